@@ -2,6 +2,7 @@ import { BrowserRouter as Router, Routes, Route } from 'react-router-dom';
 import { useLocation } from 'react-router-dom';
 
 import Login from './pages/Login';
+import LoginTwoFactor from './pages/LoginTwoFactor';
 import Register from './pages/Register';
 import Notes from './pages/Notes';
 import UserMenu from './components/UserMenu';
@@ -18,13 +19,14 @@ import './app.css';
 // Wrapper to conditionally show UserMenu
 const AppContent = () => {
   const location = useLocation();
-  const hideUserMenu = location.pathname === '/login' || location.pathname === '/register';
+  const hideUserMenu = location.pathname === '/login' || location.pathname === '/login/2fa' || location.pathname === '/register';
 
   return (
     <div>
       {/* {!hideUserMenu && <UserMenu />} */}
       <Routes>
         <Route path="/login" element={<Login/>}></Route>
+        <Route path="/login/2fa" element={<LoginTwoFactor/>}></Route>
         <Route path="/register" element={<Register/>}></Route>
         <Route path="/profile" element={<SidebarLayout><Profile /></SidebarLayout>} />
         <Route path="/change-password" element={<SidebarLayout><ChangePassword /></SidebarLayout>} />
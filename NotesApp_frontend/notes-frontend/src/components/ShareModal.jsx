@@ -18,7 +18,7 @@ const ShareModal = ({ isOpen, onClose, collaborators, onAdd, onRemove, isOwner,
     setSearching(true);
     setSearchError("");
     searchUsers(username)
-      .then(users => setUserResults(users || []))
+      .then(result => setUserResults(result.users || []))
       .catch(() => {
         setUserResults([]);
         setSearchError("No users found");
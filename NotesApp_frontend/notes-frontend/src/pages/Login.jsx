@@ -11,7 +11,11 @@ function Login() {
   const handleSubmit = async (e) => {
     e.preventDefault();
     try {
-      await AuthService.login(username, password);
+      const result = await AuthService.login(username, password);
+      if (result.twoFactorRequired) {
+        navigate("/login/2fa", { state: { pendingToken: result.pendingToken } });
+        return;
+      }
       const profile = await AuthService.getProfile();
       alert(`Welcome ${profile.username}!`);
       navigate("/dashboard"); // or wherever you want to go
@@ -0,0 +1,89 @@
+import { useEffect, useState } from "react";
+import AuthService from "../services/AuthService";
+import styles from "./AuthForm.module.css";
+import { useLocation, useNavigate } from "react-router-dom";
+
+// The password login passes the pending token in the navigation state, the SSO
+// callback redirects here with #pendingToken=...&returnTo=...
+function readPendingLogin(location) {
+  const hash = new URLSearchParams(location.hash.replace(/^#/, ""));
+  const pendingToken = location.state?.pendingToken || hash.get("pendingToken") || "";
+  let returnTo = hash.get("returnTo") || "/dashboard";
+  if (!returnTo.startsWith("/") || returnTo.startsWith("//")) {
+    returnTo = "/dashboard";
+  }
+  return { pendingToken, returnTo };
+}
+
+function LoginTwoFactor() {
+  const location = useLocation();
+  const [{ pendingToken, returnTo }] = useState(() => readPendingLogin(location));
+  const [code, setCode] = useState("");
+  const navigate = useNavigate();
+
+  // Keep the token out of the address bar and history once it's read
+  useEffect(() => {
+    if (location.hash) {
+      navigate(location.pathname, { replace: true });
+    }
+  }, []);
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    try {
+      await AuthService.loginTwoFactor(pendingToken, code);
+      const profile = await AuthService.getProfile();
+      alert(`Welcome ${profile.username}!`);
+      navigate(returnTo, { replace: true });
+    } catch (error) {
+      const message = error.response?.data?.error || "Invalid code!";
+      alert(message);
+      setCode("");
+      // The pending login is gone once it expired or had too many wrong codes
+      if (error.response?.status !== 429 && /sign in again/.test(message)) {
+        navigate("/login", { replace: true });
+      }
+    }
+  };
+
+  if (!pendingToken) {
+    return (
+      <div className={styles.pageWrapper}>
+        <div className={styles.container}>
+          <h2 className={styles.title}>Login expired</h2>
+          <button className={styles.button} type="button" onClick={() => navigate("/login")}>
+            Back to login
+          </button>
+        </div>
+      </div>
+    );
+  }
+
+  return (
+    <div className={styles.pageWrapper}>
+      <div className={styles.container}>
+        <h2 className={styles.title}>Two-factor authentication</h2>
+        <form onSubmit={handleSubmit}>
+          <div className={styles.formGroup}>
+            <label className={styles.label}>Authenticator or recovery code:</label>
+            <input
+              className={styles.input}
+              type="text"
+              autoComplete="one-time-code"
+              value={code}
+              onChange={(e) => setCode(e.target.value)}
+              autoFocus
+              required
+            />
+          </div>
+
+          <button className={styles.button} type="submit">
+            Verify
+          </button>
+        </form>
+      </div>
+    </div>
+  );
+}
+
+export default LoginTwoFactor;
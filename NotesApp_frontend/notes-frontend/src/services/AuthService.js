@@ -1,11 +1,23 @@
 import axios from '../api/axios';
 
 const AuthService = {
+  // Resolves to { twoFactorRequired, pendingToken } when the account uses 2FA,
+  // finish those logins with loginTwoFactor
   login: async (username, password) => {
     const response = await axios.post('/auth/login', { username, password });
     return response.data;
   },
 
+  // code is an authenticator code, anything that isn't 6 digits is sent as a recovery code
+  loginTwoFactor: async (pendingToken, code) => {
+    const trimmed = code.trim();
+    const body = /^\d{6}$/.test(trimmed)
+      ? { pendingToken, code: trimmed }
+      : { pendingToken, recoveryCode: trimmed };
+    const response = await axios.post('/auth/login/2fa', body);
+    return response.data;
+  },
+
   register: async (userData) => {
     const response = await axios.post('/auth/register', userData);
     return response.data;
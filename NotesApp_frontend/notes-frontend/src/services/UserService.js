@@ -1,6 +1,7 @@
 import axios from '../api/axios';
 
-export const searchUsers = async (query) => {
-  const response = await axios.get(`/auth/search-users?query=${encodeURIComponent(query)}`);
+// Resolves to one page of results: { users, page, limit, hasMore }
+export const searchUsers = async (query, page = 1) => {
+  const response = await axios.get(`/auth/search-users?query=${encodeURIComponent(query)}&page=${page}`);
   return response.data;
 };
@@ -0,0 +1,42 @@
+package config
+
+import (
+    "os"
+    "strconv"
+    "time"
+)
+
+// GetEnv returns the value of an environment variable or fallback if it is unset
+func GetEnv(key, fallback string) string {
+    if value := os.Getenv(key); value != "" {
+        return value
+    }
+    return fallback
+}
+
+// GetEnvInt returns an integer environment variable or fallback if it is unset or invalid
+func GetEnvInt(key string, fallback int) int {
+    value, err := strconv.Atoi(os.Getenv(key))
+    if err != nil {
+        return fallback
+    }
+    return value
+}
+
+// GetEnvBool returns a boolean environment variable or fallback if it is unset or invalid
+func GetEnvBool(key string, fallback bool) bool {
+    value, err := strconv.ParseBool(os.Getenv(key))
+    if err != nil {
+        return fallback
+    }
+    return value
+}
+
+// GetEnvDuration parses durations like "30s" or "24h", falling back if unset or invalid
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+    value, err := time.ParseDuration(os.Getenv(key))
+    if err != nil || value <= 0 {
+        return fallback
+    }
+    return value
+}
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+    "bytes"
+    "errors"
+    "log"
+    "net/http"
+    "time"
+    "notes-app/models"
+    "notes-app/services"
+    "github.com/gin-gonic/gin"
+)
+
+type AccountController struct {
+    accountService *services.AccountService
+}
+
+func NewAccountController(accountService *services.AccountService) *AccountController {
+    return &AccountController{accountService: accountService}
+}
+
+// confirmationErrors are the ways re-confirming a deletion can be refused
+var confirmationErrors = []error{
+    services.ErrPasswordIncorrect,
+    services.ErrRecentLoginRequired,
+    services.ErrTwoFactorCodeRequired,
+    services.ErrInvalidCode,
+    services.ErrInvalidRecoveryCode,
+    services.ErrCodeAlreadyUsed,
+}
+
+// Delete permanently deletes the signed in user's account and signs them out
+func (ac *AccountController) Delete(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    var req models.DeleteAccountRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    if err := ac.accountService.DeleteAccount(user.ID, req, clientInfo(c)); err != nil {
+        for _, rejected := range confirmationErrors {
+            if errors.Is(err, rejected) {
+                c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+                return
+            }
+        }
+        log.Println("Failed to delete account of user", user.ID.Hex(), err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+        return
+    }
+
+    c.SetCookie("sessionId", "", -1, "/", "", true, true)
+    c.SetCookie("refreshToken", "", -1, "/api/auth", "", true, true)
+    c.JSON(http.StatusOK, gin.H{"message": "Account deleted"})
+}
+
+// Export downloads a zip archive of everything stored about the user
+func (ac *AccountController) Export(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    // Built in memory first so a failure can still be reported as an error
+    var archive bytes.Buffer
+    if err := ac.accountService.Export(user.ID, clientInfo(c), &archive); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    filename := "notes-export-" + user.Username + "-" + time.Now().Format("2006-01-02") + ".zip"
+    c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+    c.Data(http.StatusOK, "application/zip", archive.Bytes())
+}
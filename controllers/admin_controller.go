@@ -0,0 +1,185 @@
+package controllers
+
+import (
+    "net/http"
+    "notes-app/models"
+    "notes-app/services"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AdminController struct {
+    adminService *services.AdminService
+    authService  *services.AuthService
+}
+
+func NewAdminController(adminService *services.AdminService, authService *services.AuthService) *AdminController {
+    return &AdminController{adminService: adminService, authService: authService}
+}
+
+// ListUsers returns users, optionally filtered with ?q= on name, username or email
+func (ac *AdminController) ListUsers(c *gin.Context) {
+    page, limit, ok := pagination(c)
+    if !ok {
+        return
+    }
+
+    users, err := ac.adminService.ListUsers(c.Query("q"), page, limit)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, users)
+}
+
+func (ac *AdminController) GetStats(c *gin.Context) {
+    stats, err := ac.adminService.GetStats()
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, stats)
+}
+
+func (ac *AdminController) SetRole(c *gin.Context) {
+    admin, userID, ok := adminParams(c)
+    if !ok {
+        return
+    }
+
+    var req models.SetRoleRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    if err := ac.adminService.SetRole(admin.ID, userID, req.Role, clientInfo(c)); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Role updated"})
+}
+
+func (ac *AdminController) Disable(c *gin.Context) {
+    ac.setDisabled(c, true)
+}
+
+func (ac *AdminController) Enable(c *gin.Context) {
+    ac.setDisabled(c, false)
+}
+
+func (ac *AdminController) setDisabled(c *gin.Context, disabled bool) {
+    admin, userID, ok := adminParams(c)
+    if !ok {
+        return
+    }
+
+    if err := ac.adminService.SetDisabled(admin.ID, userID, disabled, clientInfo(c)); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    if disabled {
+        c.JSON(http.StatusOK, gin.H{"message": "User disabled"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "User enabled"})
+}
+
+// ForcePasswordReset signs the user out and emails them a reset link they must use before signing in
+func (ac *AdminController) ForcePasswordReset(c *gin.Context) {
+    admin, userID, ok := adminParams(c)
+    if !ok {
+        return
+    }
+
+    if err := ac.adminService.ForcePasswordReset(admin.ID, userID, clientInfo(c)); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Password reset email sent"})
+}
+
+func (ac *AdminController) RevokeSessions(c *gin.Context) {
+    admin, userID, ok := adminParams(c)
+    if !ok {
+        return
+    }
+
+    revoked, err := ac.adminService.RevokeSessions(admin.ID, userID, clientInfo(c))
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"revoked": revoked})
+}
+
+// UnlockUser lifts a login lockout
+func (ac *AdminController) UnlockUser(c *gin.Context) {
+    admin, userID, ok := adminParams(c)
+    if !ok {
+        return
+    }
+
+    if err := ac.authService.UnlockUser(admin.ID, userID, clientInfo(c)); err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "User unlocked"})
+}
+
+// TransferNotes gives all notes of a departed user to another user
+func (ac *AdminController) TransferNotes(c *gin.Context) {
+    admin, userID, ok := adminParams(c)
+    if !ok {
+        return
+    }
+
+    var req models.TransferNotesRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    transferred, err := ac.adminService.TransferNotes(admin.ID, userID, req.ToUsername, clientInfo(c))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"transferred": transferred})
+}
+
+// DeleteNotes permanently deletes all notes of a user
+func (ac *AdminController) DeleteNotes(c *gin.Context) {
+    admin, userID, ok := adminParams(c)
+    if !ok {
+        return
+    }
+
+    deleted, err := ac.adminService.DeleteNotes(admin.ID, userID, clientInfo(c))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+// adminParams returns the admin making the request and the user ID from the path.
+// It writes a 400 response and returns false if the ID is invalid.
+func adminParams(c *gin.Context) (*models.User, primitive.ObjectID, bool) {
+    admin := c.MustGet("user").(*models.User)
+    userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+        return nil, primitive.NilObjectID, false
+    }
+    return admin, userID, true
+}
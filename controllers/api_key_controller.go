@@ -0,0 +1,70 @@
+package controllers
+
+import (
+    "net/http"
+    "notes-app/models"
+    "notes-app/services"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type APIKeyController struct {
+    apiKeyService *services.APIKeyService
+}
+
+func NewAPIKeyController(apiKeyService *services.APIKeyService) *APIKeyController {
+    return &APIKeyController{apiKeyService: apiKeyService}
+}
+
+func (kc *APIKeyController) GetAll(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    keys, err := kc.apiKeyService.GetAPIKeys(user.ID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, keys)
+}
+
+// Create generates an API key, the response is the only time the key is shown
+func (kc *APIKeyController) Create(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    var req models.APIKeyRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    key, err := kc.apiKeyService.CreateAPIKey(user.ID, req, clientInfo(c))
+    if err == services.ErrEmailNotVerified {
+        c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusCreated, key)
+}
+
+func (kc *APIKeyController) Revoke(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    keyID, err := primitive.ObjectIDFromHex(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+        return
+    }
+
+    err = kc.apiKeyService.RevokeAPIKey(keyID, user.ID, clientInfo(c))
+    if err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
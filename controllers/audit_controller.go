@@ -0,0 +1,75 @@
+package controllers
+
+import (
+    "net/http"
+    "time"
+    "notes-app/models"
+    "notes-app/services"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AuditController struct {
+    auditService *services.AuditService
+}
+
+func NewAuditController(auditService *services.AuditService) *AuditController {
+    return &AuditController{auditService: auditService}
+}
+
+// GetEvents returns audit events, newest first. Users only see their own events,
+// admins see everyone's and can filter by ?actorId=. Also supports ?action=,
+// ?targetId=, ?since= and ?until= (RFC 3339), ?page= and ?limit=.
+func (ac *AuditController) GetEvents(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    page, limit, ok := pagination(c)
+    if !ok {
+        return
+    }
+    query := models.AuditQuery{
+        Action:   c.Query("action"),
+        TargetID: c.Query("targetId"),
+        Page:     page,
+        Limit:    limit,
+    }
+
+    if c.Query("since") != "" {
+        since, err := time.Parse(time.RFC3339, c.Query("since"))
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since time, expected RFC 3339"})
+            return
+        }
+        query.Since = &since
+    }
+    if c.Query("until") != "" {
+        until, err := time.Parse(time.RFC3339, c.Query("until"))
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid until time, expected RFC 3339"})
+            return
+        }
+        query.Until = &until
+    }
+
+    var events []models.AuditEvent
+    var err error
+    if user.IsAdmin() {
+        if c.Query("actorId") != "" {
+            actorID, err := primitive.ObjectIDFromHex(c.Query("actorId"))
+            if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid actor ID"})
+                return
+            }
+            query.ActorID = &actorID
+        }
+        events, err = ac.auditService.GetAllEvents(query)
+    } else {
+        events, err = ac.auditService.GetUserEvents(user.ID, query)
+    }
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, events)
+}
@@ -1,7 +1,12 @@
 package controllers
 
 import (
+    "errors"
+    "math"
     "net/http"
+    "strconv"
+    "strings"
+    "notes-app/middleware"
     "notes-app/models"
     "notes-app/services"
     "github.com/gin-gonic/gin"
@@ -38,28 +43,111 @@ func (ac *AuthController) Login(c *gin.Context) {
         return
     }
 
-    response, err := ac.authService.Login(req)
+    response, err := ac.authService.Login(req, clientInfo(c))
+    if loginThrottled(c, err) {
+        return
+    }
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+        return
+    }
+
+    // No session yet, the client has to send a code to /login/2fa
+    if response.TwoFactorRequired {
+        c.JSON(http.StatusOK, gin.H{"twoFactorRequired": true, "pendingToken": response.PendingToken})
+        return
+    }
+
+    setSessionCookies(c, ac.authService, response)
+
+    if req.ReturnToken {
+        c.JSON(http.StatusOK, response)
+        return
+    }
+    c.JSON(http.StatusOK, response.User)
+}
+
+// LoginTwoFactor completes a login that requires a second factor. Set returnToken
+// in the query (?returnToken=true) to get the session ID in the body.
+func (ac *AuthController) LoginTwoFactor(c *gin.Context) {
+    var req models.TwoFactorLoginRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    response, err := ac.authService.CompleteTwoFactorLogin(req, clientInfo(c))
+    if loginThrottled(c, err) {
+        return
+    }
     if err != nil {
         c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
         return
     }
 
-    // Set sessionId as HTTP-only, Secure cookie
-    c.SetCookie("sessionId", response.SessionID, 24*3600, "/", "", true, true)
+    setSessionCookies(c, ac.authService, response)
 
+    if c.Query("returnToken") == "true" {
+        c.JSON(http.StatusOK, response)
+        return
+    }
     c.JSON(http.StatusOK, response.User)
 }
 
+// loginThrottled responds with 429 and Retry-After if err is a *LoginThrottledError
+func loginThrottled(c *gin.Context, err error) bool {
+    var throttled *services.LoginThrottledError
+    if !errors.As(err, &throttled) {
+        return false
+    }
+    c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+    c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+    return true
+}
+
 func (ac *AuthController) Logout(c *gin.Context) {
-    sessionID, err := c.Cookie("sessionId")
-    if err == nil && sessionID != "" {
-        ac.authService.Logout(sessionID)
-        // Clear the cookie
+    sessionID := middleware.SessionID(c)
+    refreshToken := refreshTokenFromRequest(c)
+    if sessionID != "" || refreshToken != "" {
+        ac.authService.Logout(sessionID, refreshToken, clientInfo(c))
+        // Clear the cookies
         c.SetCookie("sessionId", "", -1, "/", "", true, true)
+        c.SetCookie("refreshToken", "", -1, "/api/auth", "", true, true)
     }
     c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully!"})
 }
 
+// Refresh starts a new session from a remember-me refresh token. The token is
+// rotated, the old one can't be used again.
+func (ac *AuthController) Refresh(c *gin.Context) {
+    fromCookie := true
+    refreshToken, _ := c.Cookie("refreshToken")
+    if refreshToken == "" {
+        fromCookie = false
+        refreshToken = refreshTokenFromRequest(c)
+    }
+    if refreshToken == "" {
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "No refresh token provided"})
+        return
+    }
+
+    response, err := ac.authService.Refresh(refreshToken, clientInfo(c))
+    if err != nil {
+        c.SetCookie("refreshToken", "", -1, "/api/auth", "", true, true)
+        c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+        return
+    }
+
+    setSessionCookies(c, ac.authService, response)
+
+    // Clients that don't use cookies need the new tokens in the body
+    if !fromCookie {
+        c.JSON(http.StatusOK, response)
+        return
+    }
+    c.JSON(http.StatusOK, response.User)
+}
+
 func (ac *AuthController) GetCurrentUser(c *gin.Context) {
     user, exists := c.Get("user")
     if !exists {
@@ -67,11 +155,27 @@ func (ac *AuthController) GetCurrentUser(c *gin.Context) {
         return
     }
 
-    u := user.(*models.User)
-    profile := models.UserProfileDto{
-        ID:       u.ID.Hex(),
-        Username: u.Username,
-        Email:    u.Email,
+    c.JSON(http.StatusOK, services.UserProfile(*user.(*models.User)))
+}
+
+// UpdateProfile changes the fields sent and returns the updated profile
+func (ac *AuthController) UpdateProfile(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    var req models.UpdateProfileRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    profile, err := ac.authService.UpdateProfile(user.ID, req, clientInfo(c))
+    if err == services.ErrRecentLoginRequired {
+        c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
     }
 
     c.JSON(http.StatusOK, profile)
@@ -91,34 +195,125 @@ func (ac *AuthController) ChangePassword(c *gin.Context) {
     }
 
     u := user.(*models.User)
-    err := ac.authService.ChangePassword(u.ID, req)
+    sessionID, err := ac.authService.ChangePassword(u.ID, req, clientInfo(c))
     if err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
         return
     }
 
+    // All sessions and remember-me logins were ended, hand the caller its replacement
+    c.SetCookie("sessionId", sessionID, ac.authService.SessionCookieMaxAge(), "/", "", true, true)
+    c.SetCookie("refreshToken", "", -1, "/api/auth", "", true, true)
+    if middleware.BearerToken(c) != "" {
+        c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully.", "sessionId": sessionID})
+        return
+    }
     c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully."})
 }
 
+// ForgotPassword always answers the same way, so it can't be used to find out which emails have accounts
+func (ac *AuthController) ForgotPassword(c *gin.Context) {
+    var req models.ForgotPasswordRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    ac.authService.RequestPasswordReset(req.Email, clientInfo(c))
+
+    c.JSON(http.StatusOK, gin.H{"message": "If an account uses this email, a reset link is on its way."})
+}
+
+func (ac *AuthController) ResetPassword(c *gin.Context) {
+    var req models.ResetPasswordRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    if err := ac.authService.ResetPassword(req, clientInfo(c)); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Password reset, please sign in with your new password."})
+}
+
+func (ac *AuthController) ListSessions(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    sessions, err := ac.authService.ListSessions(user.ID, middleware.SessionID(c))
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, sessions)
+}
+
+func (ac *AuthController) RevokeSession(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    err := ac.authService.RevokeSession(user.ID, c.Param("id"), clientInfo(c))
+    if err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// RevokeOtherSessions signs the user out everywhere except the current session
+func (ac *AuthController) RevokeOtherSessions(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    revoked, err := ac.authService.RevokeOtherSessions(user.ID, middleware.SessionID(c), clientInfo(c))
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Other sessions revoked", "revoked": revoked})
+}
+
+// SearchUsers finds users by username prefix with ?query=, paginated with ?page= and ?limit=
 func (ac *AuthController) SearchUsers(c *gin.Context) {
-    query := c.Query("query")
+    user := c.MustGet("user").(*models.User)
+    query := strings.TrimSpace(c.Query("query"))
     if len(query) < 2 {
         c.JSON(http.StatusBadRequest, gin.H{"error": "Query too short"})
         return
     }
-    users, err := ac.authService.SearchUsers(query)
+    page, limit, ok := pagination(c)
+    if !ok {
+        return
+    }
+
+    result, err := ac.authService.SearchUsers(user.ID, query, page, limit)
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
-    // Only return id, username, email
-    var result []gin.H
-    for _, u := range users {
-        result = append(result, gin.H{
-            "id": u.ID.Hex(),
-            "username": u.Username,
-            "email": u.Email,
-        })
-    }
     c.JSON(http.StatusOK, result)
 }
+
+// setSessionCookies sets the session cookie and, for remember-me logins, the refresh
+// token cookie. Both are HTTP-only and Secure, the refresh token is only sent to /api/auth.
+func setSessionCookies(c *gin.Context, authService *services.AuthService, response models.LoginResponse) {
+    c.SetCookie("sessionId", response.SessionID, authService.SessionCookieMaxAge(), "/", "", true, true)
+    if response.RefreshToken != "" {
+        c.SetCookie("refreshToken", response.RefreshToken, authService.RefreshCookieMaxAge(), "/api/auth", "", true, true)
+    }
+}
+
+// refreshTokenFromRequest reads the refresh token from the cookie or the JSON body
+func refreshTokenFromRequest(c *gin.Context) string {
+    if refreshToken, err := c.Cookie("refreshToken"); err == nil && refreshToken != "" {
+        return refreshToken
+    }
+    var req models.RefreshRequest
+    if c.Request.ContentLength != 0 && c.ShouldBindJSON(&req) == nil {
+        return req.RefreshToken
+    }
+    return ""
+}
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+    "net/http"
+    "notes-app/models"
+    "notes-app/services"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type CommentController struct {
+    commentService *services.CommentService
+}
+
+func NewCommentController(commentService *services.CommentService) *CommentController {
+    return &CommentController{commentService: commentService}
+}
+
+func (cc *CommentController) GetAll(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    noteID, err := primitive.ObjectIDFromHex(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note ID"})
+        return
+    }
+
+    comments, err := cc.commentService.GetComments(noteID, user.ID)
+    if err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, comments)
+}
+
+func (cc *CommentController) Create(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    noteID, err := primitive.ObjectIDFromHex(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note ID"})
+        return
+    }
+
+    var req models.CommentRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    comment, err := cc.commentService.AddComment(noteID, user.ID, req)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusCreated, comment)
+}
+
+func (cc *CommentController) Update(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    noteID, commentID, ok := commentParams(c)
+    if !ok {
+        return
+    }
+
+    var req models.UpdateCommentRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    comment, err := cc.commentService.UpdateComment(noteID, commentID, user.ID, req)
+    if err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, comment)
+}
+
+func (cc *CommentController) Delete(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    noteID, commentID, ok := commentParams(c)
+    if !ok {
+        return
+    }
+
+    if err := cc.commentService.DeleteComment(noteID, commentID, user.ID); err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Comment deleted"})
+}
+
+func (cc *CommentController) Resolve(c *gin.Context) {
+    cc.setResolved(c, true)
+}
+
+func (cc *CommentController) Reopen(c *gin.Context) {
+    cc.setResolved(c, false)
+}
+
+func (cc *CommentController) setResolved(c *gin.Context, resolved bool) {
+    user := c.MustGet("user").(*models.User)
+
+    noteID, commentID, ok := commentParams(c)
+    if !ok {
+        return
+    }
+
+    comment, err := cc.commentService.SetResolved(noteID, commentID, user.ID, resolved)
+    if err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, comment)
+}
+
+// commentParams parses the :id and :commentId route parameters,
+// writing a 400 response and returning false if either is invalid
+func commentParams(c *gin.Context) (primitive.ObjectID, primitive.ObjectID, bool) {
+    noteID, err := primitive.ObjectIDFromHex(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note ID"})
+        return primitive.NilObjectID, primitive.NilObjectID, false
+    }
+    commentID, err := primitive.ObjectIDFromHex(c.Param("commentId"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+        return primitive.NilObjectID, primitive.NilObjectID, false
+    }
+    return noteID, commentID, true
+}
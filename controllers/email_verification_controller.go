@@ -0,0 +1,48 @@
+package controllers
+
+import (
+    "net/http"
+    "notes-app/models"
+    "notes-app/services"
+    "github.com/gin-gonic/gin"
+)
+
+type EmailVerificationController struct {
+    verificationService *services.EmailVerificationService
+}
+
+func NewEmailVerificationController(verificationService *services.EmailVerificationService) *EmailVerificationController {
+    return &EmailVerificationController{verificationService: verificationService}
+}
+
+// Verify confirms the email address with the token from the verification link
+func (vc *EmailVerificationController) Verify(c *gin.Context) {
+    var req models.VerifyEmailRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    if err := vc.verificationService.Verify(req.Token, clientInfo(c)); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+func (vc *EmailVerificationController) Resend(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    err := vc.verificationService.Resend(user.ID)
+    if err == services.ErrVerificationRateLimited {
+        c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
@@ -0,0 +1,55 @@
+package controllers
+
+import (
+    "net/http"
+    "strconv"
+    "notes-app/models"
+    "notes-app/services"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type GraphController struct {
+    graphService *services.GraphService
+}
+
+func NewGraphController(graphService *services.GraphService) *GraphController {
+    return &GraphController{graphService: graphService}
+}
+
+// GetGraph returns the note graph, optionally around ?noteId= up to ?depth= hops and filtered by ?tag=
+func (gc *GraphController) GetGraph(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    var centerID *primitive.ObjectID
+    if c.Query("noteId") != "" {
+        noteID, err := primitive.ObjectIDFromHex(c.Query("noteId"))
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note ID"})
+            return
+        }
+        centerID = &noteID
+    }
+
+    depth := 1
+    if c.Query("depth") != "" {
+        d, err := strconv.Atoi(c.Query("depth"))
+        if err != nil || d < 1 || d > services.MaxGraphDepth {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Depth must be between 1 and " + strconv.Itoa(services.MaxGraphDepth)})
+            return
+        }
+        depth = d
+    }
+
+    graph, err := gc.graphService.GetGraph(user.ID, centerID, depth, c.Query("tag"))
+    if err != nil {
+        if centerID != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, graph)
+}
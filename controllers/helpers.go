@@ -0,0 +1,42 @@
+package controllers
+
+import (
+    "net/http"
+    "strconv"
+    "notes-app/models"
+    "github.com/gin-gonic/gin"
+)
+
+// clientInfo returns the IP and user agent of the request for auditing
+func clientInfo(c *gin.Context) models.ClientInfo {
+    return models.ClientInfo{
+        IP:        c.ClientIP(),
+        UserAgent: c.Request.UserAgent(),
+    }
+}
+
+// maxPage keeps the number of skipped results computed from ?page= and ?limit= from overflowing
+const maxPage = 10000
+
+// pagination reads ?page= (default 1, max 10000) and ?limit= (default 20, max 100).
+// It writes a 400 response and returns false if either is invalid.
+func pagination(c *gin.Context) (int, int, bool) {
+    page, limit := 1, 20
+    if c.Query("page") != "" {
+        p, err := strconv.Atoi(c.Query("page"))
+        if err != nil || p < 1 || p > maxPage {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page"})
+            return 0, 0, false
+        }
+        page = p
+    }
+    if c.Query("limit") != "" {
+        l, err := strconv.Atoi(c.Query("limit"))
+        if err != nil || l < 1 || l > 100 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Limit must be between 1 and 100"})
+            return 0, 0, false
+        }
+        limit = l
+    }
+    return page, limit, true
+}
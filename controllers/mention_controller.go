@@ -0,0 +1,34 @@
+package controllers
+
+import (
+    "net/http"
+    "notes-app/models"
+    "notes-app/services"
+    "github.com/gin-gonic/gin"
+)
+
+type MentionController struct {
+    mentionService *services.MentionService
+}
+
+func NewMentionController(mentionService *services.MentionService) *MentionController {
+    return &MentionController{mentionService: mentionService}
+}
+
+// GetMentions lists notes where the user is mentioned, supports ?page= and ?limit=
+func (mc *MentionController) GetMentions(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    page, limit, ok := pagination(c)
+    if !ok {
+        return
+    }
+
+    mentions, err := mc.mentionService.GetMentions(user.ID, page, limit)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, mentions)
+}
@@ -2,20 +2,23 @@ package controllers
 
 import (
     "net/http"
+    "strconv"
+    "time"
     "notes-app/models"
     "notes-app/services"
     "notes-app/config"
     "github.com/gin-gonic/gin"
-    "go.mongodb.org/mongo-driver/bson"
     "go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type NoteController struct {
-    noteService *services.NoteService
+    noteService     *services.NoteService
+    linkService     *services.LinkService
+    activityService *services.ActivityService
 }
 
-func NewNoteController(noteService *services.NoteService) *NoteController {
-    return &NoteController{noteService: noteService}
+func NewNoteController(noteService *services.NoteService, linkService *services.LinkService, activityService *services.ActivityService) *NoteController {
+    return &NoteController{noteService: noteService, linkService: linkService, activityService: activityService}
 }
 
 func (nc *NoteController) GetAll(c *gin.Context) {
@@ -39,7 +42,7 @@ func (nc *NoteController) Create(c *gin.Context) {
         return
     }
 
-    note, err := nc.noteService.CreateNote(user.ID, req)
+    note, err := nc.noteService.CreateNote(user.ID, req, clientInfo(c))
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
@@ -81,7 +84,7 @@ func (nc *NoteController) Update(c *gin.Context) {
         return
     }
 
-    note, err := nc.noteService.UpdateNote(noteID, user.ID, req)
+    note, err := nc.noteService.UpdateNote(noteID, user.ID, req, clientInfo(c))
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
@@ -90,6 +93,37 @@ func (nc *NoteController) Update(c *gin.Context) {
     c.JSON(http.StatusOK, note)
 }
 
+func (nc *NoteController) Duplicate(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    noteID, err := primitive.ObjectIDFromHex(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note ID"})
+        return
+    }
+
+    // Body is optional, defaults to copying without collaborators
+    var req models.DuplicateNoteRequest
+    if c.Request.ContentLength > 0 {
+        if err := c.ShouldBindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+    }
+
+    note, err := nc.noteService.DuplicateNote(noteID, user.ID, req)
+    if err == services.ErrEmailNotVerified {
+        c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, note)
+}
+
 func (nc *NoteController) Delete(c *gin.Context) {
     user := c.MustGet("user").(*models.User)
     
@@ -189,7 +223,7 @@ func (nc *NoteController) RestoreVersion(c *gin.Context) {
         return
     }
 
-    note, err := nc.noteService.RestoreVersion(noteID, versionID, user.ID)
+    note, err := nc.noteService.RestoreVersion(noteID, versionID, user.ID, clientInfo(c))
     if err != nil {
         c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
         return
@@ -231,7 +265,7 @@ func (nc *NoteController) AutoSave(c *gin.Context) {
         return
     }
 
-    note, err := nc.noteService.AutoSaveNote(noteID, user.ID, req)
+    note, err := nc.noteService.AutoSaveNote(noteID, user.ID, req, clientInfo(c))
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
@@ -240,6 +274,94 @@ func (nc *NoteController) AutoSave(c *gin.Context) {
     c.JSON(http.StatusOK, note)
 }
 
+// GetUpcoming returns notes with a reminder or due date in the next ?days= days (default 7)
+func (nc *NoteController) GetUpcoming(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    days := 7
+    if c.Query("days") != "" {
+        d, err := strconv.Atoi(c.Query("days"))
+        if err != nil || d < 1 || d > 365 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Days must be between 1 and 365"})
+            return
+        }
+        days = d
+    }
+
+    notes, err := nc.noteService.GetUpcomingNotes(user.ID, time.Duration(days)*24*time.Hour)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, notes)
+}
+
+// GetOverdue returns notes whose due date has passed
+func (nc *NoteController) GetOverdue(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    notes, err := nc.noteService.GetOverdueNotes(user.ID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, notes)
+}
+
+// GetActivity returns the note's activity timeline, newest first, paginated with ?page= and ?limit=
+func (nc *NoteController) GetActivity(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+    noteID, err := primitive.ObjectIDFromHex(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note ID"})
+        return
+    }
+    page, limit, ok := pagination(c)
+    if !ok {
+        return
+    }
+    activity, err := nc.activityService.GetTimeline(noteID, user.ID, page, limit)
+    if err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, activity)
+}
+
+// GetBacklinks returns the notes linking to a note
+func (nc *NoteController) GetBacklinks(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+    noteID, err := primitive.ObjectIDFromHex(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note ID"})
+        return
+    }
+    links, err := nc.linkService.GetBacklinks(noteID, user.ID)
+    if err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, links)
+}
+
+// GetOutlinks returns the notes a note links to
+func (nc *NoteController) GetOutlinks(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+    noteID, err := primitive.ObjectIDFromHex(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note ID"})
+        return
+    }
+    links, err := nc.linkService.GetOutlinks(noteID, user.ID)
+    if err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, links)
+}
+
 // ShareNote adds a collaborator (only owner)
 func (nc *NoteController) ShareNote(c *gin.Context) {
     user := c.MustGet("user").(*models.User)
@@ -255,7 +377,7 @@ func (nc *NoteController) ShareNote(c *gin.Context) {
     }
     // Find collaborator user by username
     var collab models.User
-    err = config.DB.Collection("users").FindOne(c, bson.M{"username": req.Username}).Decode(&collab)
+    err = config.DB.Collection("users").FindOne(c, services.UsernameFilter(req.Username)).Decode(&collab)
     if err != nil {
         c.JSON(http.StatusNotFound, gin.H{"error": "Collaborator not found"})
         return
@@ -264,7 +386,7 @@ func (nc *NoteController) ShareNote(c *gin.Context) {
         c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot add yourself as collaborator"})
         return
     }
-    err = nc.noteService.AddCollaborator(noteID, user.ID, collab.ID)
+    err = nc.noteService.AddCollaborator(noteID, user.ID, collab.ID, req.Role, clientInfo(c))
     if err != nil {
         c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
         return
@@ -287,12 +409,12 @@ func (nc *NoteController) RemoveCollaborator(c *gin.Context) {
     }
     // Find collaborator user by username
     var collab models.User
-    err = config.DB.Collection("users").FindOne(c, bson.M{"username": req.Username}).Decode(&collab)
+    err = config.DB.Collection("users").FindOne(c, services.UsernameFilter(req.Username)).Decode(&collab)
     if err != nil {
         c.JSON(http.StatusNotFound, gin.H{"error": "Collaborator not found"})
         return
     }
-    err = nc.noteService.RemoveCollaborator(noteID, user.ID, collab.ID)
+    err = nc.noteService.RemoveCollaborator(noteID, user.ID, collab.ID, clientInfo(c))
     if err != nil {
         c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
         return
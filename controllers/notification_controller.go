@@ -0,0 +1,107 @@
+package controllers
+
+import (
+    "net/http"
+    "notes-app/models"
+    "notes-app/services"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type NotificationController struct {
+    notificationService *services.NotificationService
+}
+
+func NewNotificationController(notificationService *services.NotificationService) *NotificationController {
+    return &NotificationController{notificationService: notificationService}
+}
+
+// GetAll returns the user's notifications, supports ?unread=true, ?page= and ?limit=
+func (nc *NotificationController) GetAll(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    page, limit, ok := pagination(c)
+    if !ok {
+        return
+    }
+
+    notifications, err := nc.notificationService.GetNotifications(user.ID, c.Query("unread") == "true", page, limit)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, notifications)
+}
+
+func (nc *NotificationController) UnreadCount(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    count, err := nc.notificationService.GetUnreadCount(user.ID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"count": count})
+}
+
+func (nc *NotificationController) MarkRead(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    notificationID, err := primitive.ObjectIDFromHex(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+        return
+    }
+
+    err = nc.notificationService.MarkRead(notificationID, user.ID)
+    if err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+}
+
+func (nc *NotificationController) MarkAllRead(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    err := nc.notificationService.MarkAllRead(user.ID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "All notifications marked as read"})
+}
+
+func (nc *NotificationController) GetPreferences(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    prefs, err := nc.notificationService.GetPreferences(user.ID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, prefs)
+}
+
+func (nc *NotificationController) UpdatePreferences(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    var req models.NotificationPreferencesRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    prefs, err := nc.notificationService.UpdatePreferences(user.ID, req)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, prefs)
+}
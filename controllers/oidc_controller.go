@@ -0,0 +1,129 @@
+package controllers
+
+import (
+    "errors"
+    "log"
+    "net/http"
+    "net/url"
+    "notes-app/config"
+    "notes-app/models"
+    "notes-app/services"
+    "github.com/gin-gonic/gin"
+)
+
+type OIDCController struct {
+    oidcService *services.OIDCService
+    authService *services.AuthService
+}
+
+func NewOIDCController(oidcService *services.OIDCService, authService *services.AuthService) *OIDCController {
+    return &OIDCController{oidcService: oidcService, authService: authService}
+}
+
+// Login redirects the browser to the identity provider. ?returnTo=/notes/123 picks
+// where in the app to end up after signing in.
+func (oc *OIDCController) Login(c *gin.Context) {
+    authURL, state, err := oc.oidcService.StartLogin(c.Query("returnTo"))
+    if err == services.ErrOIDCDisabled {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+        return
+    }
+
+    // Ties the callback to this browser, so a login can't be started for someone else
+    c.SetCookie("oidcState", state, int(10*60), "/api/auth/oidc", "", true, true)
+    c.Redirect(http.StatusFound, authURL)
+}
+
+// Reauthenticate sends a signed in user to the provider to sign in again, which confirms
+// sensitive changes to accounts without a password. ?returnTo= works as for Login.
+func (oc *OIDCController) Reauthenticate(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    authURL, state, err := oc.oidcService.StartReauth(user.ID, c.Query("returnTo"))
+    if err == services.ErrOIDCDisabled {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.SetCookie("oidcState", state, int(10*60), "/api/auth/oidc", "", true, true)
+    c.Redirect(http.StatusFound, authURL)
+}
+
+// oidcUserErrors are the sign-in failures whose message is shown on the login page
+var oidcUserErrors = []error{
+    services.ErrOIDCLoginExpired,
+    services.ErrOIDCNoEmail,
+    services.ErrOIDCEmailNotVerified,
+    services.ErrOIDCDomainNotAllowed,
+    services.ErrOIDCAccountExists,
+    services.ErrOIDCAccountNotLinked,
+    services.ErrOIDCReauthMismatch,
+    services.ErrOIDCReauthNotFresh,
+    services.ErrAccountDisabled,
+    services.ErrPasswordResetRequired,
+}
+
+// oidcErrorMessage is what the login page is told about a failed sign-in. Other errors
+// can carry provider responses or internal details, they're only logged.
+func oidcErrorMessage(err error) string {
+    for _, known := range oidcUserErrors {
+        if errors.Is(err, known) {
+            return known.Error()
+        }
+    }
+    return "Sign-in failed, please try again"
+}
+
+// Callback finishes the login the provider redirected back from and sends the
+// browser to the app, with ?error= on the login page if it failed. Accounts with
+// 2FA are sent to /login/2fa with the pending token in the fragment, which isn't
+// sent to servers, to finish the login with a code.
+func (oc *OIDCController) Callback(c *gin.Context) {
+    appURL := config.GetEnv("APP_BASE_URL", "http://localhost:5173")
+    fail := func(message string) {
+        c.Redirect(http.StatusFound, appURL+"/login?error="+url.QueryEscape(message))
+    }
+
+    cookieState, _ := c.Cookie("oidcState")
+    c.SetCookie("oidcState", "", -1, "/api/auth/oidc", "", true, true)
+
+    if providerError := c.Query("error"); providerError != "" {
+        fail("Sign-in was cancelled or refused by the provider")
+        return
+    }
+    state := c.Query("state")
+    if state == "" || state != cookieState || c.Query("code") == "" {
+        fail("Sign-in expired, please try again")
+        return
+    }
+
+    response, returnTo, err := oc.oidcService.CompleteLogin(state, c.Query("code"), clientInfo(c))
+    if err != nil {
+        log.Println("OIDC login failed:", err)
+        fail(oidcErrorMessage(err))
+        return
+    }
+
+    // A confirming sign-in leaves the current session as it is
+    if response.Reauthenticated {
+        c.Redirect(http.StatusFound, appURL+returnTo)
+        return
+    }
+
+    if response.TwoFactorRequired {
+        fragment := url.Values{"pendingToken": {response.PendingToken}, "returnTo": {returnTo}}
+        c.Redirect(http.StatusFound, appURL+"/login/2fa#"+fragment.Encode())
+        return
+    }
+
+    setSessionCookies(c, oc.authService, response)
+    c.Redirect(http.StatusFound, appURL+returnTo)
+}
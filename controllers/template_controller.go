@@ -0,0 +1,111 @@
+package controllers
+
+import (
+    "net/http"
+    "notes-app/models"
+    "notes-app/services"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TemplateController struct {
+    templateService *services.TemplateService
+}
+
+func NewTemplateController(templateService *services.TemplateService) *TemplateController {
+    return &TemplateController{templateService: templateService}
+}
+
+func (tc *TemplateController) GetAll(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    templates, err := tc.templateService.GetTemplates(user.ID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, templates)
+}
+
+func (tc *TemplateController) Create(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    var req models.TemplateRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    template, err := tc.templateService.CreateTemplate(user.ID, req)
+    if err == services.ErrEmailNotVerified {
+        c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, template)
+}
+
+func (tc *TemplateController) GetTemplate(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    templateID, err := primitive.ObjectIDFromHex(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+        return
+    }
+
+    template, err := tc.templateService.GetTemplate(templateID, user.ID)
+    if err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, template)
+}
+
+func (tc *TemplateController) Update(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    templateID, err := primitive.ObjectIDFromHex(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+        return
+    }
+
+    var req models.TemplateRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    template, err := tc.templateService.UpdateTemplate(templateID, user.ID, req)
+    if err != nil {
+        c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, template)
+}
+
+func (tc *TemplateController) Delete(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    templateID, err := primitive.ObjectIDFromHex(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+        return
+    }
+
+    err = tc.templateService.DeleteTemplate(templateID, user.ID)
+    if err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Template deleted successfully"})
+}
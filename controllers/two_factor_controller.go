@@ -0,0 +1,90 @@
+package controllers
+
+import (
+    "net/http"
+    "notes-app/models"
+    "notes-app/services"
+    "github.com/gin-gonic/gin"
+)
+
+type TwoFactorController struct {
+    twoFactorService *services.TwoFactorService
+}
+
+func NewTwoFactorController(twoFactorService *services.TwoFactorService) *TwoFactorController {
+    return &TwoFactorController{twoFactorService: twoFactorService}
+}
+
+// Setup returns a new secret and its otpauth:// URI for the user to scan
+func (tc *TwoFactorController) Setup(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    setup, err := tc.twoFactorService.Setup(user.ID)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, setup)
+}
+
+// Enable confirms setup with a code and returns the recovery codes
+func (tc *TwoFactorController) Enable(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    var req models.TOTPCodeRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    codes, err := tc.twoFactorService.Enable(user.ID, req.Code, clientInfo(c))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (tc *TwoFactorController) Disable(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    var req models.ReauthRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    err := tc.twoFactorService.Disable(user.ID, req, clientInfo(c))
+    if loginThrottled(c, err) {
+        return
+    }
+    if err != nil {
+        c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+func (tc *TwoFactorController) RegenerateRecoveryCodes(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    var req models.ReauthRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    codes, err := tc.twoFactorService.RegenerateRecoveryCodes(user.ID, req, clientInfo(c))
+    if loginThrottled(c, err) {
+        return
+    }
+    if err != nil {
+        c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
@@ -0,0 +1,116 @@
+package controllers
+
+import (
+    "net/http"
+    "notes-app/models"
+    "notes-app/services"
+    "github.com/gin-gonic/gin"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type WebhookController struct {
+    webhookService *services.WebhookService
+}
+
+func NewWebhookController(webhookService *services.WebhookService) *WebhookController {
+    return &WebhookController{webhookService: webhookService}
+}
+
+func (wc *WebhookController) GetAll(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    webhooks, err := wc.webhookService.GetWebhooks(user.ID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, webhooks)
+}
+
+// Create registers a webhook, the response contains the signing secret
+func (wc *WebhookController) Create(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    var req models.WebhookRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    webhook, err := wc.webhookService.CreateWebhook(user.ID, req)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, webhook)
+}
+
+func (wc *WebhookController) Delete(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    webhookID, err := primitive.ObjectIDFromHex(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+        return
+    }
+
+    err = wc.webhookService.DeleteWebhook(webhookID, user.ID)
+    if err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+// GetDeliveries returns the delivery log of a webhook
+func (wc *WebhookController) GetDeliveries(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    webhookID, err := primitive.ObjectIDFromHex(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+        return
+    }
+
+    deliveries, err := wc.webhookService.GetDeliveries(webhookID, user.ID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, deliveries)
+}
+
+// GetDeadLetters returns deliveries that exhausted their retries
+func (wc *WebhookController) GetDeadLetters(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    deliveries, err := wc.webhookService.GetDeadLetters(user.ID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, deliveries)
+}
+
+func (wc *WebhookController) Redeliver(c *gin.Context) {
+    user := c.MustGet("user").(*models.User)
+
+    deliveryID, err := primitive.ObjectIDFromHex(c.Param("deliveryId"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery ID"})
+        return
+    }
+
+    err = wc.webhookService.Redeliver(deliveryID, user.ID)
+    if err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Delivery queued"})
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+    "context"
     "log"
+    "net/http"
+    "strings"
+    "time"
     "notes-app/config"
     "notes-app/controllers"
     "notes-app/middleware"
@@ -19,51 +23,245 @@ func main() {
     config.ConnectMongoDB()
     config.ConnectRedis()
 
-    authService := services.NewAuthService()
-    noteService := services.NewNoteService()
+    auditService := services.NewAuditService()
+    sessionService := services.NewSessionService()
+    loginGuard := services.NewLoginGuard(services.NewRedisAttemptStore())
+    twoFactorService := services.NewTwoFactorService(auditService, loginGuard)
+    mailer := services.NewMailer()
+    verificationService := services.NewEmailVerificationService(auditService, mailer)
+    authService := services.NewAuthService(auditService, sessionService, twoFactorService, verificationService, loginGuard, mailer)
+    apiKeyService := services.NewAPIKeyService(auditService)
+    oidcService := services.NewOIDCService(authService, auditService, &http.Client{Timeout: 10 * time.Second})
+    templateService := services.NewTemplateService(authService)
+    linkService := services.NewLinkService()
+    notificationService := services.NewNotificationService()
+    reminderService := services.NewReminderService(notificationService)
+    digestService := services.NewDigestService(mailer)
+    webhookService := services.NewWebhookService(services.NewWebhookClient(10 * time.Second))
+    activityService := services.NewActivityService()
+    mentionService := services.NewMentionService(authService)
+    noteService := services.NewNoteService(templateService, linkService, reminderService, notificationService, webhookService, auditService, activityService, mentionService)
+    graphService := services.NewGraphService()
+    adminService := services.NewAdminService(authService, sessionService, noteService, auditService)
+    commentService := services.NewCommentService(authService, notificationService, activityService)
+    accountService := services.NewAccountService(sessionService, twoFactorService, noteService, commentService, auditService)
+
+    if err := linkService.EnsureIndexes(); err != nil {
+        log.Println("Failed to create link indexes:", err)
+    }
+    if err := notificationService.EnsureIndexes(); err != nil {
+        log.Println("Failed to create notification indexes:", err)
+    }
+    if err := webhookService.EnsureIndexes(); err != nil {
+        log.Println("Failed to create webhook indexes:", err)
+    }
+    if err := auditService.EnsureIndexes(); err != nil {
+        log.Println("Failed to create audit indexes:", err)
+    }
+    if err := activityService.EnsureIndexes(); err != nil {
+        log.Println("Failed to create activity indexes:", err)
+    }
+    if err := commentService.EnsureIndexes(); err != nil {
+        log.Println("Failed to create comment indexes:", err)
+    }
+    if err := mentionService.EnsureIndexes(); err != nil {
+        log.Println("Failed to create mention indexes:", err)
+    }
+    if err := apiKeyService.EnsureIndexes(); err != nil {
+        log.Println("Failed to create API key indexes:", err)
+    }
+    if err := authService.EnsureIndexes(); err != nil {
+        log.Println("Failed to create unique username index:", err)
+    }
+    if err := oidcService.EnsureIndexes(); err != nil {
+        log.Println("Failed to create identity indexes:", err)
+    }
+    if err := verificationService.BackfillExisting(); err != nil {
+        log.Println("Failed to backfill email verification:", err)
+    }
+    if admins := config.GetEnv("ADMIN_USERNAMES", ""); admins != "" {
+        if err := authService.PromoteAdmins(strings.Split(admins, ",")); err != nil {
+            log.Println("Failed to promote admins:", err)
+        }
+    }
+
+    go reminderService.Start(context.Background())
+    go digestService.Start(context.Background())
+    go webhookService.Start(context.Background())
 
     authController := controllers.NewAuthController(authService)
-    noteController := controllers.NewNoteController(noteService)
+    noteController := controllers.NewNoteController(noteService, linkService, activityService)
+    templateController := controllers.NewTemplateController(templateService)
+    graphController := controllers.NewGraphController(graphService)
+    notificationController := controllers.NewNotificationController(notificationService)
+    webhookController := controllers.NewWebhookController(webhookService)
+    auditController := controllers.NewAuditController(auditService)
+    commentController := controllers.NewCommentController(commentService)
+    mentionController := controllers.NewMentionController(mentionService)
+    apiKeyController := controllers.NewAPIKeyController(apiKeyService)
+    twoFactorController := controllers.NewTwoFactorController(twoFactorService)
+    verificationController := controllers.NewEmailVerificationController(verificationService)
+    adminController := controllers.NewAdminController(adminService, authService)
+    oidcController := controllers.NewOIDCController(oidcService, authService)
+    accountController := controllers.NewAccountController(accountService)
 
     router := gin.Default()
 
+    // ClientIP only believes X-Forwarded-For from these proxies, otherwise anyone could pick
+    // the IP that rate limits, login throttling and the audit log see.
+    // TRUSTED_PROXIES is a comma separated list of IPs or CIDRs, none by default.
+    var trustedProxies []string
+    if proxies := config.GetEnv("TRUSTED_PROXIES", ""); proxies != "" {
+        for _, proxy := range strings.Split(proxies, ",") {
+            trustedProxies = append(trustedProxies, strings.TrimSpace(proxy))
+        }
+    }
+    if err := router.SetTrustedProxies(trustedProxies); err != nil {
+        log.Fatal("Invalid TRUSTED_PROXIES:", err)
+    }
+
     corsConfig := cors.DefaultConfig()
     corsConfig.AllowOrigins = []string{"http://localhost:5173"}
     corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
     corsConfig.AllowHeaders = []string{"Content-Type", "X-Requested-With", "Authorization"} // Explicitly allow required headers
+    corsConfig.ExposeHeaders = []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"}
     corsConfig.AllowCredentials = true
     router.Use(cors.New(corsConfig))
 
+    rateLimiter := services.NewRateLimiter()
+    authLimit := services.RateLimitFromEnv("auth", 20, time.Minute)
+    searchLimit := services.RateLimitFromEnv("search", 30, time.Minute)
+    apiLimit := services.RateLimitFromEnv("api", 300, time.Minute)
+    noteCreateLimit := services.RateLimitFromEnv("note_create", 30, time.Minute)
+    autosaveLimit := services.RateLimitFromEnv("autosave", 120, time.Minute)
+    exportLimit := services.RateLimitFromEnv("export", 5, time.Hour)
+
     authRoutes := router.Group("/api/auth")
     {
-        authRoutes.POST("/register", authController.Register)
-        authRoutes.POST("/login", authController.Login)
+        authRoutes.POST("/register", middleware.RateLimit(rateLimiter, authLimit), authController.Register)
+        authRoutes.POST("/login", middleware.RateLimit(rateLimiter, authLimit), authController.Login)
+        authRoutes.POST("/login/2fa", middleware.RateLimit(rateLimiter, authLimit), authController.LoginTwoFactor)
+        authRoutes.GET("/oidc/login", middleware.RateLimit(rateLimiter, authLimit), oidcController.Login)
+        authRoutes.GET("/oidc/callback", middleware.RateLimit(rateLimiter, authLimit), oidcController.Callback)
+        authRoutes.GET("/oidc/reauth", middleware.AuthMiddleware(authService, apiKeyService), middleware.SessionOnly(), middleware.RateLimit(rateLimiter, authLimit), oidcController.Reauthenticate)
         authRoutes.POST("/logout", authController.Logout)
-        authRoutes.GET("/me", middleware.AuthMiddleware(authService), authController.GetCurrentUser)
-        authRoutes.POST("/change-password", middleware.AuthMiddleware(authService), authController.ChangePassword)
-        authRoutes.GET("/search-users", middleware.AuthMiddleware(authService), authController.SearchUsers)
+        authRoutes.POST("/refresh", middleware.RateLimit(rateLimiter, authLimit), authController.Refresh)
+        authRoutes.POST("/forgot-password", middleware.RateLimit(rateLimiter, authLimit), authController.ForgotPassword)
+        authRoutes.POST("/reset-password", middleware.RateLimit(rateLimiter, authLimit), authController.ResetPassword)
+        authRoutes.POST("/verify-email", middleware.RateLimit(rateLimiter, authLimit), verificationController.Verify)
+        authRoutes.POST("/resend-verification", middleware.AuthMiddleware(authService, apiKeyService), middleware.SessionOnly(), middleware.RateLimit(rateLimiter, apiLimit), verificationController.Resend)
+        authRoutes.GET("/me", middleware.AuthMiddleware(authService, apiKeyService), middleware.RateLimit(rateLimiter, apiLimit), authController.GetCurrentUser)
+        authRoutes.PATCH("/me", middleware.AuthMiddleware(authService, apiKeyService), middleware.SessionOnly(), middleware.RateLimit(rateLimiter, apiLimit), authController.UpdateProfile)
+        authRoutes.DELETE("/me", middleware.AuthMiddleware(authService, apiKeyService), middleware.SessionOnly(), middleware.RateLimit(rateLimiter, authLimit), accountController.Delete)
+        authRoutes.GET("/me/export", middleware.AuthMiddleware(authService, apiKeyService), middleware.SessionOnly(), middleware.RateLimit(rateLimiter, exportLimit), accountController.Export)
+        authRoutes.POST("/change-password", middleware.AuthMiddleware(authService, apiKeyService), middleware.SessionOnly(), middleware.RateLimit(rateLimiter, apiLimit), authController.ChangePassword)
+        authRoutes.GET("/search-users", middleware.AuthMiddleware(authService, apiKeyService), middleware.RateLimit(rateLimiter, searchLimit), authController.SearchUsers)
+        authRoutes.GET("/sessions", middleware.AuthMiddleware(authService, apiKeyService), middleware.SessionOnly(), middleware.RateLimit(rateLimiter, apiLimit), authController.ListSessions)
+        authRoutes.POST("/sessions/revoke-others", middleware.AuthMiddleware(authService, apiKeyService), middleware.SessionOnly(), middleware.RateLimit(rateLimiter, apiLimit), authController.RevokeOtherSessions)
+        authRoutes.DELETE("/sessions/:id", middleware.AuthMiddleware(authService, apiKeyService), middleware.SessionOnly(), middleware.RateLimit(rateLimiter, apiLimit), authController.RevokeSession)
     }
 
     noteRoutes := router.Group("/api/notes")
-    noteRoutes.Use(middleware.AuthMiddleware(authService))
+    noteRoutes.Use(middleware.AuthMiddleware(authService, apiKeyService), middleware.RateLimit(rateLimiter, apiLimit))
     {
         noteRoutes.GET("", noteController.GetAll)
-        noteRoutes.POST("", noteController.Create)
+        noteRoutes.POST("", middleware.RateLimit(rateLimiter, noteCreateLimit), noteController.Create)
         noteRoutes.GET(":id", noteController.GetNote)
         noteRoutes.PUT(":id", noteController.Update)
         noteRoutes.DELETE(":id", noteController.Delete)
         noteRoutes.GET("/trash", noteController.GetTrashed)
+        noteRoutes.GET("/upcoming", noteController.GetUpcoming)
+        noteRoutes.GET("/overdue", noteController.GetOverdue)
         noteRoutes.POST(":id/restore", noteController.Restore)
         noteRoutes.POST(":id/pin", noteController.TogglePin)
+        noteRoutes.POST(":id/duplicate", noteController.Duplicate)
         noteRoutes.GET(":id/versions", noteController.GetHistory)
+        noteRoutes.GET(":id/activity", noteController.GetActivity)
         noteRoutes.POST("/version-restore/:noteId/:versionId", noteController.RestoreVersion)
         noteRoutes.GET("/filter", noteController.FilterByTag)
-        noteRoutes.PUT("/autosave/:noteId", noteController.AutoSave)
+        noteRoutes.PUT("/autosave/:noteId", middleware.RateLimit(rateLimiter, autosaveLimit), noteController.AutoSave)
         noteRoutes.GET(":id/collaborators", noteController.ListCollaborators)
+        noteRoutes.GET(":id/backlinks", noteController.GetBacklinks)
+        noteRoutes.GET(":id/outlinks", noteController.GetOutlinks)
         noteRoutes.POST(":id/share", noteController.ShareNote)
         noteRoutes.DELETE(":id/share", noteController.RemoveCollaborator)
+        noteRoutes.GET(":id/comments", commentController.GetAll)
+        noteRoutes.POST(":id/comments", commentController.Create)
+        noteRoutes.PUT(":id/comments/:commentId", commentController.Update)
+        noteRoutes.DELETE(":id/comments/:commentId", commentController.Delete)
+        noteRoutes.POST(":id/comments/:commentId/resolve", commentController.Resolve)
+        noteRoutes.POST(":id/comments/:commentId/reopen", commentController.Reopen)
     }
 
+    templateRoutes := router.Group("/api/templates")
+    templateRoutes.Use(middleware.AuthMiddleware(authService, apiKeyService), middleware.RateLimit(rateLimiter, apiLimit))
+    {
+        templateRoutes.GET("", templateController.GetAll)
+        templateRoutes.POST("", templateController.Create)
+        templateRoutes.GET(":id", templateController.GetTemplate)
+        templateRoutes.PUT(":id", templateController.Update)
+        templateRoutes.DELETE(":id", templateController.Delete)
+    }
+
+    notificationRoutes := router.Group("/api/notifications")
+    notificationRoutes.Use(middleware.AuthMiddleware(authService, apiKeyService), middleware.RateLimit(rateLimiter, apiLimit))
+    {
+        notificationRoutes.GET("", notificationController.GetAll)
+        notificationRoutes.GET("/unread-count", notificationController.UnreadCount)
+        notificationRoutes.POST("/read-all", notificationController.MarkAllRead)
+        notificationRoutes.POST(":id/read", notificationController.MarkRead)
+        notificationRoutes.GET("/preferences", notificationController.GetPreferences)
+        notificationRoutes.PUT("/preferences", notificationController.UpdatePreferences)
+    }
+
+    webhookRoutes := router.Group("/api/webhooks")
+    webhookRoutes.Use(middleware.AuthMiddleware(authService, apiKeyService), middleware.RateLimit(rateLimiter, apiLimit))
+    {
+        webhookRoutes.GET("", webhookController.GetAll)
+        webhookRoutes.POST("", webhookController.Create)
+        webhookRoutes.DELETE(":id", webhookController.Delete)
+        webhookRoutes.GET(":id/deliveries", webhookController.GetDeliveries)
+        webhookRoutes.GET("/dead-letters", webhookController.GetDeadLetters)
+        webhookRoutes.POST("/deliveries/:deliveryId/redeliver", webhookController.Redeliver)
+    }
+
+    twoFactorRoutes := router.Group("/api/auth/2fa")
+    twoFactorRoutes.Use(middleware.AuthMiddleware(authService, apiKeyService), middleware.SessionOnly(), middleware.RateLimit(rateLimiter, apiLimit))
+    {
+        twoFactorRoutes.POST("/setup", twoFactorController.Setup)
+        twoFactorRoutes.POST("/enable", twoFactorController.Enable)
+        twoFactorRoutes.POST("/disable", twoFactorController.Disable)
+        twoFactorRoutes.POST("/recovery-codes", twoFactorController.RegenerateRecoveryCodes)
+    }
+
+    // API keys can't create or revoke API keys
+    apiKeyRoutes := router.Group("/api/keys")
+    apiKeyRoutes.Use(middleware.AuthMiddleware(authService, apiKeyService), middleware.SessionOnly(), middleware.RateLimit(rateLimiter, apiLimit))
+    {
+        apiKeyRoutes.GET("", apiKeyController.GetAll)
+        apiKeyRoutes.POST("", apiKeyController.Create)
+        apiKeyRoutes.DELETE(":id", apiKeyController.Revoke)
+    }
+
+    adminRoutes := router.Group("/api/admin")
+    adminRoutes.Use(middleware.AuthMiddleware(authService, apiKeyService), middleware.SessionOnly(), middleware.AdminMiddleware(), middleware.RateLimit(rateLimiter, apiLimit))
+    {
+        adminRoutes.GET("/users", adminController.ListUsers)
+        adminRoutes.GET("/stats", adminController.GetStats)
+        adminRoutes.PUT("/users/:id/role", adminController.SetRole)
+        adminRoutes.POST("/users/:id/disable", adminController.Disable)
+        adminRoutes.POST("/users/:id/enable", adminController.Enable)
+        adminRoutes.POST("/users/:id/unlock", adminController.UnlockUser)
+        adminRoutes.POST("/users/:id/force-password-reset", adminController.ForcePasswordReset)
+        adminRoutes.POST("/users/:id/revoke-sessions", adminController.RevokeSessions)
+        adminRoutes.POST("/users/:id/transfer-notes", adminController.TransferNotes)
+        adminRoutes.DELETE("/users/:id/notes", adminController.DeleteNotes)
+    }
+
+    router.GET("/api/graph", middleware.AuthMiddleware(authService, apiKeyService), middleware.RateLimit(rateLimiter, apiLimit), graphController.GetGraph)
+    router.GET("/api/audit", middleware.AuthMiddleware(authService, apiKeyService), middleware.RateLimit(rateLimiter, apiLimit), auditController.GetEvents)
+    router.GET("/api/mentions", middleware.AuthMiddleware(authService, apiKeyService), middleware.RateLimit(rateLimiter, apiLimit), mentionController.GetMentions)
+
     log.Println("Server starting on :8080")
     if err := router.Run(":8080"); err != nil {
         log.Fatal("Failed to start server:", err)
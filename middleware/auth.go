@@ -2,14 +2,45 @@ package middleware
 
 import (
     "net/http"
+    "strings"
+    "notes-app/models"
     "notes-app/services"
     "github.com/gin-gonic/gin"
 )
 
-func AuthMiddleware(authService *services.AuthService) gin.HandlerFunc {
+const (
+    AuthMethodSession = "session"
+    AuthMethodAPIKey  = "api_key"
+)
+
+// AuthMiddleware accepts the sessionId cookie, a session ID sent as
+// "Authorization: Bearer <session>" or an API key sent as "Authorization: Bearer nk_...".
+// Read-only API keys are limited to safe methods.
+func AuthMiddleware(authService *services.AuthService, apiKeyService *services.APIKeyService) gin.HandlerFunc {
     return func(c *gin.Context) {
-        sessionID, err := c.Cookie("sessionId")
-        if err != nil || sessionID == "" {
+        token := BearerToken(c)
+
+        if strings.HasPrefix(token, models.APIKeyPrefix) {
+            user, scope, err := apiKeyService.Authenticate(token, c.ClientIP())
+            if err != nil {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+                c.Abort()
+                return
+            }
+            if scope == models.ScopeRead && !isSafeMethod(c.Request.Method) {
+                c.JSON(http.StatusForbidden, gin.H{"error": "API key is read-only"})
+                c.Abort()
+                return
+            }
+
+            c.Set("user", user)
+            c.Set("authMethod", AuthMethodAPIKey)
+            c.Next()
+            return
+        }
+
+        sessionID := SessionID(c)
+        if sessionID == "" {
             c.JSON(http.StatusUnauthorized, gin.H{"error": "No session provided"})
             c.Abort()
             return
@@ -23,6 +54,55 @@ func AuthMiddleware(authService *services.AuthService) gin.HandlerFunc {
         }
 
         c.Set("user", user)
+        c.Set("authMethod", AuthMethodSession)
+        c.Next()
+    }
+}
+
+// SessionOnly rejects requests authenticated with an API key, for account
+// management that should need an interactive login. Use it after AuthMiddleware.
+func SessionOnly() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if c.GetString("authMethod") != AuthMethodSession {
+            c.JSON(http.StatusForbidden, gin.H{"error": "This action requires signing in, API keys can't be used"})
+            c.Abort()
+            return
+        }
         c.Next()
     }
 }
+
+// AdminMiddleware rejects users who aren't admins. Use it after AuthMiddleware.
+func AdminMiddleware() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        user := c.MustGet("user").(*models.User)
+        if !user.IsAdmin() {
+            c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+            c.Abort()
+            return
+        }
+        c.Next()
+    }
+}
+
+// BearerToken returns the token of an "Authorization: Bearer" header, or ""
+func BearerToken(c *gin.Context) string {
+    header := c.GetHeader("Authorization")
+    if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+        return ""
+    }
+    return strings.TrimSpace(header[7:])
+}
+
+// SessionID returns the session ID from the Bearer token or, failing that, the sessionId cookie
+func SessionID(c *gin.Context) string {
+    if token := BearerToken(c); token != "" && !strings.HasPrefix(token, models.APIKeyPrefix) {
+        return token
+    }
+    sessionID, _ := c.Cookie("sessionId")
+    return sessionID
+}
+
+func isSafeMethod(method string) bool {
+    return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
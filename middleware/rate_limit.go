@@ -0,0 +1,47 @@
+package middleware
+
+import (
+    "math"
+    "net/http"
+    "strconv"
+    "time"
+    "notes-app/config"
+    "notes-app/models"
+    "notes-app/services"
+    "github.com/gin-gonic/gin"
+)
+
+// RateLimit limits requests per user, or per IP for requests without a user. Put it
+// after AuthMiddleware to limit per user. Every response gets RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers, refused ones a 429 with Retry-After.
+// RATE_LIMIT_ENABLED=false turns all limits off.
+func RateLimit(limiter *services.RateLimiter, limit services.RateLimit) gin.HandlerFunc {
+    enabled := config.GetEnvBool("RATE_LIMIT_ENABLED", true)
+    return func(c *gin.Context) {
+        if !enabled || limit.Requests <= 0 {
+            c.Next()
+            return
+        }
+
+        key := limit.Name + ":ip:" + c.ClientIP()
+        if user, ok := c.Get("user"); ok {
+            key = limit.Name + ":user:" + user.(*models.User).ID.Hex()
+        }
+
+        result := limiter.Allow(key, limit)
+        c.Header("RateLimit-Limit", strconv.Itoa(limit.Requests))
+        c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+        c.Header("RateLimit-Reset", ceilSeconds(result.Reset))
+        if !result.Allowed {
+            c.Header("Retry-After", ceilSeconds(result.RetryAfter))
+            c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, please slow down"})
+            c.Abort()
+            return
+        }
+        c.Next()
+    }
+}
+
+func ceilSeconds(d time.Duration) string {
+    return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
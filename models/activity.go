@@ -0,0 +1,59 @@
+package models
+
+import (
+    "time"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    ActivityCreated         = "created"
+    ActivityEdited          = "edited"
+    ActivityPinned          = "pinned"
+    ActivityUnpinned        = "unpinned"
+    ActivityTrashed         = "trashed"
+    ActivityRestored        = "restored"
+    ActivityShared          = "shared"
+    ActivityUnshared        = "unshared"
+    ActivityVersionRestored = "version_restored"
+    ActivityVersionSaved    = "version_saved"
+    ActivityCommented       = "commented"
+)
+
+// NoteActivity is one entry in a note's activity timeline
+type NoteActivity struct {
+    ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+    NoteID       primitive.ObjectID  `bson:"noteId" json:"noteId"`
+    ActorID      primitive.ObjectID  `bson:"actorId" json:"actorId"`
+    Type         string              `bson:"type" json:"type"`
+    Fields       []string            `bson:"fields,omitempty" json:"fields,omitempty"`
+    TagsAdded    []string            `bson:"tagsAdded,omitempty" json:"tagsAdded,omitempty"`
+    TagsRemoved  []string            `bson:"tagsRemoved,omitempty" json:"tagsRemoved,omitempty"`
+    VersionID    *primitive.ObjectID `bson:"versionId,omitempty" json:"versionId,omitempty"`
+    VersionedAt  *time.Time          `bson:"versionedAt,omitempty" json:"versionedAt,omitempty"`
+    TargetUserID *primitive.ObjectID `bson:"targetUserId,omitempty" json:"targetUserId,omitempty"`
+    CommentID    *primitive.ObjectID `bson:"commentId,omitempty" json:"commentId,omitempty"`
+    CreatedAt    time.Time           `bson:"createdAt" json:"createdAt"`
+}
+
+type ActivityResponse struct {
+    ID          string     `json:"id"`
+    Type        string     `json:"type"`
+    ActorID     string     `json:"actorId,omitempty"`
+    ActorName   string     `json:"actorName,omitempty"`
+    Summary     string     `json:"summary"`
+    Fields      []string   `json:"fields,omitempty"`
+    TagsAdded   []string   `json:"tagsAdded,omitempty"`
+    TagsRemoved []string   `json:"tagsRemoved,omitempty"`
+    VersionID   string     `json:"versionId,omitempty"`
+    VersionedAt *time.Time `json:"versionedAt,omitempty"`
+    TargetUser  string     `json:"targetUser,omitempty"`
+    CommentID   string     `json:"commentId,omitempty"`
+    CreatedAt   time.Time  `json:"createdAt"`
+}
+
+type ActivityListResponse struct {
+    Activities []ActivityResponse `json:"activities"`
+    Total      int                `json:"total"`
+    Page       int                `json:"page"`
+    Limit      int                `json:"limit"`
+}
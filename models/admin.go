@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// AdminUserResponse is a user as admins see it
+type AdminUserResponse struct {
+    ID                    string     `json:"id"`
+    Name                  string     `json:"name"`
+    Username              string     `json:"username"`
+    Email                 string     `json:"email"`
+    Role                  string     `json:"role"`
+    EmailVerified         bool       `json:"emailVerified"`
+    TwoFactorEnabled      bool       `json:"twoFactorEnabled"`
+    SingleSignOn          bool       `json:"singleSignOn"`
+    Disabled              bool       `json:"disabled"`
+    DisabledAt            *time.Time `json:"disabledAt,omitempty"`
+    PasswordResetRequired bool       `json:"passwordResetRequired"`
+    CreatedAt             time.Time  `json:"createdAt"` // from the ID, users have no creation date field
+}
+
+type AdminUserListResponse struct {
+    Users []AdminUserResponse `json:"users"`
+    Total int64               `json:"total"`
+    Page  int                 `json:"page"`
+    Limit int                 `json:"limit"`
+}
+
+// SetRoleRequest changes a user's role
+// { "role": "admin" }
+type SetRoleRequest struct {
+    Role string `json:"role" binding:"required"`
+}
+
+// TransferNotesRequest moves all notes of a user to another user
+// { "toUsername": "bob" }
+type TransferNotesRequest struct {
+    ToUsername string `json:"toUsername" binding:"required"`
+}
+
+// AdminStats are usage totals across all users
+type AdminStats struct {
+    Users          int64 `json:"users"`
+    Admins         int64 `json:"admins"`
+    VerifiedUsers  int64 `json:"verifiedUsers"`
+    DisabledUsers  int64 `json:"disabledUsers"`
+    TwoFactorUsers int64 `json:"twoFactorUsers"`
+    SSOUsers       int64 `json:"ssoUsers"`
+    Notes          int64 `json:"notes"`
+    TrashedNotes   int64 `json:"trashedNotes"`
+    SharedNotes    int64 `json:"sharedNotes"`
+    NotesLast7Days int64 `json:"notesLast7Days"`
+    Comments       int64 `json:"comments"`
+    APIKeys        int64 `json:"apiKeys"`
+}
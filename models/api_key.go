@@ -0,0 +1,40 @@
+package models
+
+import (
+    "time"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    ScopeRead      = "read"
+    ScopeReadWrite = "read-write"
+)
+
+// APIKeyPrefix starts every API key, so keys can be told apart from session IDs
+const APIKeyPrefix = "nk_"
+
+// APIKey is a long-lived personal access token. Only a SHA-256 hash of the key is stored.
+type APIKey struct {
+    ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    UserID     primitive.ObjectID `bson:"userId" json:"userId"`
+    Name       string             `bson:"name" json:"name"`
+    KeyHash    string             `bson:"keyHash" json:"-"`
+    Hint       string             `bson:"hint" json:"hint"` // last characters of the key, to tell keys apart
+    Scope      string             `bson:"scope" json:"scope"`
+    LastUsedAt *time.Time         `bson:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+    LastUsedIP string             `bson:"lastUsedIp,omitempty" json:"lastUsedIp,omitempty"`
+    CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// APIKeyRequest is the request body for creating an API key, scope defaults to read
+// { "name": "backup script", "scope": "read" }
+type APIKeyRequest struct {
+    Name  string `json:"name" binding:"required"`
+    Scope string `json:"scope"`
+}
+
+// APIKeyCreatedResponse includes the key itself, which is only ever shown once
+type APIKeyCreatedResponse struct {
+    APIKey
+    Key string `json:"key"`
+}
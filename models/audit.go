@@ -0,0 +1,71 @@
+package models
+
+import (
+    "time"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    AuditLogin           = "auth.login"
+    AuditLoginFailed     = "auth.login_failed"
+    AuditLogout          = "auth.logout"
+    AuditPasswordChanged = "auth.password_changed"
+    AuditAPIKeyCreated   = "auth.api_key_created"
+    AuditAPIKeyRevoked   = "auth.api_key_revoked"
+    AuditSessionRevoked  = "auth.session_revoked"
+    AuditRefreshReused   = "auth.refresh_token_reused"
+    AuditTwoFactorOn     = "auth.2fa_enabled"
+    AuditTwoFactorOff    = "auth.2fa_disabled"
+    AuditRecoveryUsed    = "auth.recovery_code_used"
+    AuditRecoveryReset   = "auth.recovery_codes_regenerated"
+    AuditResetRequested  = "auth.password_reset_requested"
+    AuditPasswordReset   = "auth.password_reset"
+    AuditEmailVerified   = "auth.email_verified"
+    AuditEmailChanged    = "auth.email_changed"
+    AuditUsernameChanged = "auth.username_changed"
+    AuditAccountLocked   = "auth.account_locked"
+    AuditAccountUnlocked = "auth.account_unlocked"
+    AuditIdentityLinked  = "auth.identity_linked"
+    AuditAccountDeleted  = "auth.account_deleted"
+    AuditDataExported    = "auth.data_exported"
+    AuditUserDisabled    = "admin.user_disabled"
+    AuditUserEnabled     = "admin.user_enabled"
+    AuditRoleChanged     = "admin.role_changed"
+    AuditResetForced     = "admin.password_reset_forced"
+    AuditNotesMoved      = "admin.notes_transferred"
+    AuditNotesDeleted    = "admin.notes_deleted"
+    AuditNoteShared      = "note.shared"
+    AuditNoteUnshared    = "note.unshared"
+    AuditVersionRestored = "note.version_restored"
+)
+
+// AuditEvent is an append-only record of a security relevant action.
+// ActorID is empty when the actor is unknown, e.g. a failed login.
+type AuditEvent struct {
+    ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+    ActorID    *primitive.ObjectID `bson:"actorId,omitempty" json:"actorId,omitempty"`
+    Action     string              `bson:"action" json:"action"`
+    TargetType string              `bson:"targetType" json:"targetType"`
+    TargetID   string              `bson:"targetId" json:"targetId"`
+    IP         string              `bson:"ip" json:"ip"`
+    UserAgent  string              `bson:"userAgent" json:"userAgent"`
+    Metadata   map[string]string   `bson:"metadata,omitempty" json:"metadata,omitempty"`
+    CreatedAt  time.Time           `bson:"createdAt" json:"createdAt"`
+}
+
+// ClientInfo describes where a request came from
+type ClientInfo struct {
+    IP        string
+    UserAgent string
+}
+
+// AuditQuery filters audit events, empty fields match everything
+type AuditQuery struct {
+    ActorID  *primitive.ObjectID
+    Action   string
+    TargetID string
+    Since    *time.Time
+    Until    *time.Time
+    Page     int
+    Limit    int
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+    "time"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CommentAnchor ties a comment to a range of the note content. Start and End
+// count Unicode characters (code points), not bytes.
+type CommentAnchor struct {
+    Start int    `bson:"start" json:"start"`
+    End   int    `bson:"end" json:"end"`
+    Quote string `bson:"quote" json:"quote"` // the anchored text when the comment was made
+}
+
+// Comment is a top-level comment (ParentID empty) or a reply in its thread
+type Comment struct {
+    ID         primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+    NoteID     primitive.ObjectID   `bson:"noteId" json:"noteId"`
+    ParentID   *primitive.ObjectID  `bson:"parentId,omitempty" json:"parentId,omitempty"`
+    AuthorID   primitive.ObjectID   `bson:"authorId" json:"authorId"`
+    Body       string               `bson:"body" json:"body"`
+    Anchor     *CommentAnchor       `bson:"anchor,omitempty" json:"anchor,omitempty"`
+    Mentions   []primitive.ObjectID `bson:"mentions,omitempty" json:"mentions,omitempty"`
+    Resolved   bool                 `bson:"resolved" json:"resolved"`
+    ResolvedBy *primitive.ObjectID  `bson:"resolvedBy,omitempty" json:"resolvedBy,omitempty"`
+    ResolvedAt *time.Time           `bson:"resolvedAt,omitempty" json:"resolvedAt,omitempty"`
+    Edited     bool                 `bson:"edited" json:"edited"`
+    Deleted    bool                 `bson:"deleted" json:"deleted"`
+    CreatedAt  time.Time            `bson:"createdAt" json:"createdAt"`
+    UpdatedAt  time.Time            `bson:"updatedAt" json:"updatedAt"`
+}
+
+// CommentRequest is the request body for adding a comment. ParentID makes it a reply,
+// Anchor is only allowed on top-level comments.
+// { "body": "Can we cite this? @alice", "anchor": { "start": 10, "end": 42, "quote": "..." } }
+type CommentRequest struct {
+    Body     string         `json:"body" binding:"required"`
+    ParentID string         `json:"parentId"`
+    Anchor   *CommentAnchor `json:"anchor"`
+}
+
+type UpdateCommentRequest struct {
+    Body string `json:"body" binding:"required"`
+}
+
+type CommentResponse struct {
+    ID         string            `json:"id"`
+    NoteID     string            `json:"noteId"`
+    ParentID   string            `json:"parentId,omitempty"`
+    Author     UserProfileDto    `json:"author"`
+    Body       string            `json:"body"`
+    Anchor     *CommentAnchor    `json:"anchor,omitempty"`
+    Mentions   []UserProfileDto  `json:"mentions"`
+    Resolved   bool              `json:"resolved"`
+    ResolvedAt *time.Time        `json:"resolvedAt,omitempty"`
+    Edited     bool              `json:"edited"`
+    Deleted    bool              `json:"deleted"`
+    CreatedAt  time.Time         `json:"createdAt"`
+    UpdatedAt  time.Time         `json:"updatedAt"`
+    Replies    []CommentResponse `json:"replies,omitempty"`
+}
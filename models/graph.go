@@ -0,0 +1,20 @@
+package models
+
+// GraphNode is either a note (ID is the note ID) or a tag (ID is "tag:<name>")
+type GraphNode struct {
+    ID    string `json:"id"`
+    Type  string `json:"type"`
+    Label string `json:"label"`
+}
+
+// GraphEdge is a [[link]] between two notes ("link") or a note carrying a tag ("tag")
+type GraphEdge struct {
+    Source string `json:"source"`
+    Target string `json:"target"`
+    Type   string `json:"type"`
+}
+
+type GraphResponse struct {
+    Nodes []GraphNode `json:"nodes"`
+    Edges []GraphEdge `json:"edges"`
+}
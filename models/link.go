@@ -0,0 +1,23 @@
+package models
+
+import (
+    "time"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NoteLink is one [[...]] reference from a source note. TargetID is empty
+// while no note with the referenced title exists yet.
+type NoteLink struct {
+    ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+    SourceID    primitive.ObjectID  `bson:"sourceId" json:"sourceId"`
+    TargetID    *primitive.ObjectID `bson:"targetId" json:"targetId"`
+    TargetTitle string              `bson:"targetTitle" json:"targetTitle"`
+    TargetKey   string              `bson:"targetKey" json:"-"` // lowercased title used for matching
+    CreatedAt   time.Time           `bson:"createdAt" json:"createdAt"`
+}
+
+type LinkedNoteResponse struct {
+    ID       string `json:"id,omitempty"`
+    Title    string `json:"title"`
+    Resolved bool   `json:"resolved"`
+}
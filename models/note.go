@@ -15,28 +15,48 @@ type Note struct {
     UserID          primitive.ObjectID   `bson:"userId" json:"userId"`
     Tags            []string             `bson:"tags" json:"tags"`
     Collaborators   []primitive.ObjectID `bson:"collaborators" json:"collaborators"`
+    Roles           map[string]string    `bson:"roles,omitempty" json:"roles,omitempty"` // collaborator ID (hex) -> role, missing means editor
+    ShareOnMention  bool                 `bson:"shareOnMention" json:"shareOnMention"`   // mentioning someone without access grants them view access
+    ReminderAt      *time.Time           `bson:"reminderAt,omitempty" json:"reminderAt,omitempty"`
+    ReminderSentAt  *time.Time           `bson:"reminderSentAt,omitempty" json:"reminderSentAt,omitempty"`
+    DueAt           *time.Time           `bson:"dueAt,omitempty" json:"dueAt,omitempty"`
     CreatedAt       time.Time            `bson:"createdAt" json:"createdAt"`
     UpdatedAt       time.Time            `bson:"updatedAt" json:"updatedAt"`
 }
 
 type NoteRequest struct {
-    Title           string   `json:"title"`
-    Content         string   `json:"content"`
-    Tags            []string `json:"tags"`
-    AutoSaveEnabled bool     `json:"autoSaveEnabled"`
+    Title           string            `json:"title"`
+    Content         string            `json:"content"`
+    Tags            []string          `json:"tags"`
+    AutoSaveEnabled bool              `json:"autoSaveEnabled"`
+    // ReminderAt and DueAt are left unchanged by updates that don't set them,
+    // ClearReminder and ClearDueAt remove them
+    ReminderAt      *time.Time        `json:"reminderAt"`
+    DueAt           *time.Time        `json:"dueAt"`
+    ClearReminder   bool              `json:"clearReminder,omitempty"`
+    ClearDueAt      bool              `json:"clearDueAt,omitempty"`
+    // ShareOnMention is only applied when the owner saves the note, nil leaves it unchanged
+    ShareOnMention  *bool             `json:"shareOnMention,omitempty"`
+    // TemplateID and Variables are only used by CreateNote to prefill
+    // the note from a template.
+    TemplateID      string            `json:"templateId,omitempty"`
+    Variables       map[string]string `json:"variables,omitempty"`
 }
 
 type NoteResponse struct {
-    ID              string    `json:"id"`
-    Title           string    `json:"title"`
-    Content         string    `json:"content"`
-    Pinned          bool      `json:"pinned"`
-    Trashed         bool      `json:"trashed"`
-    AutoSaveEnabled bool      `json:"autoSaveEnabled"`
-    Tags            []string  `json:"tags"`
-    CreatedAt       time.Time `json:"createdAt"`
-    UpdatedAt       time.Time `json:"updatedAt"`
-    UserID          string    `json:"userId"`
+    ID              string     `json:"id"`
+    Title           string     `json:"title"`
+    Content         string     `json:"content"`
+    Pinned          bool       `json:"pinned"`
+    Trashed         bool       `json:"trashed"`
+    AutoSaveEnabled bool       `json:"autoSaveEnabled"`
+    Tags            []string   `json:"tags"`
+    ReminderAt      *time.Time `json:"reminderAt,omitempty"`
+    DueAt           *time.Time `json:"dueAt,omitempty"`
+    ShareOnMention  bool       `json:"shareOnMention"`
+    CreatedAt       time.Time  `json:"createdAt"`
+    UpdatedAt       time.Time  `json:"updatedAt"`
+    UserID          string     `json:"userId"`
 }
 
 type NoteVersion struct {
@@ -54,10 +74,26 @@ type NoteVersionResponse struct {
     VersionedAt time.Time `json:"versionedAt"`
 }
 
-// AddCollaboratorRequest is the request body for adding a collaborator
-// { "username": "collab_username" }
+// DuplicateNoteRequest is the optional request body for duplicating a note
+// { "includeCollaborators": true }
+type DuplicateNoteRequest struct {
+    IncludeCollaborators bool `json:"includeCollaborators"`
+}
+
+const (
+    RoleOwner     = "owner"
+    RoleEditor    = "editor"
+    RoleCommenter = "commenter" // can read and comment but not edit
+    RoleViewer    = "viewer"    // can only read
+)
+
+// AddCollaboratorRequest is the request body for adding a collaborator.
+// Role is editor (default), commenter or viewer, sharing again with an
+// existing collaborator changes their role.
+// { "username": "collab_username", "role": "commenter" }
 type AddCollaboratorRequest struct {
     Username string `json:"username" binding:"required"`
+    Role     string `json:"role"`
 }
 
 // RemoveCollaboratorRequest is the request body for removing a collaborator
@@ -65,3 +101,26 @@ type AddCollaboratorRequest struct {
 type RemoveCollaboratorRequest struct {
     Username string `json:"username" binding:"required"`
 }
+
+// NoteMention records that a user is mentioned with @username in a note's content
+type NoteMention struct {
+    ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    NoteID      primitive.ObjectID `bson:"noteId" json:"noteId"`
+    UserID      primitive.ObjectID `bson:"userId" json:"userId"`
+    MentionedBy primitive.ObjectID `bson:"mentionedBy" json:"mentionedBy"`
+    CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+type MentionResponse struct {
+    NoteID      string         `json:"noteId"`
+    NoteTitle   string         `json:"noteTitle"`
+    MentionedBy UserProfileDto `json:"mentionedBy"`
+    MentionedAt time.Time      `json:"mentionedAt"`
+}
+
+type MentionListResponse struct {
+    Mentions []MentionResponse `json:"mentions"`
+    Total    int               `json:"total"`
+    Page     int               `json:"page"`
+    Limit    int               `json:"limit"`
+}
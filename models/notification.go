@@ -0,0 +1,76 @@
+package models
+
+import (
+    "time"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    NotificationReminder        = "reminder"
+    NotificationNoteShared      = "note_shared"
+    NotificationNoteUnshared    = "note_unshared"
+    NotificationNoteEdited      = "note_edited"
+    NotificationVersionRestored = "version_restored"
+    NotificationComment         = "comment"
+    NotificationMentioned       = "mentioned"
+)
+
+// NotificationTypes lists every notification type a user can mute
+var NotificationTypes = []string{
+    NotificationReminder,
+    NotificationNoteShared,
+    NotificationNoteUnshared,
+    NotificationNoteEdited,
+    NotificationVersionRestored,
+    NotificationComment,
+    NotificationMentioned,
+}
+
+type Notification struct {
+    ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+    UserID    primitive.ObjectID  `bson:"userId" json:"userId"`
+    Type      string              `bson:"type" json:"type"`
+    NoteID    *primitive.ObjectID `bson:"noteId,omitempty" json:"noteId,omitempty"`
+    ActorID   *primitive.ObjectID `bson:"actorId,omitempty" json:"actorId,omitempty"`
+    Message   string              `bson:"message" json:"message"`
+    Read      bool                `bson:"read" json:"read"`
+    CreatedAt time.Time           `bson:"createdAt" json:"createdAt"`
+}
+
+const (
+    DigestOff    = "off"
+    DigestDaily  = "daily"
+    DigestWeekly = "weekly"
+)
+
+type NotificationPreferences struct {
+    UserID          primitive.ObjectID `bson:"userId" json:"-"`
+    Muted           []string           `bson:"muted" json:"muted"`
+    DigestFrequency string             `bson:"digestFrequency" json:"digestFrequency"`
+    LastDigestAt    *time.Time         `bson:"lastDigestAt,omitempty" json:"lastDigestAt,omitempty"`
+    UpdatedAt       time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// NotificationPreferencesRequest is the request body for updating notification preferences.
+// Omitted fields are left unchanged.
+// { "muted": ["note_edited"], "digestFrequency": "daily" }
+type NotificationPreferencesRequest struct {
+    Muted           []string `json:"muted"`
+    DigestFrequency string   `json:"digestFrequency"`
+}
+
+// DigestItem is one note that changed during a digest window
+type DigestItem struct {
+    NoteID    string
+    Title     string
+    Edits     int
+    UpdatedAt time.Time
+    URL       string
+}
+
+type NotificationListResponse struct {
+    Notifications []Notification `json:"notifications"`
+    UnreadCount   int64          `json:"unreadCount"`
+    Page          int            `json:"page"`
+    Limit         int            `json:"limit"`
+}
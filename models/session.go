@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Session describes a login for the session list. ID is derived from the session
+// token and safe to show, the token itself is never returned.
+type Session struct {
+    ID         string    `json:"id"`
+    Device     string    `json:"device"`
+    IP         string    `json:"ip"`
+    UserAgent  string    `json:"userAgent"`
+    CreatedAt  time.Time `json:"createdAt"`
+    LastSeenAt time.Time `json:"lastSeenAt"`
+    Remembered bool      `json:"remembered"` // started by a remember-me login
+    Current    bool      `json:"current"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+    "time"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type NoteTemplate struct {
+    ID          primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+    UserID      primitive.ObjectID   `bson:"userId" json:"userId"`
+    Name        string               `bson:"name" json:"name"`
+    Description string               `bson:"description" json:"description"`
+    Title       string               `bson:"title" json:"title"`
+    Content     string               `bson:"content" json:"content"`
+    Tags        []string             `bson:"tags" json:"tags"`
+    SharedWith  []primitive.ObjectID `bson:"sharedWith,omitempty" json:"sharedWith"`
+    CreatedAt   time.Time            `bson:"createdAt" json:"createdAt"`
+    UpdatedAt   time.Time            `bson:"updatedAt" json:"updatedAt"`
+}
+
+// TemplateRequest is the request body for creating or updating a template.
+// Title and content may contain placeholders such as {{date}} or {{user.name}}.
+// Templates are private unless shared with users by username, templates
+// can hold private content so there is no sharing with everyone.
+type TemplateRequest struct {
+    Name        string   `json:"name" binding:"required"`
+    Description string   `json:"description"`
+    Title       string   `json:"title"`
+    Content     string   `json:"content"`
+    Tags        []string `json:"tags"`
+    SharedWith  []string `json:"sharedWith"`
+}
+
+type TemplateResponse struct {
+    ID          string    `json:"id"`
+    Name        string    `json:"name"`
+    Description string    `json:"description"`
+    Title       string    `json:"title"`
+    Content     string    `json:"content"`
+    Tags        []string  `json:"tags"`
+    Shared      bool      `json:"shared"`
+    SharedWith  []string  `json:"sharedWith,omitempty"` // usernames, only shown to the owner
+    UserID      string    `json:"userId"`
+    CreatedAt   time.Time `json:"createdAt"`
+    UpdatedAt   time.Time `json:"updatedAt"`
+}
@@ -1,15 +1,75 @@
 package models
 
 import (
+    "time"
     "go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const (
+    RoleUser  = "user"
+    RoleAdmin = "admin"
+)
+
+// Who can find a user with user search. Users without a setting are discoverable by everyone.
+const (
+    DiscoverableEveryone      = "everyone"
+    DiscoverableCollaborators = "collaborators" // only people who already share a note with them
+    DiscoverableNobody        = "nobody"
+)
+
 type User struct {
     ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
     Name     string             `bson:"name" json:"name" binding:"required"`
     Email    string             `bson:"email" json:"email" binding:"required,email"`
     Username string             `bson:"username" json:"username" binding:"required"`
     Password string             `bson:"password" json:"-"` // Hide from JSON
+    Role     string             `bson:"role,omitempty" json:"role"`
+
+    // UsernameLower is the lowercased username, indexed for prefix search
+    UsernameLower   string `bson:"usernameLower,omitempty" json:"-"`
+    Discoverability string `bson:"discoverability,omitempty" json:"discoverability,omitempty"`
+
+    // Profile details the user can edit, shown to the people they collaborate with
+    AvatarURL string `bson:"avatarUrl,omitempty" json:"avatarUrl,omitempty"`
+    Timezone  string `bson:"timezone,omitempty" json:"timezone,omitempty"` // IANA name, e.g. Europe/Berlin
+    Locale    string `bson:"locale,omitempty" json:"locale,omitempty"`     // BCP 47 tag, e.g. en-GB
+    Bio       string `bson:"bio,omitempty" json:"bio,omitempty"`
+
+    // Disabled accounts can't sign in or use API keys. PasswordResetRequired is set
+    // by an admin and blocks signing in until the password is reset by email.
+    Disabled              bool       `bson:"disabled,omitempty" json:"disabled"`
+    DisabledAt            *time.Time `bson:"disabledAt,omitempty" json:"disabledAt,omitempty"`
+    PasswordResetRequired bool       `bson:"passwordResetRequired,omitempty" json:"passwordResetRequired"`
+
+    // New accounts start unverified until the emailed link is opened. A changed
+    // email is kept as pending until the link sent to it is opened.
+    EmailVerified   bool       `bson:"emailVerified" json:"emailVerified"`
+    EmailVerifiedAt *time.Time `bson:"emailVerifiedAt,omitempty" json:"emailVerifiedAt,omitempty"`
+    PendingEmail    string     `bson:"pendingEmail,omitempty" json:"pendingEmail,omitempty"`
+
+    // Two-factor authentication. The pending secret is kept until enrollment is
+    // confirmed with a code, recovery codes are stored as SHA-256 hashes.
+    TOTPEnabled       bool     `bson:"totpEnabled" json:"totpEnabled"`
+    TOTPSecret        string   `bson:"totpSecret,omitempty" json:"-"`
+    TOTPPendingSecret string   `bson:"totpPendingSecret,omitempty" json:"-"`
+    TOTPLastStep      int64    `bson:"totpLastStep,omitempty" json:"-"`
+    RecoveryCodes     []string `bson:"recoveryCodes,omitempty" json:"-"`
+
+    // Identities at external providers the user signs in with. Users created
+    // through single sign-on have no password.
+    Identities []ExternalIdentity `bson:"identities,omitempty" json:"-"`
+}
+
+// ExternalIdentity links a user to an account at an OpenID Connect provider
+type ExternalIdentity struct {
+    Provider string    `bson:"provider" json:"provider"` // the provider's issuer URL
+    Subject  string    `bson:"subject" json:"subject"`
+    Email    string    `bson:"email,omitempty" json:"email,omitempty"`
+    LinkedAt time.Time `bson:"linkedAt" json:"linkedAt"`
+}
+
+func (u *User) IsAdmin() bool {
+    return u.Role == RoleAdmin
 }
 
 type RegisterRequest struct {
@@ -20,19 +80,135 @@ type RegisterRequest struct {
 }
 
 type LoginRequest struct {
-    Username string `json:"username" binding:"required"`
-    Password string `json:"password" binding:"required"`
+    Username    string `json:"username" binding:"required"`
+    Password    string `json:"password" binding:"required"`
+    // ReturnToken puts the session ID in the response body, for clients
+    // that send it as a Bearer token instead of using the cookie
+    ReturnToken bool `json:"returnToken"`
+    // RememberMe also issues a refresh token, which keeps the user signed in
+    // across sessions until it expires
+    RememberMe  bool `json:"rememberMe"`
 }
 
+// LoginResponse is the result of a login. When TwoFactorRequired is set there is no
+// session yet, PendingToken has to be completed with a code at /api/auth/login/2fa.
 type LoginResponse struct {
-    SessionID string `json:"sessionId"`
-    User      UserProfileDto `json:"user"`
+    SessionID         string         `json:"sessionId,omitempty"`
+    RefreshToken      string         `json:"refreshToken,omitempty"`
+    TwoFactorRequired bool           `json:"twoFactorRequired,omitempty"`
+    PendingToken      string         `json:"pendingToken,omitempty"`
+    User              UserProfileDto `json:"user"`
+    // Reauthenticated is set when a signed in user confirmed their identity at the
+    // identity provider, no new session is started then
+    Reauthenticated   bool           `json:"-"`
+}
+
+// TwoFactorLoginRequest completes a login with either an authenticator code or a recovery code
+// { "pendingToken": "...", "code": "123456" }
+type TwoFactorLoginRequest struct {
+    PendingToken string `json:"pendingToken" binding:"required"`
+    Code         string `json:"code"`
+    RecoveryCode string `json:"recoveryCode"`
+}
+
+type TOTPSetupResponse struct {
+    Secret          string `json:"secret"`
+    ProvisioningURI string `json:"provisioningUri"`
+}
+
+type TOTPCodeRequest struct {
+    Code string `json:"code" binding:"required"`
+}
+
+// ReauthRequest re-confirms the user's identity before changing two-factor settings.
+// Code may be an authenticator code or a recovery code. Accounts without a password
+// sign in at the provider again instead, see OIDCService.StartReauth.
+type ReauthRequest struct {
+    Password string `json:"password"`
+    Code     string `json:"code" binding:"required"`
+}
+
+type RecoveryCodesResponse struct {
+    RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// RefreshRequest is the optional request body for refreshing a session,
+// browsers send the refresh token as a cookie instead
+type RefreshRequest struct {
+    RefreshToken string `json:"refreshToken"`
 }
 
 type UserProfileDto struct {
     ID       string `json:"id"`
     Username string `json:"username"`
-    Email    string `json:"email"`
+    Email    string `json:"email,omitempty"` // left out where other users shouldn't see it
+    Role     string `json:"role,omitempty"` // collaborator role when listing collaborators
+
+    Name      string `json:"name,omitempty"`
+    AvatarURL string `json:"avatarUrl,omitempty"`
+    Timezone  string `json:"timezone,omitempty"`
+    Locale    string `json:"locale,omitempty"`
+    Bio       string `json:"bio,omitempty"`
+
+    // Only set on the user's own profile
+    TwoFactorEnabled bool   `json:"twoFactorEnabled,omitempty"`
+    EmailVerified    *bool  `json:"emailVerified,omitempty"`
+    PendingEmail     string `json:"pendingEmail,omitempty"`
+    Discoverability  string `json:"discoverability,omitempty"`
+}
+
+// UserSearchResult is a user found by user search. The email is masked unless the
+// searching user already shares a note with them.
+type UserSearchResult struct {
+    ID        string `json:"id"`
+    Username  string `json:"username"`
+    Name      string `json:"name"`
+    Email     string `json:"email"`
+    AvatarURL string `json:"avatarUrl,omitempty"`
+}
+
+type UserSearchResponse struct {
+    Users   []UserSearchResult `json:"users"`
+    Page    int                `json:"page"`
+    Limit   int                `json:"limit"`
+    HasMore bool               `json:"hasMore"`
+}
+
+// UpdateProfileRequest changes the fields that are set. Changing the email needs the
+// current password, or a fresh sign-in through /api/auth/oidc/reauth for accounts without
+// one, and only takes effect once the link sent to the new address is opened.
+// { "name": "Alice Doe", "timezone": "Europe/Berlin", "email": "alice@example.com", "currentPassword": "..." }
+type UpdateProfileRequest struct {
+    Name            *string `json:"name"`
+    Username        *string `json:"username"`
+    Email           *string `json:"email" binding:"omitempty,email"`
+    AvatarURL       *string `json:"avatarUrl"`
+    Timezone        *string `json:"timezone"`
+    Locale          *string `json:"locale"`
+    Bio             *string `json:"bio"`
+    Discoverability *string `json:"discoverability" binding:"omitempty,oneof=everyone collaborators nobody"`
+    CurrentPassword string  `json:"currentPassword"`
+}
+
+type VerifyEmailRequest struct {
+    Token string `json:"token" binding:"required"`
+}
+
+type ForgotPasswordRequest struct {
+    Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+    Token       string `json:"token" binding:"required"`
+    NewPassword string `json:"newPassword" binding:"required,min=6"`
+}
+
+// DeleteAccountRequest confirms deleting the account. Code is needed with 2FA enabled and
+// may be a recovery code. Accounts without a password confirm by signing in again at the
+// identity provider through /api/auth/oidc/reauth shortly before.
+type DeleteAccountRequest struct {
+    Password string `json:"password"`
+    Code     string `json:"code"`
 }
 
 type ChangePasswordRequest struct {
@@ -0,0 +1,75 @@
+package models
+
+import (
+    "time"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+    WebhookNoteCreated  = "note.created"
+    WebhookNoteUpdated  = "note.updated"
+    WebhookNoteTrashed  = "note.trashed"
+    WebhookNoteRestored = "note.restored"
+    WebhookNoteShared   = "note.shared"
+)
+
+var WebhookEvents = []string{
+    WebhookNoteCreated,
+    WebhookNoteUpdated,
+    WebhookNoteTrashed,
+    WebhookNoteRestored,
+    WebhookNoteShared,
+}
+
+const (
+    DeliveryPending   = "pending"
+    DeliveryDelivered = "delivered"
+    DeliveryDead      = "dead"
+)
+
+type Webhook struct {
+    ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    UserID    primitive.ObjectID `bson:"userId" json:"userId"`
+    URL       string             `bson:"url" json:"url"`
+    Secret    string             `bson:"secret" json:"-"`
+    Events    []string           `bson:"events" json:"events"` // empty means all events
+    CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// WebhookRequest is the request body for registering a webhook.
+// A secret is generated when none is given.
+// { "url": "https://example.com/hook", "secret": "...", "events": ["note.created"] }
+type WebhookRequest struct {
+    URL    string   `json:"url" binding:"required,url"`
+    Secret string   `json:"secret"`
+    Events []string `json:"events"`
+}
+
+// WebhookCreatedResponse is only returned once, it's the only time the secret is shown
+type WebhookCreatedResponse struct {
+    Webhook
+    Secret string `json:"secret"`
+}
+
+type WebhookDelivery struct {
+    ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    WebhookID      primitive.ObjectID `bson:"webhookId" json:"webhookId"`
+    UserID         primitive.ObjectID `bson:"userId" json:"userId"`
+    Event          string             `bson:"event" json:"event"`
+    Payload        string             `bson:"payload" json:"payload"`
+    Status         string             `bson:"status" json:"status"`
+    Attempts       int                `bson:"attempts" json:"attempts"`
+    ResponseStatus int                `bson:"responseStatus,omitempty" json:"responseStatus,omitempty"`
+    LastError      string             `bson:"lastError,omitempty" json:"lastError,omitempty"`
+    NextAttemptAt  time.Time          `bson:"nextAttemptAt" json:"nextAttemptAt"`
+    LockedUntil    *time.Time         `bson:"lockedUntil,omitempty" json:"-"`
+    DeliveredAt    *time.Time         `bson:"deliveredAt,omitempty" json:"deliveredAt,omitempty"`
+    CreatedAt      time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// WebhookPayload is the JSON body posted to webhook receivers
+type WebhookPayload struct {
+    Event      string       `json:"event"`
+    OccurredAt time.Time    `json:"occurredAt"`
+    Note       NoteResponse `json:"note"`
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+    "bytes"
+    htmltemplate "html/template"
+    texttemplate "text/template"
+)
+
+var passwordResetTextTemplate = texttemplate.Must(texttemplate.New("password_reset").Parse(
+`Hi {{.Name}},
+
+Someone asked to reset the password of your notes account. If it was you, open this
+link to choose a new password. It works once and expires in {{.ExpiresIn}}.
+
+{{.URL}}
+
+If you didn't ask for this you can ignore this email, your password won't change.
+`))
+
+var passwordResetHTMLTemplate = htmltemplate.Must(htmltemplate.New("password_reset").Parse(
+`<p>Hi {{.Name}},</p>
+<p>Someone asked to reset the password of your notes account. If it was you, use the link
+below to choose a new password. It works once and expires in {{.ExpiresIn}}.</p>
+<p><a href="{{.URL}}">Reset your password</a></p>
+<p>If you didn't ask for this you can ignore this email, your password won't change.</p>
+`))
+
+var verificationTextTemplate = texttemplate.Must(texttemplate.New("verification").Parse(
+`Hi {{.Name}},
+
+Please confirm that this is your email address by opening the link below.
+It expires in {{.ExpiresIn}}.
+
+{{.URL}}
+
+If you didn't create a notes account you can ignore this email.
+`))
+
+var verificationHTMLTemplate = htmltemplate.Must(htmltemplate.New("verification").Parse(
+`<p>Hi {{.Name}},</p>
+<p>Please confirm that this is your email address. The link expires in {{.ExpiresIn}}.</p>
+<p><a href="{{.URL}}">Verify your email</a></p>
+<p>If you didn't create a notes account you can ignore this email.</p>
+`))
+
+var lockoutTextTemplate = texttemplate.Must(texttemplate.New("lockout").Parse(
+`Hi {{.Name}},
+
+There were too many failed attempts to sign in to your notes account, so sign-in is
+locked for {{.ExpiresIn}}.
+
+If this wasn't you, someone may be guessing your password. You can choose a new one here:
+
+{{.URL}}
+`))
+
+var lockoutHTMLTemplate = htmltemplate.Must(htmltemplate.New("lockout").Parse(
+`<p>Hi {{.Name}},</p>
+<p>There were too many failed attempts to sign in to your notes account, so sign-in is
+locked for {{.ExpiresIn}}.</p>
+<p>If this wasn't you, someone may be guessing your password.
+<a href="{{.URL}}">Choose a new password</a></p>
+`))
+
+var emailChangeNoticeTextTemplate = texttemplate.Must(texttemplate.New("email_change_notice").Parse(
+`Hi {{.Name}},
+
+Someone asked to change the email address of your notes account to {{.Email}}.
+The change only happens once that address is confirmed.
+
+If this wasn't you, sign in and set your email back in your profile to cancel it,
+and choose a new password:
+
+{{.URL}}
+`))
+
+var emailChangeNoticeHTMLTemplate = htmltemplate.Must(htmltemplate.New("email_change_notice").Parse(
+`<p>Hi {{.Name}},</p>
+<p>Someone asked to change the email address of your notes account to {{.Email}}.
+The change only happens once that address is confirmed.</p>
+<p>If this wasn't you, sign in and set your email back in your profile to cancel it, and
+<a href="{{.URL}}">choose a new password</a>.</p>
+`))
+
+// accountEmailData is what the account email templates render
+type accountEmailData struct {
+    Name      string
+    URL       string
+    ExpiresIn string
+    Email     string
+}
+
+func renderAccountEmail(to, subject string, text *texttemplate.Template, html *htmltemplate.Template, data accountEmailData) (Email, error) {
+    var textBody, htmlBody bytes.Buffer
+    if err := text.Execute(&textBody, data); err != nil {
+        return Email{}, err
+    }
+    if err := html.Execute(&htmlBody, data); err != nil {
+        return Email{}, err
+    }
+    return Email{
+        To:      to,
+        Subject: subject,
+        Text:    textBody.String(),
+        HTML:    htmlBody.String(),
+    }, nil
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+    "archive/zip"
+    "context"
+    "encoding/json"
+    "errors"
+    "io"
+    "strconv"
+    "time"
+    "notes-app/config"
+    "notes-app/models"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "golang.org/x/crypto/bcrypt"
+)
+
+// ErrTwoFactorCodeRequired is returned when deleting an account with 2FA without a code
+var ErrTwoFactorCodeRequired = errors.New("two-factor code is required")
+
+// AccountService lets users delete their account and export their data.
+// Audit events are kept after deletion, they're the security record of the account.
+type AccountService struct {
+    sessionService   *SessionService
+    twoFactorService *TwoFactorService
+    noteService      *NoteService
+    commentService   *CommentService
+    auditService     *AuditService
+}
+
+func NewAccountService(sessionService *SessionService, twoFactorService *TwoFactorService, noteService *NoteService, commentService *CommentService, auditService *AuditService) *AccountService {
+    return &AccountService{
+        sessionService:   sessionService,
+        twoFactorService: twoFactorService,
+        noteService:      noteService,
+        commentService:   commentService,
+        auditService:     auditService,
+    }
+}
+
+// DeleteAccount permanently deletes the user after re-checking who they are: their
+// sessions, owned notes with everything attached to them, comments, templates, API keys,
+// webhooks and notifications. They are taken off notes others shared with them.
+func (s *AccountService) DeleteAccount(userID primitive.ObjectID, req models.DeleteAccountRequest, client models.ClientInfo) error {
+    ctx := context.Background()
+
+    var user models.User
+    if err := config.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+        return errors.New("user not found")
+    }
+    if user.Password != "" {
+        if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+            return ErrPasswordIncorrect
+        }
+    } else if !RecentlyReauthenticated(userID) {
+        return ErrRecentLoginRequired
+    }
+    if user.TOTPEnabled {
+        if req.Code == "" {
+            return ErrTwoFactorCodeRequired
+        }
+        code, recoveryCode := splitTwoFactorCode(req.Code)
+        if err := s.twoFactorService.Verify(user, code, recoveryCode, client); err != nil {
+            return err
+        }
+    }
+
+    revoked, err := s.sessionService.RevokeAll(userID, "")
+    if err != nil {
+        return err
+    }
+    notes, err := s.noteService.PurgeUserNotes(userID)
+    if err != nil {
+        return err
+    }
+    if err := s.noteService.RemoveUserFromNotes(userID); err != nil {
+        return err
+    }
+    if err := s.commentService.DeleteUserComments(userID); err != nil {
+        return err
+    }
+    if _, err := config.DB.Collection("templates").UpdateMany(ctx, bson.M{"sharedWith": userID}, bson.M{"$pull": bson.M{"sharedWith": userID}}); err != nil {
+        return err
+    }
+    for _, collection := range []string{"templates", "api_keys", "webhooks", "webhook_deliveries", "notifications", "notification_preferences", "note_mentions"} {
+        if _, err := config.DB.Collection(collection).DeleteMany(ctx, bson.M{"userId": userID}); err != nil {
+            return err
+        }
+    }
+    if _, err := config.DB.Collection("note_activity").DeleteMany(ctx, bson.M{"actorId": userID}); err != nil {
+        return err
+    }
+
+    // The user goes last, so a failure part way can be retried
+    if _, err := config.DB.Collection("users").DeleteOne(ctx, bson.M{"_id": userID}); err != nil {
+        return err
+    }
+
+    s.auditService.Record(&userID, models.AuditAccountDeleted, "user", userID.Hex(), client, map[string]string{
+        "username": user.Username,
+        "notes":    strconv.FormatInt(notes, 10),
+        "sessions": strconv.Itoa(revoked),
+    })
+    return nil
+}
+
+// exportProfile is profile.json in the data export
+type exportProfile struct {
+    ID               string                    `json:"id"`
+    Name             string                    `json:"name"`
+    Username         string                    `json:"username"`
+    Email            string                    `json:"email"`
+    Role             string                    `json:"role,omitempty"`
+    AvatarURL        string                    `json:"avatarUrl,omitempty"`
+    Timezone         string                    `json:"timezone,omitempty"`
+    Locale           string                    `json:"locale,omitempty"`
+    Bio              string                    `json:"bio,omitempty"`
+    PendingEmail     string                    `json:"pendingEmail,omitempty"`
+    Discoverability  string                    `json:"discoverability,omitempty"`
+    EmailVerified    bool                      `json:"emailVerified"`
+    EmailVerifiedAt  *time.Time                `json:"emailVerifiedAt,omitempty"`
+    TwoFactorEnabled bool                      `json:"twoFactorEnabled"`
+    Identities       []models.ExternalIdentity `json:"identities"`
+    CreatedAt        time.Time                 `json:"createdAt"`
+}
+
+// exportSharedNote is an entry of shared_notes.json. Only the title is included,
+// the content belongs to the owner.
+type exportSharedNote struct {
+    ID      primitive.ObjectID `json:"id"`
+    Title   string             `json:"title"`
+    OwnerID primitive.ObjectID `json:"ownerId"`
+    Role    string             `json:"role"`
+}
+
+// Export writes a zip archive with a JSON file for each kind of data stored about
+// the user. Secrets like password and key hashes are left out.
+func (s *AccountService) Export(userID primitive.ObjectID, client models.ClientInfo, w io.Writer) error {
+    ctx := context.Background()
+
+    var user models.User
+    if err := config.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+        return errors.New("user not found")
+    }
+
+    archive := zip.NewWriter(w)
+    write := func(name string, v interface{}) error {
+        file, err := archive.Create(name)
+        if err != nil {
+            return err
+        }
+        encoder := json.NewEncoder(file)
+        encoder.SetIndent("", "  ")
+        return encoder.Encode(v)
+    }
+    // find decodes the matching documents into results, a pointer to a slice, and writes them
+    find := func(name, collection string, filter bson.M, results interface{}) error {
+        cursor, err := config.DB.Collection(collection).Find(ctx, filter)
+        if err != nil {
+            return err
+        }
+        if err := cursor.All(ctx, results); err != nil {
+            return err
+        }
+        return write(name, results)
+    }
+
+    identities := user.Identities
+    if identities == nil {
+        identities = []models.ExternalIdentity{}
+    }
+    err := write("export.json", map[string]interface{}{
+        "userId":     userID.Hex(),
+        "exportedAt": time.Now(),
+    })
+    if err == nil {
+        err = write("profile.json", exportProfile{
+            ID:               user.ID.Hex(),
+            Name:             user.Name,
+            Username:         user.Username,
+            Email:            user.Email,
+            Role:             user.Role,
+            AvatarURL:        user.AvatarURL,
+            Timezone:         user.Timezone,
+            Locale:           user.Locale,
+            Bio:              user.Bio,
+            PendingEmail:     user.PendingEmail,
+            Discoverability:  user.Discoverability,
+            EmailVerified:    user.EmailVerified,
+            EmailVerifiedAt:  user.EmailVerifiedAt,
+            TwoFactorEnabled: user.TOTPEnabled,
+            Identities:       identities,
+            CreatedAt:        user.ID.Timestamp(),
+        })
+    }
+    if err != nil {
+        return err
+    }
+
+    notes := []models.Note{}
+    if err := find("notes.json", "notes", bson.M{"userId": userID}, &notes); err != nil {
+        return err
+    }
+    noteIDs := []primitive.ObjectID{}
+    for _, note := range notes {
+        noteIDs = append(noteIDs, note.ID)
+    }
+
+    var shared []models.Note
+    cursor, err := config.DB.Collection("notes").Find(ctx, bson.M{"collaborators": userID})
+    if err != nil {
+        return err
+    }
+    if err := cursor.All(ctx, &shared); err != nil {
+        return err
+    }
+    sharedNotes := []exportSharedNote{}
+    for _, note := range shared {
+        sharedNotes = append(sharedNotes, exportSharedNote{
+            ID:      note.ID,
+            Title:   note.Title,
+            OwnerID: note.UserID,
+            Role:    CollaboratorRole(note, userID),
+        })
+    }
+    if err := write("shared_notes.json", sharedNotes); err != nil {
+        return err
+    }
+
+    sessions, err := s.sessionService.List(userID, "")
+    if err != nil {
+        return err
+    }
+    if err := write("sessions.json", sessions); err != nil {
+        return err
+    }
+
+    exports := []struct {
+        name       string
+        collection string
+        filter     bson.M
+        results    interface{}
+    }{
+        {"note_versions.json", "note_versions", bson.M{"noteId": bson.M{"$in": noteIDs}}, &[]models.NoteVersion{}},
+        {"comments.json", "comments", bson.M{"authorId": userID}, &[]models.Comment{}},
+        {"mentions.json", "note_mentions", bson.M{"userId": userID}, &[]models.NoteMention{}},
+        {"templates.json", "templates", bson.M{"userId": userID}, &[]models.NoteTemplate{}},
+        {"api_keys.json", "api_keys", bson.M{"userId": userID}, &[]models.APIKey{}},
+        {"webhooks.json", "webhooks", bson.M{"userId": userID}, &[]models.Webhook{}},
+        {"notifications.json", "notifications", bson.M{"userId": userID}, &[]models.Notification{}},
+        {"notification_preferences.json", "notification_preferences", bson.M{"userId": userID}, &[]models.NotificationPreferences{}},
+        {"activity.json", "note_activity", bson.M{"actorId": userID}, &[]models.NoteActivity{}},
+        {"audit_events.json", "audit_events", bson.M{"actorId": userID}, &[]models.AuditEvent{}},
+    }
+    for _, export := range exports {
+        if err := find(export.name, export.collection, export.filter, export.results); err != nil {
+            return err
+        }
+    }
+
+    if err := archive.Close(); err != nil {
+        return err
+    }
+    s.auditService.Record(&userID, models.AuditDataExported, "user", userID.Hex(), client, nil)
+    return nil
+}
@@ -0,0 +1,362 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+    "notes-app/config"
+    "notes-app/models"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// autosaveCoalesceWindow groups consecutive autosaves by the same user into one timeline entry
+const autosaveCoalesceWindow = 10 * time.Minute
+
+type ActivityService struct{}
+
+func NewActivityService() *ActivityService {
+    return &ActivityService{}
+}
+
+func (s *ActivityService) EnsureIndexes() error {
+    ctx := context.Background()
+    _, err := config.DB.Collection("note_activity").Indexes().CreateMany(ctx, []mongo.IndexModel{
+        {Keys: bson.D{{Key: "noteId", Value: 1}, {Key: "createdAt", Value: -1}}},
+        // The timeline looks up which versions activities refer to
+        {Keys: bson.D{{Key: "versionId", Value: 1}}, Options: options.Index().SetSparse(true)},
+    })
+    return err
+}
+
+// Record stores a timeline entry. Failures are logged and never fail the action itself.
+func (s *ActivityService) Record(activity models.NoteActivity) {
+    ctx := context.Background()
+
+    activity.ID = primitive.NewObjectID()
+    activity.CreatedAt = time.Now()
+
+    if _, err := config.DB.Collection("note_activity").InsertOne(ctx, activity); err != nil {
+        log.Println("Failed to record activity for note", activity.NoteID.Hex(), err)
+    }
+}
+
+// RecordEdit records what changed between two states of a note. Nothing is recorded
+// if nothing changed. With coalesce set, the change is merged into the actor's
+// previous edit entry if it is recent enough, which keeps autosave from flooding the timeline.
+func (s *ActivityService) RecordEdit(before models.Note, after models.NoteRequest, actorID primitive.ObjectID, versionID *primitive.ObjectID, coalesce bool) {
+    ctx := context.Background()
+
+    var fields []string
+    if before.Title != after.Title {
+        fields = append(fields, "title")
+    }
+    if before.Content != after.Content {
+        fields = append(fields, "content")
+    }
+    added, removed := diffTags(before.Tags, after.Tags)
+    if len(added) > 0 || len(removed) > 0 {
+        fields = append(fields, "tags")
+    }
+    if len(fields) == 0 {
+        return
+    }
+
+    if coalesce {
+        var last models.NoteActivity
+        opts := options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+        err := config.DB.Collection("note_activity").FindOne(ctx, bson.M{"noteId": before.ID}, opts).Decode(&last)
+        if err == nil && last.Type == models.ActivityEdited && last.ActorID == actorID && time.Since(last.CreatedAt) < autosaveCoalesceWindow {
+            lastAdded, lastRemoved := mergeTagChanges(last.TagsAdded, last.TagsRemoved, added, removed)
+            _, err = config.DB.Collection("note_activity").UpdateOne(ctx, bson.M{"_id": last.ID}, bson.M{
+                "$set": bson.M{
+                    "fields":      mergeStrings(last.Fields, fields),
+                    "tagsAdded":   lastAdded,
+                    "tagsRemoved": lastRemoved,
+                    "createdAt":   time.Now(),
+                },
+            })
+            if err == nil {
+                return
+            }
+        }
+    }
+
+    s.Record(models.NoteActivity{
+        NoteID:      before.ID,
+        ActorID:     actorID,
+        Type:        models.ActivityEdited,
+        Fields:      fields,
+        TagsAdded:   added,
+        TagsRemoved: removed,
+        VersionID:   versionID,
+    })
+}
+
+// GetTimeline returns the note's activity merged with saved versions that no activity
+// refers to (versions from before the timeline existed), newest first
+func (s *ActivityService) GetTimeline(noteID, userID primitive.ObjectID, page, limit int) (models.ActivityListResponse, error) {
+    ctx := context.Background()
+
+    var note models.Note
+    err := config.DB.Collection("notes").FindOne(ctx, bson.M{
+        "_id": noteID,
+        "$or": []bson.M{
+            {"userId": userID},
+            {"collaborators": userID},
+        },
+    }).Decode(&note)
+    if err != nil {
+        return models.ActivityListResponse{}, errors.New("note not found or access denied")
+    }
+
+    // Versions no activity refers to are shaped like activities and merged in,
+    // so sorting and paging happen in the database
+    pipeline := mongo.Pipeline{
+        {{Key: "$match", Value: bson.M{"noteId": noteID}}},
+        {{Key: "$unionWith", Value: bson.M{
+            "coll": "note_versions",
+            "pipeline": bson.A{
+                bson.M{"$match": bson.M{"noteId": noteID}},
+                bson.M{"$lookup": bson.M{"from": "note_activity", "localField": "_id", "foreignField": "versionId", "as": "activities"}},
+                bson.M{"$match": bson.M{"activities": bson.M{"$size": 0}}},
+                bson.M{"$project": bson.M{
+                    "noteId":      1,
+                    "type":        bson.M{"$literal": models.ActivityVersionSaved},
+                    "versionId":   "$_id",
+                    "versionedAt": "$versionedAt",
+                    "createdAt":   "$versionedAt",
+                }},
+            },
+        }}},
+        {{Key: "$sort", Value: bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}}},
+        {{Key: "$facet", Value: bson.M{
+            "items": bson.A{bson.M{"$skip": (page - 1) * limit}, bson.M{"$limit": limit}},
+            "total": bson.A{bson.M{"$count": "count"}},
+        }}},
+    }
+    cursor, err := config.DB.Collection("note_activity").Aggregate(ctx, pipeline)
+    if err != nil {
+        return models.ActivityListResponse{}, err
+    }
+    var results []struct {
+        Items []models.NoteActivity `bson:"items"`
+        Total []struct {
+            Count int `bson:"count"`
+        } `bson:"total"`
+    }
+    if err = cursor.All(ctx, &results); err != nil {
+        return models.ActivityListResponse{}, err
+    }
+    var pageItems []models.NoteActivity
+    total := 0
+    if len(results) > 0 {
+        pageItems = results[0].Items
+        if len(results[0].Total) > 0 {
+            total = results[0].Total[0].Count
+        }
+    }
+
+    names, err := s.userNames(pageItems)
+    if err != nil {
+        return models.ActivityListResponse{}, err
+    }
+
+    responses := []models.ActivityResponse{}
+    for _, activity := range pageItems {
+        responses = append(responses, s.activityToResponse(activity, names))
+    }
+
+    return models.ActivityListResponse{
+        Activities: responses,
+        Total:      total,
+        Page:       page,
+        Limit:      limit,
+    }, nil
+}
+
+func (s *ActivityService) userNames(activities []models.NoteActivity) (map[primitive.ObjectID]string, error) {
+    ctx := context.Background()
+
+    var ids []primitive.ObjectID
+    for _, activity := range activities {
+        if !activity.ActorID.IsZero() {
+            ids = append(ids, activity.ActorID)
+        }
+        if activity.TargetUserID != nil {
+            ids = append(ids, *activity.TargetUserID)
+        }
+    }
+    names := map[primitive.ObjectID]string{}
+    if len(ids) == 0 {
+        return names, nil
+    }
+
+    cursor, err := config.DB.Collection("users").Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+    if err != nil {
+        return nil, err
+    }
+    var users []models.User
+    if err = cursor.All(ctx, &users); err != nil {
+        return nil, err
+    }
+    for _, user := range users {
+        names[user.ID] = user.Username
+    }
+    return names, nil
+}
+
+func (s *ActivityService) activityToResponse(activity models.NoteActivity, names map[primitive.ObjectID]string) models.ActivityResponse {
+    actor := names[activity.ActorID]
+    if actor == "" {
+        actor = "Someone"
+    }
+    target := ""
+    if activity.TargetUserID != nil {
+        target = names[*activity.TargetUserID]
+    }
+
+    var summary string
+    switch activity.Type {
+    case models.ActivityCreated:
+        summary = actor + " created the note"
+    case models.ActivityEdited:
+        summary = actor + " " + describeEdit(activity)
+    case models.ActivityPinned:
+        summary = actor + " pinned the note"
+    case models.ActivityUnpinned:
+        summary = actor + " unpinned the note"
+    case models.ActivityTrashed:
+        summary = actor + " moved the note to trash"
+    case models.ActivityRestored:
+        summary = actor + " restored the note from trash"
+    case models.ActivityShared:
+        summary = actor + " shared the note with " + target
+    case models.ActivityUnshared:
+        summary = actor + " removed " + target + " from the note"
+    case models.ActivityVersionRestored:
+        summary = actor + " restored an earlier version"
+        if activity.VersionedAt != nil {
+            summary = actor + " restored the version from " + activity.VersionedAt.Format("Mon Jan 2, 15:04")
+        }
+    case models.ActivityCommented:
+        summary = actor + " commented"
+    case models.ActivityVersionSaved:
+        summary = "A version was saved"
+    default:
+        summary = actor + " updated the note"
+    }
+
+    response := models.ActivityResponse{
+        ID:          activity.ID.Hex(),
+        Type:        activity.Type,
+        ActorName:   names[activity.ActorID],
+        Summary:     summary,
+        Fields:      activity.Fields,
+        TagsAdded:   activity.TagsAdded,
+        TagsRemoved: activity.TagsRemoved,
+        VersionedAt: activity.VersionedAt,
+        TargetUser:  target,
+        CreatedAt:   activity.CreatedAt,
+    }
+    if !activity.ActorID.IsZero() {
+        response.ActorID = activity.ActorID.Hex()
+    }
+    if activity.VersionID != nil {
+        response.VersionID = activity.VersionID.Hex()
+    }
+    if activity.CommentID != nil {
+        response.CommentID = activity.CommentID.Hex()
+    }
+    return response
+}
+
+// describeEdit turns an edit into e.g. "edited the title and added tag 'q3'"
+func describeEdit(activity models.NoteActivity) string {
+    var parts []string
+    var edited []string
+    for _, field := range activity.Fields {
+        if field != "tags" {
+            edited = append(edited, "the "+field)
+        }
+    }
+    if len(edited) > 0 {
+        parts = append(parts, "edited "+strings.Join(edited, " and "))
+    }
+    for _, tag := range activity.TagsAdded {
+        parts = append(parts, fmt.Sprintf("added tag '%s'", tag))
+    }
+    for _, tag := range activity.TagsRemoved {
+        parts = append(parts, fmt.Sprintf("removed tag '%s'", tag))
+    }
+    if len(parts) == 0 {
+        return "edited the note"
+    }
+    return strings.Join(parts, ", ")
+}
+
+func diffTags(before, after []string) ([]string, []string) {
+    var added, removed []string
+    for _, tag := range after {
+        if !containsString(before, tag) && !containsString(added, tag) {
+            added = append(added, tag)
+        }
+    }
+    for _, tag := range before {
+        if !containsString(after, tag) && !containsString(removed, tag) {
+            removed = append(removed, tag)
+        }
+    }
+    return added, removed
+}
+
+// mergeTagChanges combines two consecutive tag diffs into the net change,
+// e.g. a tag added by the first and removed by the second cancels out
+func mergeTagChanges(added1, removed1, added2, removed2 []string) ([]string, []string) {
+    var added, removed []string
+    for _, tag := range added1 {
+        if !containsString(removed2, tag) {
+            added = append(added, tag)
+        }
+    }
+    for _, tag := range added2 {
+        if !containsString(removed1, tag) && !containsString(added, tag) {
+            added = append(added, tag)
+        }
+    }
+    for _, tag := range removed1 {
+        if !containsString(added2, tag) {
+            removed = append(removed, tag)
+        }
+    }
+    for _, tag := range removed2 {
+        if !containsString(added1, tag) && !containsString(removed, tag) {
+            removed = append(removed, tag)
+        }
+    }
+    return added, removed
+}
+
+func mergeStrings(a, b []string) []string {
+    merged := append([]string{}, a...)
+    for _, value := range b {
+        if !containsString(merged, value) {
+            merged = append(merged, value)
+        }
+    }
+    return merged
+}
+
+func containsString(values []string, value string) bool {
+    for _, candidate := range values {
+        if candidate == value {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,284 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "regexp"
+    "strconv"
+    "time"
+    "notes-app/config"
+    "notes-app/models"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AdminService is account and data administration for admins. Every change is audited
+// with the admin as the actor and the user as the target.
+type AdminService struct {
+    authService    *AuthService
+    sessionService *SessionService
+    noteService    *NoteService
+    auditService   *AuditService
+}
+
+func NewAdminService(authService *AuthService, sessionService *SessionService, noteService *NoteService, auditService *AuditService) *AdminService {
+    return &AdminService{
+        authService:    authService,
+        sessionService: sessionService,
+        noteService:    noteService,
+        auditService:   auditService,
+    }
+}
+
+// ListUsers returns users whose name, username or email contains query, newest first
+func (s *AdminService) ListUsers(query string, page, limit int) (models.AdminUserListResponse, error) {
+    ctx := context.Background()
+
+    filter := bson.M{}
+    if query != "" {
+        pattern := bson.M{"$regex": regexp.QuoteMeta(query), "$options": "i"}
+        filter["$or"] = []bson.M{
+            {"username": pattern},
+            {"email": pattern},
+            {"name": pattern},
+        }
+    }
+    total, err := config.DB.Collection("users").CountDocuments(ctx, filter)
+    if err != nil {
+        return models.AdminUserListResponse{}, err
+    }
+
+    opts := options.Find().
+        SetSort(bson.D{{Key: "_id", Value: -1}}).
+        SetSkip(int64((page - 1) * limit)).
+        SetLimit(int64(limit))
+    cursor, err := config.DB.Collection("users").Find(ctx, filter, opts)
+    if err != nil {
+        return models.AdminUserListResponse{}, err
+    }
+    defer cursor.Close(ctx)
+    var users []models.User
+    if err := cursor.All(ctx, &users); err != nil {
+        return models.AdminUserListResponse{}, err
+    }
+
+    responses := []models.AdminUserResponse{}
+    for _, user := range users {
+        responses = append(responses, adminUserResponse(user))
+    }
+    return models.AdminUserListResponse{
+        Users: responses,
+        Total: total,
+        Page:  page,
+        Limit: limit,
+    }, nil
+}
+
+// SetRole makes a user an admin or a regular user. Admins can't change their own
+// role, so there is always at least one admin left.
+func (s *AdminService) SetRole(adminID, userID primitive.ObjectID, role string, client models.ClientInfo) error {
+    ctx := context.Background()
+
+    if role != models.RoleUser && role != models.RoleAdmin {
+        return errors.New("role must be user or admin")
+    }
+    if adminID == userID {
+        return errors.New("you can't change your own role")
+    }
+    user, err := s.findUser(userID)
+    if err != nil {
+        return err
+    }
+
+    _, err = config.DB.Collection("users").UpdateOne(ctx, bson.M{"_id": userID}, bson.M{
+        "$set": bson.M{"role": role},
+    })
+    if err != nil {
+        return err
+    }
+
+    s.auditService.Record(&adminID, models.AuditRoleChanged, "user", userID.Hex(), client, map[string]string{
+        "from": user.Role,
+        "to":   role,
+    })
+    return nil
+}
+
+// SetDisabled disables or re-enables an account. Disabling signs the user out everywhere.
+func (s *AdminService) SetDisabled(adminID, userID primitive.ObjectID, disabled bool, client models.ClientInfo) error {
+    ctx := context.Background()
+
+    if adminID == userID {
+        return errors.New("you can't disable your own account")
+    }
+    if _, err := s.findUser(userID); err != nil {
+        return err
+    }
+
+    update := bson.M{"$set": bson.M{"disabled": true, "disabledAt": time.Now()}}
+    action := models.AuditUserDisabled
+    if !disabled {
+        update = bson.M{"$unset": bson.M{"disabled": "", "disabledAt": ""}}
+        action = models.AuditUserEnabled
+    }
+    if _, err := config.DB.Collection("users").UpdateOne(ctx, bson.M{"_id": userID}, update); err != nil {
+        return err
+    }
+    s.auditService.Record(&adminID, action, "user", userID.Hex(), client, nil)
+
+    if disabled {
+        if _, err := s.RevokeSessions(adminID, userID, client); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// ForcePasswordReset signs the user out and keeps them from signing in
+// until they reset their password with the link emailed to them
+func (s *AdminService) ForcePasswordReset(adminID, userID primitive.ObjectID, client models.ClientInfo) error {
+    ctx := context.Background()
+
+    user, err := s.findUser(userID)
+    if err != nil {
+        return err
+    }
+    _, err = config.DB.Collection("users").UpdateOne(ctx, bson.M{"_id": userID}, bson.M{
+        "$set": bson.M{"passwordResetRequired": true},
+    })
+    if err != nil {
+        return err
+    }
+    if err := s.authService.sendPasswordReset(user); err != nil {
+        return err
+    }
+
+    s.auditService.Record(&adminID, models.AuditResetForced, "user", userID.Hex(), client, nil)
+    _, err = s.RevokeSessions(adminID, userID, client)
+    return err
+}
+
+// RevokeSessions signs the user out of every session and remember-me login
+func (s *AdminService) RevokeSessions(adminID, userID primitive.ObjectID, client models.ClientInfo) (int, error) {
+    revoked, err := s.sessionService.RevokeAll(userID, "")
+    if err != nil {
+        return revoked, err
+    }
+    s.auditService.Record(&adminID, models.AuditSessionRevoked, "user", userID.Hex(), client, map[string]string{
+        "reason":   "admin",
+        "sessions": strconv.Itoa(revoked),
+    })
+    return revoked, nil
+}
+
+func (s *AdminService) GetStats() (models.AdminStats, error) {
+    ctx := context.Background()
+
+    var stats models.AdminStats
+    counts := []struct {
+        target     *int64
+        collection string
+        filter     bson.M
+    }{
+        {&stats.Users, "users", bson.M{}},
+        {&stats.Admins, "users", bson.M{"role": models.RoleAdmin}},
+        {&stats.VerifiedUsers, "users", bson.M{"emailVerified": bson.M{"$ne": false}}},
+        {&stats.DisabledUsers, "users", bson.M{"disabled": true}},
+        {&stats.TwoFactorUsers, "users", bson.M{"totpEnabled": true}},
+        {&stats.SSOUsers, "users", bson.M{"identities.0": bson.M{"$exists": true}}},
+        {&stats.Notes, "notes", bson.M{"trashed": false}},
+        {&stats.TrashedNotes, "notes", bson.M{"trashed": true}},
+        {&stats.SharedNotes, "notes", bson.M{"trashed": false, "collaborators.0": bson.M{"$exists": true}}},
+        {&stats.NotesLast7Days, "notes", bson.M{"createdAt": bson.M{"$gte": time.Now().AddDate(0, 0, -7)}}},
+        {&stats.Comments, "comments", bson.M{}},
+        {&stats.APIKeys, "api_keys", bson.M{}},
+    }
+    for _, count := range counts {
+        n, err := config.DB.Collection(count.collection).CountDocuments(ctx, count.filter)
+        if err != nil {
+            return models.AdminStats{}, err
+        }
+        *count.target = n
+    }
+    return stats, nil
+}
+
+// TransferNotes makes another user the owner of every note the user owns. The new owner
+// stops being a collaborator on those notes, the other collaborators keep their access.
+func (s *AdminService) TransferNotes(adminID, fromID primitive.ObjectID, toUsername string, client models.ClientInfo) (int64, error) {
+    ctx := context.Background()
+
+    if _, err := s.findUser(fromID); err != nil {
+        return 0, err
+    }
+    var to models.User
+    if err := config.DB.Collection("users").FindOne(ctx, UsernameFilter(toUsername)).Decode(&to); err != nil {
+        return 0, errors.New("new owner not found")
+    }
+    if to.ID == fromID {
+        return 0, errors.New("notes already belong to this user")
+    }
+
+    result, err := config.DB.Collection("notes").UpdateMany(ctx, bson.M{"userId": fromID}, bson.M{
+        "$set":   bson.M{"userId": to.ID},
+        "$pull":  bson.M{"collaborators": to.ID},
+        "$unset": bson.M{"roles." + to.ID.Hex(): ""},
+    })
+    if err != nil {
+        return 0, err
+    }
+
+    s.auditService.Record(&adminID, models.AuditNotesMoved, "user", fromID.Hex(), client, map[string]string{
+        "toUserId": to.ID.Hex(),
+        "notes":    strconv.FormatInt(result.ModifiedCount, 10),
+    })
+    return result.ModifiedCount, nil
+}
+
+// DeleteNotes permanently deletes every note the user owns
+func (s *AdminService) DeleteNotes(adminID, userID primitive.ObjectID, client models.ClientInfo) (int64, error) {
+    if _, err := s.findUser(userID); err != nil {
+        return 0, err
+    }
+    deleted, err := s.noteService.PurgeUserNotes(userID)
+    if err != nil {
+        return deleted, err
+    }
+
+    s.auditService.Record(&adminID, models.AuditNotesDeleted, "user", userID.Hex(), client, map[string]string{
+        "notes": strconv.FormatInt(deleted, 10),
+    })
+    return deleted, nil
+}
+
+func (s *AdminService) findUser(userID primitive.ObjectID) (models.User, error) {
+    ctx := context.Background()
+
+    var user models.User
+    if err := config.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+        return models.User{}, errors.New("user not found")
+    }
+    return user, nil
+}
+
+func adminUserResponse(user models.User) models.AdminUserResponse {
+    role := user.Role
+    if role == "" {
+        role = models.RoleUser
+    }
+    return models.AdminUserResponse{
+        ID:                    user.ID.Hex(),
+        Name:                  user.Name,
+        Username:              user.Username,
+        Email:                 user.Email,
+        Role:                  role,
+        EmailVerified:         user.EmailVerified,
+        TwoFactorEnabled:      user.TOTPEnabled,
+        SingleSignOn:          len(user.Identities) > 0,
+        Disabled:              user.Disabled,
+        DisabledAt:            user.DisabledAt,
+        PasswordResetRequired: user.PasswordResetRequired,
+        CreatedAt:             user.ID.Timestamp(),
+    }
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "time"
+    "notes-app/config"
+    "notes-app/models"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// apiKeyUsageInterval limits how often lastUsedAt is written for a busy key
+const apiKeyUsageInterval = time.Minute
+
+type APIKeyService struct {
+    auditService *AuditService
+}
+
+func NewAPIKeyService(auditService *AuditService) *APIKeyService {
+    return &APIKeyService{auditService: auditService}
+}
+
+func (s *APIKeyService) EnsureIndexes() error {
+    ctx := context.Background()
+    _, err := config.DB.Collection("api_keys").Indexes().CreateMany(ctx, []mongo.IndexModel{
+        {Keys: bson.M{"keyHash": 1}, Options: options.Index().SetUnique(true)},
+        {Keys: bson.M{"userId": 1}},
+    })
+    return err
+}
+
+// CreateAPIKey generates a new key for the user. The key is returned once and only its hash is kept.
+func (s *APIKeyService) CreateAPIKey(userID primitive.ObjectID, req models.APIKeyRequest, client models.ClientInfo) (models.APIKeyCreatedResponse, error) {
+    ctx := context.Background()
+
+    scope := req.Scope
+    if scope == "" {
+        scope = models.ScopeRead
+    }
+    if scope != models.ScopeRead && scope != models.ScopeReadWrite {
+        return models.APIKeyCreatedResponse{}, errors.New("scope must be read or read-write")
+    }
+    if err := requireVerified(RestrictAPIKeys, userID); err != nil {
+        return models.APIKeyCreatedResponse{}, err
+    }
+
+    key := models.APIKeyPrefix + generateSessionID()
+    apiKey := models.APIKey{
+        ID:        primitive.NewObjectID(),
+        UserID:    userID,
+        Name:      req.Name,
+        KeyHash:   hashAPIKey(key),
+        Hint:      key[len(key)-4:],
+        Scope:     scope,
+        CreatedAt: time.Now(),
+    }
+
+    if _, err := config.DB.Collection("api_keys").InsertOne(ctx, apiKey); err != nil {
+        return models.APIKeyCreatedResponse{}, err
+    }
+
+    s.auditService.Record(&userID, models.AuditAPIKeyCreated, "api_key", apiKey.ID.Hex(), client, map[string]string{
+        "name":  apiKey.Name,
+        "scope": apiKey.Scope,
+    })
+
+    return models.APIKeyCreatedResponse{APIKey: apiKey, Key: key}, nil
+}
+
+func (s *APIKeyService) GetAPIKeys(userID primitive.ObjectID) ([]models.APIKey, error) {
+    ctx := context.Background()
+
+    opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+    cursor, err := config.DB.Collection("api_keys").Find(ctx, bson.M{"userId": userID}, opts)
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+
+    keys := []models.APIKey{}
+    if err = cursor.All(ctx, &keys); err != nil {
+        return nil, err
+    }
+    return keys, nil
+}
+
+// RevokeAPIKey deletes a key, requests using it fail from then on
+func (s *APIKeyService) RevokeAPIKey(keyID, userID primitive.ObjectID, client models.ClientInfo) error {
+    ctx := context.Background()
+
+    result, err := config.DB.Collection("api_keys").DeleteOne(ctx, bson.M{"_id": keyID, "userId": userID})
+    if err != nil || result.DeletedCount == 0 {
+        return errors.New("API key not found")
+    }
+
+    s.auditService.Record(&userID, models.AuditAPIKeyRevoked, "api_key", keyID.Hex(), client, nil)
+    return nil
+}
+
+// Authenticate returns the owner of an API key and the key's scope
+func (s *APIKeyService) Authenticate(key, ip string) (*models.User, string, error) {
+    ctx := context.Background()
+
+    var apiKey models.APIKey
+    err := config.DB.Collection("api_keys").FindOne(ctx, bson.M{"keyHash": hashAPIKey(key)}).Decode(&apiKey)
+    if err != nil {
+        return nil, "", errors.New("invalid API key")
+    }
+
+    var user models.User
+    err = config.DB.Collection("users").FindOne(ctx, bson.M{"_id": apiKey.UserID}).Decode(&user)
+    if err != nil || user.Disabled {
+        return nil, "", errors.New("invalid API key")
+    }
+
+    // Usage tracking is best effort, a failed write doesn't fail the request
+    now := time.Now()
+    config.DB.Collection("api_keys").UpdateOne(ctx, bson.M{
+        "_id": apiKey.ID,
+        "$or": []bson.M{
+            {"lastUsedAt": nil},
+            {"lastUsedAt": bson.M{"$lt": now.Add(-apiKeyUsageInterval)}},
+        },
+    }, bson.M{
+        "$set": bson.M{"lastUsedAt": now, "lastUsedIp": ip},
+    })
+
+    return &user, apiKey.Scope, nil
+}
+
+func hashAPIKey(key string) string {
+    sum := sha256.Sum256([]byte(key))
+    return hex.EncodeToString(sum[:])
+}
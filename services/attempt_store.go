@@ -0,0 +1,128 @@
+package services
+
+import (
+    "context"
+    "sync"
+    "time"
+    "notes-app/config"
+)
+
+// AttemptStore keeps short-lived counters and markers, e.g. for failed logins.
+// RedisAttemptStore is used in production, MemoryAttemptStore keeps everything in process.
+type AttemptStore interface {
+    // Increment adds one to a counter and returns the new value. A new counter expires after window.
+    Increment(key string, window time.Duration) (int64, error)
+    // Mark sets a marker that expires after ttl
+    Mark(key string, ttl time.Duration) error
+    // Remaining returns how long a marker has left, or 0 if it isn't set
+    Remaining(key string) (time.Duration, error)
+    Delete(keys ...string) error
+}
+
+type RedisAttemptStore struct{}
+
+func NewRedisAttemptStore() *RedisAttemptStore {
+    return &RedisAttemptStore{}
+}
+
+func (s *RedisAttemptStore) Increment(key string, window time.Duration) (int64, error) {
+    ctx := context.Background()
+    count, err := config.RedisClient.Incr(ctx, key).Result()
+    if err != nil {
+        return 0, err
+    }
+    if count == 1 {
+        config.RedisClient.Expire(ctx, key, window)
+    }
+    return count, nil
+}
+
+func (s *RedisAttemptStore) Mark(key string, ttl time.Duration) error {
+    ctx := context.Background()
+    return config.RedisClient.Set(ctx, key, 1, ttl).Err()
+}
+
+func (s *RedisAttemptStore) Remaining(key string) (time.Duration, error) {
+    ctx := context.Background()
+    ttl, err := config.RedisClient.PTTL(ctx, key).Result()
+    if err != nil {
+        return 0, err
+    }
+    // PTTL is negative for missing keys and keys without expiry
+    if ttl < 0 {
+        return 0, nil
+    }
+    return ttl, nil
+}
+
+func (s *RedisAttemptStore) Delete(keys ...string) error {
+    ctx := context.Background()
+    return config.RedisClient.Del(ctx, keys...).Err()
+}
+
+// MemoryAttemptStore keeps counters in memory. It is meant for tests and single-instance setups.
+type MemoryAttemptStore struct {
+    mu      sync.Mutex
+    entries map[string]memoryAttempt
+    now     func() time.Time
+}
+
+type memoryAttempt struct {
+    count   int64
+    expires time.Time
+}
+
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+    return &MemoryAttemptStore{entries: map[string]memoryAttempt{}, now: time.Now}
+}
+
+func (s *MemoryAttemptStore) Increment(key string, window time.Duration) (int64, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    entry, ok := s.get(key)
+    if !ok {
+        entry = memoryAttempt{expires: s.now().Add(window)}
+    }
+    entry.count++
+    s.entries[key] = entry
+    return entry.count, nil
+}
+
+func (s *MemoryAttemptStore) Mark(key string, ttl time.Duration) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.entries[key] = memoryAttempt{count: 1, expires: s.now().Add(ttl)}
+    return nil
+}
+
+func (s *MemoryAttemptStore) Remaining(key string) (time.Duration, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    entry, ok := s.get(key)
+    if !ok {
+        return 0, nil
+    }
+    return entry.expires.Sub(s.now()), nil
+}
+
+func (s *MemoryAttemptStore) Delete(keys ...string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for _, key := range keys {
+        delete(s.entries, key)
+    }
+    return nil
+}
+
+// get returns an unexpired entry, dropping it if it has expired. Callers hold mu.
+func (s *MemoryAttemptStore) get(key string) (memoryAttempt, bool) {
+    entry, ok := s.entries[key]
+    if !ok {
+        return memoryAttempt{}, false
+    }
+    if !s.now().Before(entry.expires) {
+        delete(s.entries, key)
+        return memoryAttempt{}, false
+    }
+    return entry, true
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+    "context"
+    "log"
+    "time"
+    "notes-app/config"
+    "notes-app/models"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditService writes to the append-only audit_events collection.
+// There is deliberately no way to update or delete events.
+type AuditService struct{}
+
+func NewAuditService() *AuditService {
+    return &AuditService{}
+}
+
+func (s *AuditService) EnsureIndexes() error {
+    ctx := context.Background()
+    _, err := config.DB.Collection("audit_events").Indexes().CreateMany(ctx, []mongo.IndexModel{
+        {Keys: bson.D{{Key: "actorId", Value: 1}, {Key: "createdAt", Value: -1}}},
+        {Keys: bson.D{{Key: "targetId", Value: 1}, {Key: "createdAt", Value: -1}}},
+        {Keys: bson.D{{Key: "action", Value: 1}, {Key: "createdAt", Value: -1}}},
+    })
+    return err
+}
+
+// Record stores an audit event. Failures are logged, auditing never blocks the action itself.
+func (s *AuditService) Record(actorID *primitive.ObjectID, action, targetType, targetID string, client models.ClientInfo, metadata map[string]string) {
+    ctx := context.Background()
+
+    event := models.AuditEvent{
+        ID:         primitive.NewObjectID(),
+        ActorID:    actorID,
+        Action:     action,
+        TargetType: targetType,
+        TargetID:   targetID,
+        IP:         client.IP,
+        UserAgent:  client.UserAgent,
+        Metadata:   metadata,
+        CreatedAt:  time.Now(),
+    }
+
+    if _, err := config.DB.Collection("audit_events").InsertOne(ctx, event); err != nil {
+        log.Println("Failed to record audit event", action, err)
+    }
+}
+
+// GetUserEvents returns events performed by the user or targeting their account
+func (s *AuditService) GetUserEvents(userID primitive.ObjectID, query models.AuditQuery) ([]models.AuditEvent, error) {
+    filter := s.buildFilter(query)
+    filter["$or"] = []bson.M{
+        {"actorId": userID},
+        {"targetType": "user", "targetId": userID.Hex()},
+    }
+    return s.find(filter, query)
+}
+
+// GetAllEvents returns events of all users, only for admins
+func (s *AuditService) GetAllEvents(query models.AuditQuery) ([]models.AuditEvent, error) {
+    filter := s.buildFilter(query)
+    if query.ActorID != nil {
+        filter["actorId"] = *query.ActorID
+    }
+    return s.find(filter, query)
+}
+
+func (s *AuditService) buildFilter(query models.AuditQuery) bson.M {
+    filter := bson.M{}
+    if query.Action != "" {
+        filter["action"] = query.Action
+    }
+    if query.TargetID != "" {
+        filter["targetId"] = query.TargetID
+    }
+    createdAt := bson.M{}
+    if query.Since != nil {
+        createdAt["$gte"] = *query.Since
+    }
+    if query.Until != nil {
+        createdAt["$lt"] = *query.Until
+    }
+    if len(createdAt) > 0 {
+        filter["createdAt"] = createdAt
+    }
+    return filter
+}
+
+func (s *AuditService) find(filter bson.M, query models.AuditQuery) ([]models.AuditEvent, error) {
+    ctx := context.Background()
+
+    opts := options.Find().
+        SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+        SetSkip(int64((query.Page - 1) * query.Limit)).
+        SetLimit(int64(query.Limit))
+
+    cursor, err := config.DB.Collection("audit_events").Find(ctx, filter, opts)
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+
+    events := []models.AuditEvent{}
+    if err = cursor.All(ctx, &events); err != nil {
+        return nil, err
+    }
+    return events, nil
+}
@@ -5,34 +5,137 @@ import (
     "crypto/rand"
     "encoding/hex"
     "errors"
+    "log"
+    "net/url"
+    "regexp"
+    "strconv"
+    "strings"
     "time"
+    "unicode/utf8"
     "notes-app/config"
     "notes-app/models"
+    "github.com/go-redis/redis/v8"
     "go.mongodb.org/mongo-driver/bson"
     "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
     "golang.org/x/crypto/bcrypt"
 )
 
-type AuthService struct{}
+var (
+    // ErrAccountDisabled is returned when a disabled account tries to sign in
+    ErrAccountDisabled = errors.New("account is disabled")
+    // ErrPasswordResetRequired is returned when an admin required a password reset before the next sign-in
+    ErrPasswordResetRequired = errors.New("a password reset is required, check your email for a reset link")
+)
+
+type AuthService struct {
+    auditService     *AuditService
+    sessionService   *SessionService
+    twoFactorService *TwoFactorService
+    verification     *EmailVerificationService
+    loginGuard       *LoginGuard
+    mailer           Mailer
+    resetTTL         time.Duration
+}
+
+func NewAuthService(auditService *AuditService, sessionService *SessionService, twoFactorService *TwoFactorService, verification *EmailVerificationService, loginGuard *LoginGuard, mailer Mailer) *AuthService {
+    return &AuthService{
+        auditService:     auditService,
+        sessionService:   sessionService,
+        twoFactorService: twoFactorService,
+        verification:     verification,
+        loginGuard:       loginGuard,
+        mailer:           mailer,
+        resetTTL:         config.GetEnvDuration("PASSWORD_RESET_TTL", time.Hour),
+    }
+}
+
+// PromoteAdmins gives the admin role to the given usernames to bootstrap the first admins
+// from config. It does nothing once any admin exists, so a listed name that's freed up or
+// registered later by someone else doesn't make them an admin on the next restart.
+func (s *AuthService) PromoteAdmins(usernames []string) error {
+    ctx := context.Background()
+    var names []string
+    for _, username := range usernames {
+        if username = strings.ToLower(strings.TrimSpace(username)); username != "" {
+            names = append(names, username)
+        }
+    }
+    if len(names) == 0 {
+        return nil
+    }
+    admins, err := config.DB.Collection("users").CountDocuments(ctx, bson.M{"role": models.RoleAdmin})
+    if err != nil || admins > 0 {
+        return err
+    }
+    result, err := config.DB.Collection("users").UpdateMany(ctx,
+        bson.M{"usernameLower": bson.M{"$in": names}},
+        bson.M{"$set": bson.M{"role": models.RoleAdmin}},
+    )
+    if err != nil {
+        return err
+    }
+    log.Println("Promoted", result.ModifiedCount, "users from ADMIN_USERNAMES to admin")
+    return nil
+}
 
-func NewAuthService() *AuthService {
-    return &AuthService{}
+// EnsureIndexes fills in the lowercased username of users created before it existed and
+// makes it unique, it's used for search as well as to keep usernames unique ignoring case.
+// An older non-unique index is replaced. If usernames differing only in case already exist
+// the unique index can't be built, the old one is put back and the error returned.
+func (s *AuthService) EnsureIndexes() error {
+    ctx := context.Background()
+    _, err := config.DB.Collection("users").UpdateMany(ctx,
+        bson.M{"usernameLower": bson.M{"$exists": false}},
+        mongo.Pipeline{{{Key: "$set", Value: bson.M{"usernameLower": bson.M{"$toLower": "$username"}}}}},
+    )
+    if err != nil {
+        return err
+    }
+
+    indexes := config.DB.Collection("users").Indexes()
+    unique := mongo.IndexModel{
+        Keys:    bson.D{{Key: "usernameLower", Value: 1}},
+        Options: options.Index().SetUnique(true),
+    }
+    _, err = indexes.CreateOne(ctx, unique)
+    if !isIndexConflict(err) {
+        return err
+    }
+    if _, err := indexes.DropOne(ctx, "usernameLower_1"); err != nil {
+        return err
+    }
+    if _, err := indexes.CreateOne(ctx, unique); err != nil {
+        indexes.CreateOne(ctx, mongo.IndexModel{Keys: unique.Keys})
+        return err
+    }
+    return nil
+}
+
+// isIndexConflict reports whether an index couldn't be created because one with the same
+// keys but other options exists
+func isIndexConflict(err error) bool {
+    var commandErr mongo.CommandError
+    return errors.As(err, &commandErr) && (commandErr.Code == 85 || commandErr.Code == 86)
 }
 
 func (s *AuthService) Register(req models.RegisterRequest) error {
     ctx := context.Background()
+    email := normalizeEmail(req.Email)
     
     // Check if email exists
     var existingUser models.User
-    err := config.DB.Collection("users").FindOne(ctx, bson.M{"email": req.Email}).Decode(&existingUser)
+    err := config.DB.Collection("users").FindOne(ctx, bson.M{"email": email}, options.FindOne().SetCollation(caseInsensitive)).Decode(&existingUser)
     if err == nil {
         return errors.New("email already exists")
     }
 
-    // Check if username exists
-    err = config.DB.Collection("users").FindOne(ctx, bson.M{"username": req.Username}).Decode(&existingUser)
+    // Check if username exists, usernames differing only in case would be confusing
+    // in mentions and sharing
+    err = config.DB.Collection("users").FindOne(ctx, UsernameFilter(req.Username)).Decode(&existingUser)
     if err == nil {
-        return errors.New("username already exists")
+        return errUsernameTaken
     }
 
     // Hash password
@@ -42,67 +145,273 @@ func (s *AuthService) Register(req models.RegisterRequest) error {
     }
 
     user := models.User{
-        ID:       primitive.NewObjectID(),
-        Name:     req.Name,
-        Email:    req.Email,
-        Username: req.Username,
-        Password: string(hashedPassword),
+        ID:            primitive.NewObjectID(),
+        Name:          req.Name,
+        Email:         email,
+        Username:      req.Username,
+        UsernameLower: strings.ToLower(req.Username),
+        Password:      string(hashedPassword),
     }
 
     _, err = config.DB.Collection("users").InsertOne(ctx, user)
-    return err
+    if mongo.IsDuplicateKeyError(err) {
+        return errUsernameTaken
+    }
+    if err != nil {
+        return err
+    }
+
+    // The account works without verification, so a failed email only needs a resend
+    if err := s.verification.SendVerification(user); err != nil {
+        log.Println("Failed to start email verification for user", user.ID.Hex(), err)
+    }
+    return nil
 }
 
-func (s *AuthService) Login(req models.LoginRequest) (models.LoginResponse, error) {
+// Login checks the password, refusing with a *LoginThrottledError while the
+// username or IP is slowed down or locked after failed attempts
+func (s *AuthService) Login(req models.LoginRequest, client models.ClientInfo) (models.LoginResponse, error) {
     ctx := context.Background()
+
+    if err := s.loginGuard.Check(req.Username, client.IP); err != nil {
+        return models.LoginResponse{}, err
+    }
     
     var user models.User
     err := config.DB.Collection("users").FindOne(ctx, bson.M{"username": req.Username}).Decode(&user)
     if err != nil {
+        s.auditService.Record(nil, models.AuditLoginFailed, "user", "", client, map[string]string{"username": req.Username})
+        s.loginFailed(req.Username, nil, client)
         return models.LoginResponse{}, errors.New("invalid credentials")
     }
 
     // Verify password
     err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
     if err != nil {
+        s.auditService.Record(nil, models.AuditLoginFailed, "user", user.ID.Hex(), client, map[string]string{"username": req.Username})
+        s.loginFailed(req.Username, &user, client)
         return models.LoginResponse{}, errors.New("invalid credentials")
     }
 
-    // Generate session ID
-    sessionID := generateSessionID()
-    
-    // Store session in Redis (expires in 24 hours)
-    err = config.RedisClient.Set(ctx, "session:"+sessionID, user.ID.Hex(), 24*time.Hour).Err()
+    if err := checkAccountUsable(user); err != nil {
+        return models.LoginResponse{}, err
+    }
+
+    // With 2FA the password only gets the user as far as a pending login, failures
+    // are only cleared once the code is accepted too
+    if user.TOTPEnabled {
+        pendingToken, err := s.twoFactorService.CreatePending(user.ID, req.RememberMe)
+        if err != nil {
+            return models.LoginResponse{}, err
+        }
+        return models.LoginResponse{TwoFactorRequired: true, PendingToken: pendingToken}, nil
+    }
+
+    response, err := s.startSession(user, req.RememberMe, client, map[string]string{"method": "password"})
     if err != nil {
         return models.LoginResponse{}, err
     }
+    s.loginSucceeded(user.Username)
+    return response, nil
+}
+
+// loginSucceeded clears the failed logins of a username after a complete login
+func (s *AuthService) loginSucceeded(username string) {
+    if err := s.loginGuard.RecordSuccess(username); err != nil {
+        log.Println("Failed to clear login failures of", username, err)
+    }
+}
+
+// loginFailed counts a failed login and tells the account owner if it locked their account.
+// Failing to count is logged rather than turned into an error for the user.
+func (s *AuthService) loginFailed(username string, user *models.User, client models.ClientInfo) {
+    locked, err := s.loginGuard.RecordFailure(username, client.IP)
+    if err != nil {
+        log.Println("Failed to record login failure of", username, err)
+        return
+    }
+    if !locked || user == nil {
+        return
+    }
+
+    s.auditService.Record(nil, models.AuditAccountLocked, "user", user.ID.Hex(), client, map[string]string{"username": user.Username})
+    go func(user models.User) {
+        message, err := renderAccountEmail(user.Email, "Your account was locked", lockoutTextTemplate, lockoutHTMLTemplate, accountEmailData{
+            Name:      user.Name,
+            URL:       config.GetEnv("APP_BASE_URL", "http://localhost:5173") + "/forgot-password",
+            ExpiresIn: humanDuration(s.loginGuard.LockoutDuration()),
+        })
+        if err == nil {
+            err = s.mailer.Send(message)
+        }
+        if err != nil {
+            log.Println("Failed to send lockout email to user", user.ID.Hex(), err)
+        }
+    }(*user)
+}
+
+// UnlockUser lets an admin lift a login lockout before it runs out
+func (s *AuthService) UnlockUser(adminID, userID primitive.ObjectID, client models.ClientInfo) error {
+    ctx := context.Background()
+
+    var user models.User
+    if err := config.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+        return errors.New("user not found")
+    }
+    if err := s.loginGuard.Unlock(user.Username); err != nil {
+        return err
+    }
+
+    s.auditService.Record(&adminID, models.AuditAccountUnlocked, "user", userID.Hex(), client, map[string]string{"username": user.Username})
+    return nil
+}
+
+// CompleteTwoFactorLogin finishes a pending login with an authenticator or recovery code.
+// Wrong codes count as failed logins of the username, so they're throttled and lock the
+// account the same way wrong passwords do, across pending logins.
+func (s *AuthService) CompleteTwoFactorLogin(req models.TwoFactorLoginRequest, client models.ClientInfo) (models.LoginResponse, error) {
+    ctx := context.Background()
+
+    if req.Code == "" && req.RecoveryCode == "" {
+        return models.LoginResponse{}, errors.New("code or recovery code is required")
+    }
+
+    userID, rememberMe, err := s.twoFactorService.LookupPending(req.PendingToken)
+    if err != nil {
+        return models.LoginResponse{}, errors.New("login expired, please sign in again")
+    }
+
+    var user models.User
+    err = config.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+    if err != nil {
+        return models.LoginResponse{}, errors.New("login expired, please sign in again")
+    }
+    if err := s.loginGuard.Check(user.Username, client.IP); err != nil {
+        return models.LoginResponse{}, err
+    }
+
+    if err := s.twoFactorService.Verify(user, req.Code, req.RecoveryCode, client); err != nil {
+        s.auditService.Record(nil, models.AuditLoginFailed, "user", user.ID.Hex(), client, map[string]string{
+            "username": user.Username,
+            "reason":   "invalid_2fa_code",
+        })
+        s.loginFailed(user.Username, &user, client)
+        if s.twoFactorService.FailPending(req.PendingToken) {
+            return models.LoginResponse{}, errors.New("too many invalid codes, please sign in again")
+        }
+        return models.LoginResponse{}, err
+    }
+    if !s.twoFactorService.CompletePending(req.PendingToken) {
+        return models.LoginResponse{}, errors.New("login expired, please sign in again")
+    }
+
+    method := "totp"
+    if req.RecoveryCode != "" {
+        method = "recovery_code"
+    }
+    response, err := s.startSession(user, rememberMe, client, map[string]string{"method": method})
+    if err != nil {
+        return models.LoginResponse{}, err
+    }
+    s.loginSucceeded(user.Username)
+    return response, nil
+}
+
+// startSession signs the user in and audits the login
+func (s *AuthService) startSession(user models.User, rememberMe bool, client models.ClientInfo, metadata map[string]string) (models.LoginResponse, error) {
+    if err := checkAccountUsable(user); err != nil {
+        return models.LoginResponse{}, err
+    }
+
+    var sessionID, refreshToken string
+    var err error
+    if rememberMe {
+        sessionID, refreshToken, err = s.sessionService.CreateRemembered(user.ID, client)
+    } else {
+        sessionID, err = s.sessionService.Create(user.ID, client)
+    }
+    if err != nil {
+        return models.LoginResponse{}, err
+    }
+
+    s.auditService.Record(&user.ID, models.AuditLogin, "user", user.ID.Hex(), client, metadata)
 
     response := models.LoginResponse{
-        SessionID: sessionID,
-        User: models.UserProfileDto{
-            ID:       user.ID.Hex(),
-            Username: user.Username,
-            Email:    user.Email,
-        },
+        SessionID:    sessionID,
+        RefreshToken: refreshToken,
+        User:         UserProfile(user),
     }
 
     return response, nil
 }
 
-func (s *AuthService) Logout(sessionID string) error {
+// Logout ends the session and, for remember-me logins, the refresh token. Either may be empty.
+func (s *AuthService) Logout(sessionID, refreshToken string, client models.ClientInfo) error {
     ctx := context.Background()
-    return config.RedisClient.Del(ctx, "session:"+sessionID).Err()
+    if refreshToken != "" {
+        if err := s.sessionService.RevokeRefreshToken(refreshToken); err != nil {
+            return err
+        }
+    }
+    if sessionID == "" {
+        return nil
+    }
+    userID, _ := config.RedisClient.Get(ctx, "session:"+sessionID).Result()
+    if objID, err := primitive.ObjectIDFromHex(userID); err == nil {
+        s.auditService.Record(&objID, models.AuditLogout, "user", userID, client, nil)
+    }
+    return s.sessionService.Delete(sessionID)
 }
 
-func (s *AuthService) GetUserBySession(sessionID string) (*models.User, error) {
+// Refresh exchanges a remember-me refresh token for a new session and refresh token
+func (s *AuthService) Refresh(refreshToken string, client models.ClientInfo) (models.LoginResponse, error) {
     ctx := context.Background()
-    
-    userID, err := config.RedisClient.Get(ctx, "session:"+sessionID).Result()
+
+    userID, sessionID, newToken, err := s.sessionService.Refresh(refreshToken, client)
+    if err == ErrRefreshTokenReused {
+        // The family's owner is recorded as the actor since whoever holds the copy acts as them
+        if userID.IsZero() {
+            s.auditService.Record(nil, models.AuditRefreshReused, "user", "", client, nil)
+        } else {
+            s.auditService.Record(&userID, models.AuditRefreshReused, "user", userID.Hex(), client, nil)
+        }
+    }
     if err != nil {
-        return nil, errors.New("invalid session")
+        return models.LoginResponse{}, err
     }
 
-    objID, err := primitive.ObjectIDFromHex(userID)
+    var user models.User
+    err = config.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+    if err == nil {
+        err = checkAccountUsable(user)
+    }
+    if err != nil {
+        s.sessionService.Delete(sessionID)
+        s.sessionService.RevokeRefreshToken(newToken)
+        return models.LoginResponse{}, errors.New("invalid refresh token")
+    }
+
+    return models.LoginResponse{
+        SessionID:    sessionID,
+        RefreshToken: newToken,
+        User:         UserProfile(user),
+    }, nil
+}
+
+// SessionCookieMaxAge is the max-age, in seconds, of the session cookie
+func (s *AuthService) SessionCookieMaxAge() int {
+    return int(s.sessionService.MaxLifetime().Seconds())
+}
+
+// RefreshCookieMaxAge is the max-age, in seconds, of the refresh token cookie
+func (s *AuthService) RefreshCookieMaxAge() int {
+    return int(s.sessionService.RememberLifetime().Seconds())
+}
+
+func (s *AuthService) GetUserBySession(sessionID string) (*models.User, error) {
+    ctx := context.Background()
+    
+    objID, err := s.sessionService.Lookup(sessionID)
     if err != nil {
         return nil, err
     }
@@ -112,29 +421,35 @@ func (s *AuthService) GetUserBySession(sessionID string) (*models.User, error) {
     if err != nil {
         return nil, err
     }
+    if user.Disabled {
+        return nil, ErrAccountDisabled
+    }
 
     return &user, nil
 }
 
-func (s *AuthService) ChangePassword(userID primitive.ObjectID, req models.ChangePasswordRequest) error {
+// ChangePassword sets a new password and ends every session of the user, since one of
+// them may belong to whoever learned the old password. The caller gets a new session,
+// whose ID is returned.
+func (s *AuthService) ChangePassword(userID primitive.ObjectID, req models.ChangePasswordRequest, client models.ClientInfo) (string, error) {
     ctx := context.Background()
     
     var user models.User
     err := config.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
     if err != nil {
-        return err
+        return "", err
     }
 
     // Verify old password
     err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.OldPassword))
     if err != nil {
-        return errors.New("old password is incorrect")
+        return "", errors.New("old password is incorrect")
     }
 
     // Hash new password
     hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
     if err != nil {
-        return err
+        return "", err
     }
 
     // Update password
@@ -143,29 +458,513 @@ func (s *AuthService) ChangePassword(userID primitive.ObjectID, req models.Chang
         bson.M{"_id": userID},
         bson.M{"$set": bson.M{"password": string(hashedPassword)}},
     )
+    if err != nil {
+        return "", err
+    }
+
+    s.auditService.Record(&userID, models.AuditPasswordChanged, "user", userID.Hex(), client, nil)
 
-    return err
+    revoked, err := s.sessionService.RevokeAll(userID, "")
+    if err != nil {
+        return "", err
+    }
+    s.auditService.Record(&userID, models.AuditSessionRevoked, "user", userID.Hex(), client, map[string]string{
+        "reason":   "password_changed",
+        "sessions": strconv.Itoa(revoked),
+    })
+
+    return s.sessionService.Create(userID, client)
 }
 
-func (s *AuthService) SearchUsers(query string) ([]models.User, error) {
+var (
+    usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,30}$`)
+    localePattern   = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+)
+
+// normalizeEmail is the form emails are stored and looked up in
+func normalizeEmail(email string) string {
+    return strings.ToLower(strings.TrimSpace(email))
+}
+
+// caseInsensitive compares strings ignoring case, for email lookups, since accounts created
+// before emails were normalized may use capitals. Usernames use usernameLower instead.
+var caseInsensitive = &options.Collation{Locale: "en", Strength: 2}
+
+// UpdateProfile changes the profile fields set in req and returns the updated profile.
+// A new email is kept as pending and a verification link is sent to it, the account
+// keeps the old email until the link is opened.
+func (s *AuthService) UpdateProfile(userID primitive.ObjectID, req models.UpdateProfileRequest, client models.ClientInfo) (models.UserProfileDto, error) {
+    ctx := context.Background()
+
+    var user models.User
+    if err := config.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+        return models.UserProfileDto{}, errors.New("user not found")
+    }
+
+    set := bson.M{}
+    unset := bson.M{}
+    // setOptional sets field to value, or removes it when value is empty
+    setOptional := func(field, value string) {
+        if value == "" {
+            unset[field] = ""
+            return
+        }
+        set[field] = value
+    }
+
+    if req.Name != nil {
+        name := strings.TrimSpace(*req.Name)
+        if name == "" || utf8.RuneCountInString(name) > 100 {
+            return models.UserProfileDto{}, errors.New("name must be 1 to 100 characters")
+        }
+        set["name"] = name
+        user.Name = name
+    }
+    if req.AvatarURL != nil {
+        avatarURL := strings.TrimSpace(*req.AvatarURL)
+        if avatarURL != "" {
+            parsed, err := url.Parse(avatarURL)
+            if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || len(avatarURL) > 2048 {
+                return models.UserProfileDto{}, errors.New("avatar must be an http or https URL")
+            }
+        }
+        setOptional("avatarUrl", avatarURL)
+        user.AvatarURL = avatarURL
+    }
+    if req.Timezone != nil {
+        timezone := strings.TrimSpace(*req.Timezone)
+        if timezone != "" {
+            if _, err := time.LoadLocation(timezone); err != nil || timezone == "Local" {
+                return models.UserProfileDto{}, errors.New("unknown timezone")
+            }
+        }
+        setOptional("timezone", timezone)
+        user.Timezone = timezone
+    }
+    if req.Locale != nil {
+        locale := strings.TrimSpace(*req.Locale)
+        if locale != "" && !localePattern.MatchString(locale) {
+            return models.UserProfileDto{}, errors.New("locale must be a language tag like en or en-GB")
+        }
+        setOptional("locale", locale)
+        user.Locale = locale
+    }
+    if req.Bio != nil {
+        bio := strings.TrimSpace(*req.Bio)
+        if utf8.RuneCountInString(bio) > 500 {
+            return models.UserProfileDto{}, errors.New("bio must be at most 500 characters")
+        }
+        setOptional("bio", bio)
+        user.Bio = bio
+    }
+
+    previousUsername := user.Username
+    if req.Username != nil && *req.Username != user.Username {
+        username := strings.TrimSpace(*req.Username)
+        if !usernamePattern.MatchString(username) {
+            return models.UserProfileDto{}, errors.New("username must be 3 to 30 letters, digits, dots, dashes or underscores")
+        }
+        filter := UsernameFilter(username)
+        filter["_id"] = bson.M{"$ne": userID}
+        taken, err := config.DB.Collection("users").CountDocuments(ctx, filter)
+        if err != nil {
+            return models.UserProfileDto{}, err
+        }
+        if taken > 0 {
+            return models.UserProfileDto{}, errUsernameTaken
+        }
+        set["username"] = username
+        set["usernameLower"] = strings.ToLower(username)
+        user.Username = username
+    }
+
+    if req.Discoverability != nil {
+        setOptional("discoverability", *req.Discoverability)
+        user.Discoverability = *req.Discoverability
+    }
+
+    newEmail := ""
+    if req.Email != nil {
+        email := normalizeEmail(*req.Email)
+        if strings.EqualFold(email, user.Email) {
+            // Going back to the current email cancels a pending change
+            unset["pendingEmail"] = ""
+            user.PendingEmail = ""
+        } else if email != user.PendingEmail {
+            // Whoever holds a stolen session shouldn't be able to take over the account by email
+            if user.Password != "" && bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)) != nil {
+                return models.UserProfileDto{}, errors.New("current password is incorrect")
+            }
+            taken, err := config.DB.Collection("users").CountDocuments(ctx, bson.M{"email": email, "_id": bson.M{"$ne": userID}},
+                options.Count().SetCollation(caseInsensitive))
+            if err != nil {
+                return models.UserProfileDto{}, err
+            }
+            if taken > 0 {
+                return models.UserProfileDto{}, errors.New("email already exists")
+            }
+            // Without a password the owner confirms by signing in at the provider again.
+            // Checked last since each confirmation is good for one change.
+            if user.Password == "" && !RecentlyReauthenticated(userID) {
+                return models.UserProfileDto{}, ErrRecentLoginRequired
+            }
+            set["pendingEmail"] = email
+            user.PendingEmail = email
+            newEmail = email
+        }
+    }
+
+    update := bson.M{}
+    if len(set) > 0 {
+        update["$set"] = set
+    }
+    if len(unset) > 0 {
+        update["$unset"] = unset
+    }
+    if len(update) > 0 {
+        _, err := config.DB.Collection("users").UpdateOne(ctx, bson.M{"_id": userID}, update)
+        if mongo.IsDuplicateKeyError(err) {
+            return models.UserProfileDto{}, errUsernameTaken
+        }
+        if err != nil {
+            return models.UserProfileDto{}, err
+        }
+    }
+
+    if user.Username != previousUsername {
+        s.auditService.Record(&userID, models.AuditUsernameChanged, "user", userID.Hex(), client, map[string]string{
+            "from": previousUsername,
+            "to":   user.Username,
+        })
+    }
+    if newEmail != "" {
+        if err := s.verification.SendEmailChange(user, newEmail); err != nil {
+            return models.UserProfileDto{}, err
+        }
+    }
+    return UserProfile(user), nil
+}
+
+// RequestPasswordReset emails a reset link if an account uses the email. The outcome is
+// the same whether or not it does. All the work, the lookup included, happens in the
+// background so the response time doesn't give it away either.
+//
+// Reset tokens are stored hashed as password_reset:<hash> -> user ID. A new request
+// replaces the user's previous token, and requests are limited to one a minute per user.
+func (s *AuthService) RequestPasswordReset(email string, client models.ClientInfo) {
+    go s.requestPasswordReset(normalizeEmail(email), client)
+}
+
+func (s *AuthService) requestPasswordReset(email string, client models.ClientInfo) {
     ctx := context.Background()
-    // Search by username or email, case-insensitive, partial match
-    filter := bson.M{"$or": []bson.M{
-        {"username": bson.M{"$regex": query, "$options": "i"}},
-        {"email": bson.M{"$regex": query, "$options": "i"}},
-    }}
-    cursor, err := config.DB.Collection("users").Find(ctx, filter)
+
+    var user models.User
+    err := config.DB.Collection("users").FindOne(ctx, bson.M{"email": email}, options.FindOne().SetCollation(caseInsensitive)).Decode(&user)
     if err != nil {
-        return nil, err
+        return
+    }
+
+    allowed, err := config.RedisClient.SetNX(ctx, "password_reset_cooldown:"+user.ID.Hex(), 1, time.Minute).Result()
+    if err != nil || !allowed {
+        return
+    }
+
+    if err := s.sendPasswordReset(user); err != nil {
+        log.Println("Failed to store password reset token:", err)
+        return
+    }
+    s.auditService.Record(&user.ID, models.AuditResetRequested, "user", user.ID.Hex(), client, nil)
+}
+
+// sendPasswordReset emails the user a new reset link, replacing any previous one
+func (s *AuthService) sendPasswordReset(user models.User) error {
+    ctx := context.Background()
+
+    token := generateSessionID()
+    hash := hashToken(token)
+    previous, _ := config.RedisClient.Get(ctx, "password_reset_user:"+user.ID.Hex()).Result()
+    _, err := config.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+        if previous != "" {
+            pipe.Del(ctx, "password_reset:"+previous)
+        }
+        pipe.Set(ctx, "password_reset:"+hash, user.ID.Hex(), s.resetTTL)
+        pipe.Set(ctx, "password_reset_user:"+user.ID.Hex(), hash, s.resetTTL)
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+
+    go func() {
+        message, err := renderAccountEmail(user.Email, "Reset your password", passwordResetTextTemplate, passwordResetHTMLTemplate, accountEmailData{
+            Name:      user.Name,
+            URL:       config.GetEnv("APP_BASE_URL", "http://localhost:5173") + "/reset-password?token=" + token,
+            ExpiresIn: humanDuration(s.resetTTL),
+        })
+        if err == nil {
+            err = s.mailer.Send(message)
+        }
+        if err != nil {
+            log.Println("Failed to send password reset email to user", user.ID.Hex(), err)
+        }
+    }()
+    return nil
+}
+
+// ResetPassword sets a new password with a token from RequestPasswordReset. The token
+// is consumed whether or not the reset succeeds, and every session of the user is ended.
+func (s *AuthService) ResetPassword(req models.ResetPasswordRequest, client models.ClientInfo) error {
+    ctx := context.Background()
+
+    hash := hashToken(req.Token)
+    userID, err := config.RedisClient.GetDel(ctx, "password_reset:"+hash).Result()
+    if err != nil {
+        return errors.New("reset link is invalid or has expired")
+    }
+    objID, err := primitive.ObjectIDFromHex(userID)
+    if err != nil {
+        return errors.New("reset link is invalid or has expired")
+    }
+    config.RedisClient.Del(ctx, "password_reset_user:"+userID)
+
+    hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+    if err != nil {
+        return err
+    }
+    result, err := config.DB.Collection("users").UpdateOne(ctx, bson.M{"_id": objID}, bson.M{
+        "$set":   bson.M{"password": string(hashedPassword)},
+        "$unset": bson.M{"passwordResetRequired": ""},
+    })
+    if err != nil {
+        return err
+    }
+    if result.MatchedCount == 0 {
+        return errors.New("reset link is invalid or has expired")
+    }
+
+    s.auditService.Record(&objID, models.AuditPasswordReset, "user", userID, client, nil)
+
+    revoked, err := s.sessionService.RevokeAll(objID, "")
+    if err != nil {
+        return err
+    }
+    s.auditService.Record(&objID, models.AuditSessionRevoked, "user", userID, client, map[string]string{
+        "reason":   "password_reset",
+        "sessions": strconv.Itoa(revoked),
+    })
+    return nil
+}
+
+// ListSessions returns the user's active sessions, marking the one with currentSessionID
+func (s *AuthService) ListSessions(userID primitive.ObjectID, currentSessionID string) ([]models.Session, error) {
+    return s.sessionService.List(userID, currentSessionID)
+}
+
+// RevokeSession ends one of the user's sessions by its public ID
+func (s *AuthService) RevokeSession(userID primitive.ObjectID, id string, client models.ClientInfo) error {
+    if err := s.sessionService.Revoke(userID, id); err != nil {
+        return err
+    }
+    s.auditService.Record(&userID, models.AuditSessionRevoked, "user", userID.Hex(), client, map[string]string{"sessionId": id})
+    return nil
+}
+
+// RevokeOtherSessions ends every session of the user except the current one
+func (s *AuthService) RevokeOtherSessions(userID primitive.ObjectID, currentSessionID string, client models.ClientInfo) (int, error) {
+    revoked, err := s.sessionService.RevokeAll(userID, currentSessionID)
+    if err != nil {
+        return revoked, err
+    }
+    s.auditService.Record(&userID, models.AuditSessionRevoked, "user", userID.Hex(), client, map[string]string{
+        "reason":   "revoke_others",
+        "sessions": strconv.Itoa(revoked),
+    })
+    return revoked, nil
+}
+
+// SearchUsers finds users whose username starts with query, ignoring case, for sharing
+// and mentions. Users only appear to the people their discoverability setting allows,
+// and their email is masked unless the searcher already shares a note with them.
+func (s *AuthService) SearchUsers(searcherID primitive.ObjectID, query string, page, limit int) (models.UserSearchResponse, error) {
+    ctx := context.Background()
+
+    related, err := s.relatedUsers(searcherID)
+    if err != nil {
+        return models.UserSearchResponse{}, err
+    }
+    relatedIDs := make([]primitive.ObjectID, 0, len(related))
+    for id := range related {
+        relatedIDs = append(relatedIDs, id)
+    }
+
+    // Anchored and case-sensitive on the lowercased username, so the index is used
+    filter := bson.M{
+        "usernameLower": bson.M{"$regex": "^" + regexp.QuoteMeta(strings.ToLower(query))},
+        "disabled":      bson.M{"$ne": true},
+        "$or": []bson.M{
+            {"discoverability": bson.M{"$in": bson.A{nil, models.DiscoverableEveryone}}},
+            {"discoverability": models.DiscoverableCollaborators, "_id": bson.M{"$in": relatedIDs}},
+        },
+    }
+    if UnverifiedRestricts(RestrictSearch) {
+        filter["emailVerified"] = bson.M{"$ne": false}
+    }
+    // One extra result tells whether there is another page
+    opts := options.Find().
+        SetSort(bson.D{{Key: "usernameLower", Value: 1}}).
+        SetSkip(int64((page - 1) * limit)).
+        SetLimit(int64(limit + 1))
+    cursor, err := config.DB.Collection("users").Find(ctx, filter, opts)
+    if err != nil {
+        return models.UserSearchResponse{}, err
     }
     defer cursor.Close(ctx)
     var users []models.User
+    if err := cursor.All(ctx, &users); err != nil {
+        return models.UserSearchResponse{}, err
+    }
+
+    response := models.UserSearchResponse{Users: []models.UserSearchResult{}, Page: page, Limit: limit}
+    if len(users) > limit {
+        users = users[:limit]
+        response.HasMore = true
+    }
+    for _, user := range users {
+        email := user.Email
+        if !related[user.ID] && user.ID != searcherID {
+            email = maskEmail(email)
+        }
+        response.Users = append(response.Users, models.UserSearchResult{
+            ID:        user.ID.Hex(),
+            Username:  user.Username,
+            Name:      user.Name,
+            Email:     email,
+            AvatarURL: user.AvatarURL,
+        })
+    }
+    return response, nil
+}
+
+// relatedUsers returns the users who share a note with the user, as owner or collaborator
+func (s *AuthService) relatedUsers(userID primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+    ctx := context.Background()
+
+    filter := bson.M{"$or": []bson.M{{"userId": userID}, {"collaborators": userID}}}
+    related := map[primitive.ObjectID]bool{}
+    for _, field := range []string{"userId", "collaborators"} {
+        values, err := config.DB.Collection("notes").Distinct(ctx, field, filter)
+        if err != nil {
+            return nil, err
+        }
+        for _, value := range values {
+            if id, ok := value.(primitive.ObjectID); ok && id != userID {
+                related[id] = true
+            }
+        }
+    }
+    return related, nil
+}
+
+// maskEmail keeps the first character and the domain, "alice@example.com" becomes "a****@example.com"
+func maskEmail(email string) string {
+    at := strings.LastIndex(email, "@")
+    if at < 1 {
+        return "****"
+    }
+    local := []rune(email[:at])
+    return string(local[0]) + "****" + email[at:]
+}
+
+// errUsernameTaken is also returned when the unique index catches a concurrent registration
+var errUsernameTaken = errors.New("username already exists")
+
+// UsernameFilter matches the user with a username. Usernames are unique ignoring case,
+// so every lookup by name goes through usernameLower.
+func UsernameFilter(username string) bson.M {
+    return bson.M{"usernameLower": strings.ToLower(strings.TrimSpace(username))}
+}
+
+// ResolveUsernames returns the users with the given usernames, ignoring case.
+// Unknown names are skipped.
+func (s *AuthService) ResolveUsernames(usernames []string) ([]models.User, error) {
+    ctx := context.Background()
+    if len(usernames) == 0 {
+        return []models.User{}, nil
+    }
+    lowered := make([]string, len(usernames))
+    for i, username := range usernames {
+        lowered[i] = strings.ToLower(strings.TrimSpace(username))
+    }
+    cursor, err := config.DB.Collection("users").Find(ctx, bson.M{"usernameLower": bson.M{"$in": lowered}})
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+    users := []models.User{}
     if err := cursor.All(ctx, &users); err != nil {
         return nil, err
     }
     return users, nil
 }
 
+// checkAccountUsable refuses new sessions for disabled accounts and accounts
+// an admin has required a password reset for
+func checkAccountUsable(user models.User) error {
+    if user.Disabled {
+        return ErrAccountDisabled
+    }
+    if user.PasswordResetRequired {
+        return ErrPasswordResetRequired
+    }
+    return nil
+}
+
+// UserProfile is the profile of the signed in user, with the fields only they get to see
+func UserProfile(user models.User) models.UserProfileDto {
+    profile := publicProfile(user)
+    profile.TwoFactorEnabled = user.TOTPEnabled
+    profile.EmailVerified = &user.EmailVerified
+    profile.PendingEmail = user.PendingEmail
+    profile.Discoverability = user.Discoverability
+    if profile.Discoverability == "" {
+        profile.Discoverability = models.DiscoverableEveryone
+    }
+    return profile
+}
+
+// publicProfile is how a user is shown to the people they collaborate with
+func publicProfile(user models.User) models.UserProfileDto {
+    return models.UserProfileDto{
+        ID:        user.ID.Hex(),
+        Username:  user.Username,
+        Email:     user.Email,
+        Name:      user.Name,
+        AvatarURL: user.AvatarURL,
+        Timezone:  user.Timezone,
+        Locale:    user.Locale,
+        Bio:       user.Bio,
+    }
+}
+
+// humanDuration formats durations like "1 hour" or "30 minutes" for emails and errors
+func humanDuration(d time.Duration) string {
+    plural := func(n int, unit string) string {
+        if n == 1 {
+            return "1 " + unit
+        }
+        return strconv.Itoa(n) + " " + unit + "s"
+    }
+    switch {
+    case d < time.Minute:
+        return plural(int((d+time.Second-1)/time.Second), "second")
+    case d >= 24*time.Hour && d%(24*time.Hour) == 0:
+        return plural(int(d/(24*time.Hour)), "day")
+    case d >= time.Hour && d%time.Hour == 0:
+        return plural(int(d/time.Hour), "hour")
+    }
+    return plural(int(d.Round(time.Minute)/time.Minute), "minute")
+}
+
 func generateSessionID() string {
     bytes := make([]byte, 32)
     rand.Read(bytes)
@@ -0,0 +1,534 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "log"
+    "strings"
+    "time"
+    "notes-app/config"
+    "notes-app/models"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type CommentService struct {
+    authService         *AuthService
+    notificationService *NotificationService
+    activityService     *ActivityService
+}
+
+func NewCommentService(authService *AuthService, notificationService *NotificationService, activityService *ActivityService) *CommentService {
+    return &CommentService{
+        authService:         authService,
+        notificationService: notificationService,
+        activityService:     activityService,
+    }
+}
+
+func (s *CommentService) EnsureIndexes() error {
+    ctx := context.Background()
+    _, err := config.DB.Collection("comments").Indexes().CreateMany(ctx, []mongo.IndexModel{
+        {Keys: bson.D{{Key: "noteId", Value: 1}, {Key: "createdAt", Value: 1}}},
+        {Keys: bson.M{"parentId": 1}},
+    })
+    return err
+}
+
+// GetComments returns the note's threads, oldest first, with replies nested under them
+func (s *CommentService) GetComments(noteID, userID primitive.ObjectID) ([]models.CommentResponse, error) {
+    ctx := context.Background()
+
+    if _, err := s.findNote(noteID, userID); err != nil {
+        return nil, err
+    }
+
+    opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+    cursor, err := config.DB.Collection("comments").Find(ctx, bson.M{"noteId": noteID}, opts)
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+
+    var comments []models.Comment
+    if err = cursor.All(ctx, &comments); err != nil {
+        return nil, err
+    }
+
+    users, err := s.commentUsers(comments)
+    if err != nil {
+        return nil, err
+    }
+
+    replies := map[primitive.ObjectID][]models.CommentResponse{}
+    for _, comment := range comments {
+        if comment.ParentID != nil {
+            replies[*comment.ParentID] = append(replies[*comment.ParentID], commentToResponse(comment, users))
+        }
+    }
+
+    threads := []models.CommentResponse{}
+    for _, comment := range comments {
+        if comment.ParentID != nil {
+            continue
+        }
+        response := commentToResponse(comment, users)
+        response.Replies = replies[comment.ID]
+        threads = append(threads, response)
+    }
+    return threads, nil
+}
+
+// AddComment adds a top-level comment or a reply. The owner, editors and commenters may comment.
+func (s *CommentService) AddComment(noteID, userID primitive.ObjectID, req models.CommentRequest) (models.CommentResponse, error) {
+    ctx := context.Background()
+
+    note, err := s.findCommentableNote(noteID, userID)
+    if err != nil {
+        return models.CommentResponse{}, err
+    }
+
+    body := strings.TrimSpace(req.Body)
+    if body == "" {
+        return models.CommentResponse{}, errors.New("comment body is required")
+    }
+
+    comment := models.Comment{
+        ID:        primitive.NewObjectID(),
+        NoteID:    noteID,
+        AuthorID:  userID,
+        Body:      body,
+        CreatedAt: time.Now(),
+        UpdatedAt: time.Now(),
+    }
+
+    if req.ParentID != "" {
+        parentID, err := primitive.ObjectIDFromHex(req.ParentID)
+        if err != nil {
+            return models.CommentResponse{}, errors.New("invalid parent comment ID")
+        }
+        var parent models.Comment
+        err = config.DB.Collection("comments").FindOne(ctx, bson.M{"_id": parentID, "noteId": noteID}).Decode(&parent)
+        if err != nil {
+            return models.CommentResponse{}, errors.New("parent comment not found")
+        }
+        if parent.ParentID != nil {
+            return models.CommentResponse{}, errors.New("replies can't be nested, reply to the thread instead")
+        }
+        if req.Anchor != nil {
+            return models.CommentResponse{}, errors.New("only top-level comments can be anchored")
+        }
+        comment.ParentID = &parentID
+    }
+
+    if req.Anchor != nil {
+        anchor, err := anchorIn(note.Content, *req.Anchor)
+        if err != nil {
+            return models.CommentResponse{}, err
+        }
+        comment.Anchor = &anchor
+    }
+
+    comment.Mentions = s.resolveMentions(note, body)
+
+    if _, err = config.DB.Collection("comments").InsertOne(ctx, comment); err != nil {
+        return models.CommentResponse{}, err
+    }
+
+    commentID := comment.ID
+    s.activityService.Record(models.NoteActivity{
+        NoteID:    noteID,
+        ActorID:   userID,
+        Type:      models.ActivityCommented,
+        CommentID: &commentID,
+    })
+
+    s.notifyParticipants(note, comment, nil)
+
+    users, err := s.commentUsers([]models.Comment{comment})
+    if err != nil {
+        return models.CommentResponse{}, err
+    }
+    return commentToResponse(comment, users), nil
+}
+
+// UpdateComment changes the body of a comment, only its author may edit it
+func (s *CommentService) UpdateComment(noteID, commentID, userID primitive.ObjectID, req models.UpdateCommentRequest) (models.CommentResponse, error) {
+    ctx := context.Background()
+
+    note, err := s.findCommentableNote(noteID, userID)
+    if err != nil {
+        return models.CommentResponse{}, err
+    }
+
+    body := strings.TrimSpace(req.Body)
+    if body == "" {
+        return models.CommentResponse{}, errors.New("comment body is required")
+    }
+
+    mentions := s.resolveMentions(note, body)
+
+    var before models.Comment
+    err = config.DB.Collection("comments").FindOneAndUpdate(ctx, bson.M{
+        "_id": commentID,
+        "noteId": noteID,
+        "authorId": userID,
+        "deleted": false,
+    }, bson.M{
+        "$set": bson.M{
+            "body":      body,
+            "mentions":  mentions,
+            "edited":    true,
+            "updatedAt": time.Now(),
+        },
+    }).Decode(&before)
+    if err != nil {
+        return models.CommentResponse{}, errors.New("comment not found or not yours")
+    }
+
+    comment := before
+    comment.Body = body
+    comment.Mentions = mentions
+    comment.Edited = true
+    comment.UpdatedAt = time.Now()
+
+    // only users mentioned by this edit are notified, not everyone mentioned before
+    s.notifyParticipants(note, comment, before.Mentions)
+
+    users, err := s.commentUsers([]models.Comment{comment})
+    if err != nil {
+        return models.CommentResponse{}, err
+    }
+    return commentToResponse(comment, users), nil
+}
+
+// DeleteComment removes a comment, only its author may delete it. A thread that
+// has replies keeps a placeholder so the replies stay readable.
+func (s *CommentService) DeleteComment(noteID, commentID, userID primitive.ObjectID) error {
+    ctx := context.Background()
+
+    if _, err := s.findCommentableNote(noteID, userID); err != nil {
+        return err
+    }
+
+    var comment models.Comment
+    err := config.DB.Collection("comments").FindOne(ctx, bson.M{
+        "_id": commentID,
+        "noteId": noteID,
+        "authorId": userID,
+        "deleted": false,
+    }).Decode(&comment)
+    if err != nil {
+        return errors.New("comment not found or not yours")
+    }
+
+    replies, err := config.DB.Collection("comments").CountDocuments(ctx, bson.M{"parentId": commentID})
+    if err != nil {
+        return err
+    }
+
+    if replies > 0 {
+        _, err = config.DB.Collection("comments").UpdateOne(ctx, bson.M{"_id": commentID}, bson.M{
+            "$set": bson.M{"body": "", "mentions": []primitive.ObjectID{}, "deleted": true, "updatedAt": time.Now()},
+            "$unset": bson.M{"anchor": ""},
+        })
+        return err
+    }
+
+    _, err = config.DB.Collection("comments").DeleteOne(ctx, bson.M{"_id": commentID})
+    if err != nil {
+        return err
+    }
+
+    // removing the last reply of a deleted thread leaves nothing worth keeping
+    if comment.ParentID != nil {
+        remaining, err := config.DB.Collection("comments").CountDocuments(ctx, bson.M{"parentId": *comment.ParentID})
+        if err == nil && remaining == 0 {
+            _, err = config.DB.Collection("comments").DeleteOne(ctx, bson.M{"_id": *comment.ParentID, "deleted": true})
+        }
+        if err != nil {
+            log.Println("Failed to clean up deleted thread", comment.ParentID.Hex(), err)
+        }
+    }
+    return nil
+}
+
+// DeleteUserComments removes every comment the user wrote. Like DeleteComment, a
+// top-level comment that others replied to is blanked instead so the thread stays.
+func (s *CommentService) DeleteUserComments(userID primitive.ObjectID) error {
+    ctx := context.Background()
+
+    // Their replies go first, remembering the threads they were in
+    parentIDs, err := config.DB.Collection("comments").Distinct(ctx, "parentId", bson.M{
+        "authorId": userID,
+        "parentId": bson.M{"$exists": true},
+    })
+    if err != nil {
+        return err
+    }
+    if _, err := config.DB.Collection("comments").DeleteMany(ctx, bson.M{
+        "authorId": userID,
+        "parentId": bson.M{"$exists": true},
+    }); err != nil {
+        return err
+    }
+
+    cursor, err := config.DB.Collection("comments").Find(ctx, bson.M{"authorId": userID},
+        options.Find().SetProjection(bson.M{"_id": 1}))
+    if err != nil {
+        return err
+    }
+    var own []models.Comment
+    if err := cursor.All(ctx, &own); err != nil {
+        return err
+    }
+    var ownIDs []primitive.ObjectID
+    for _, comment := range own {
+        ownIDs = append(ownIDs, comment.ID)
+    }
+    if len(ownIDs) > 0 {
+        withReplies, err := config.DB.Collection("comments").Distinct(ctx, "parentId", bson.M{"parentId": bson.M{"$in": ownIDs}})
+        if err != nil {
+            return err
+        }
+        if len(withReplies) > 0 {
+            _, err = config.DB.Collection("comments").UpdateMany(ctx, bson.M{"_id": bson.M{"$in": withReplies}}, bson.M{
+                "$set": bson.M{"body": "", "mentions": []primitive.ObjectID{}, "deleted": true, "updatedAt": time.Now()},
+                "$unset": bson.M{"anchor": ""},
+            })
+            if err != nil {
+                return err
+            }
+        }
+        _, err = config.DB.Collection("comments").DeleteMany(ctx, bson.M{"authorId": userID, "deleted": false})
+        if err != nil {
+            return err
+        }
+    }
+
+    // Deleted threads that were only kept for the user's replies
+    for _, parentID := range parentIDs {
+        remaining, err := config.DB.Collection("comments").CountDocuments(ctx, bson.M{"parentId": parentID})
+        if err != nil {
+            return err
+        }
+        if remaining == 0 {
+            config.DB.Collection("comments").DeleteOne(ctx, bson.M{"_id": parentID, "deleted": true})
+        }
+    }
+    return nil
+}
+
+// SetResolved resolves or reopens a thread. Anyone who can comment on the note may do either.
+func (s *CommentService) SetResolved(noteID, commentID, userID primitive.ObjectID, resolved bool) (models.CommentResponse, error) {
+    ctx := context.Background()
+
+    if _, err := s.findCommentableNote(noteID, userID); err != nil {
+        return models.CommentResponse{}, err
+    }
+
+    update := bson.M{
+        "$set": bson.M{"resolved": true, "resolvedBy": userID, "resolvedAt": time.Now()},
+    }
+    if !resolved {
+        update = bson.M{
+            "$set": bson.M{"resolved": false},
+            "$unset": bson.M{"resolvedBy": "", "resolvedAt": ""},
+        }
+    }
+
+    var comment models.Comment
+    err := config.DB.Collection("comments").FindOneAndUpdate(ctx, bson.M{
+        "_id": commentID,
+        "noteId": noteID,
+        "parentId": nil,
+    }, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&comment)
+    if err != nil {
+        return models.CommentResponse{}, errors.New("thread not found")
+    }
+
+    users, err := s.commentUsers([]models.Comment{comment})
+    if err != nil {
+        return models.CommentResponse{}, err
+    }
+    return commentToResponse(comment, users), nil
+}
+
+// findNote returns the note if the user may read its comments
+func (s *CommentService) findNote(noteID, userID primitive.ObjectID) (models.Note, error) {
+    ctx := context.Background()
+
+    var note models.Note
+    err := config.DB.Collection("notes").FindOne(ctx, bson.M{
+        "_id": noteID,
+        "$or": []bson.M{
+            {"userId": userID},
+            {"collaborators": userID},
+        },
+    }).Decode(&note)
+    if err != nil {
+        return models.Note{}, errors.New("note not found or access denied")
+    }
+    return note, nil
+}
+
+// findCommentableNote returns the note if the user may comment on it, viewers can only read comments
+func (s *CommentService) findCommentableNote(noteID, userID primitive.ObjectID) (models.Note, error) {
+    note, err := s.findNote(noteID, userID)
+    if err != nil {
+        return models.Note{}, err
+    }
+    if CollaboratorRole(note, userID) == models.RoleViewer {
+        return models.Note{}, errors.New("viewers can't comment on this note")
+    }
+    return note, nil
+}
+
+// resolveMentions returns the mentioned users that can access the note,
+// mentions of anyone else are left as plain text
+func (s *CommentService) resolveMentions(note models.Note, body string) []primitive.ObjectID {
+    usernames := ParseMentions(body)
+    if len(usernames) == 0 {
+        return nil
+    }
+
+    users, err := s.authService.ResolveUsernames(usernames)
+    if err != nil {
+        log.Println("Failed to resolve mentions on note", note.ID.Hex(), err)
+        return nil
+    }
+
+    var mentions []primitive.ObjectID
+    for _, user := range users {
+        if CollaboratorRole(note, user.ID) != "" {
+            mentions = append(mentions, user.ID)
+        }
+    }
+    return mentions
+}
+
+// notifyParticipants tells mentioned users they were mentioned and everyone else
+// involved (the note owner and, for replies, the thread's participants) about the comment.
+// Users in alreadyMentioned don't get another mention notification.
+func (s *CommentService) notifyParticipants(note models.Note, comment models.Comment, alreadyMentioned []primitive.ObjectID) {
+    ctx := context.Background()
+
+    var mentioned []primitive.ObjectID
+    for _, userID := range comment.Mentions {
+        if !containsID(alreadyMentioned, userID) {
+            mentioned = append(mentioned, userID)
+        }
+    }
+    s.notificationService.NotifyNoteEvent(mentioned, models.NotificationMentioned, note, comment.AuthorID)
+
+    // edits only notify newly mentioned users
+    if comment.Edited {
+        return
+    }
+
+    recipients := []primitive.ObjectID{note.UserID}
+    if comment.ParentID != nil {
+        cursor, err := config.DB.Collection("comments").Find(ctx, bson.M{
+            "$or": []bson.M{
+                {"_id": *comment.ParentID},
+                {"parentId": *comment.ParentID},
+            },
+        })
+        if err == nil {
+            var thread []models.Comment
+            if err = cursor.All(ctx, &thread); err == nil {
+                for _, participant := range thread {
+                    if !containsID(recipients, participant.AuthorID) && CollaboratorRole(note, participant.AuthorID) != "" {
+                        recipients = append(recipients, participant.AuthorID)
+                    }
+                }
+            }
+        }
+        if err != nil {
+            log.Println("Failed to load thread participants for comment", comment.ID.Hex(), err)
+        }
+    }
+
+    var others []primitive.ObjectID
+    for _, recipient := range recipients {
+        if !containsID(comment.Mentions, recipient) {
+            others = append(others, recipient)
+        }
+    }
+    s.notificationService.NotifyNoteEvent(others, models.NotificationComment, note, comment.AuthorID)
+}
+
+func (s *CommentService) commentUsers(comments []models.Comment) (map[primitive.ObjectID]models.User, error) {
+    ctx := context.Background()
+
+    var ids []primitive.ObjectID
+    for _, comment := range comments {
+        ids = append(ids, comment.AuthorID)
+        ids = append(ids, comment.Mentions...)
+    }
+    users := map[primitive.ObjectID]models.User{}
+    if len(ids) == 0 {
+        return users, nil
+    }
+
+    cursor, err := config.DB.Collection("users").Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+    if err != nil {
+        return nil, err
+    }
+    var found []models.User
+    if err = cursor.All(ctx, &found); err != nil {
+        return nil, err
+    }
+    for _, user := range found {
+        users[user.ID] = user
+    }
+    return users, nil
+}
+
+func commentToResponse(comment models.Comment, users map[primitive.ObjectID]models.User) models.CommentResponse {
+    author := users[comment.AuthorID]
+    response := models.CommentResponse{
+        ID:         comment.ID.Hex(),
+        NoteID:     comment.NoteID.Hex(),
+        Author:     commenterProfile(comment.AuthorID, author),
+        Body:       comment.Body,
+        Anchor:     comment.Anchor,
+        Mentions:   []models.UserProfileDto{},
+        Resolved:   comment.Resolved,
+        ResolvedAt: comment.ResolvedAt,
+        Edited:     comment.Edited,
+        Deleted:    comment.Deleted,
+        CreatedAt:  comment.CreatedAt,
+        UpdatedAt:  comment.UpdatedAt,
+    }
+    if comment.ParentID != nil {
+        response.ParentID = comment.ParentID.Hex()
+    }
+    for _, userID := range comment.Mentions {
+        user := users[userID]
+        response.Mentions = append(response.Mentions, commenterProfile(userID, user))
+    }
+    return response
+}
+
+// anchorIn checks the anchor's range against the content and fills in the quoted text.
+// Offsets count characters, not bytes, so a quote can't end inside a character.
+func anchorIn(content string, anchor models.CommentAnchor) (models.CommentAnchor, error) {
+    runes := []rune(content)
+    if anchor.Start < 0 || anchor.End < anchor.Start || anchor.End > len(runes) {
+        return models.CommentAnchor{}, errors.New("anchor is outside the note content")
+    }
+    anchor.Quote = string(runes[anchor.Start:anchor.End])
+    return anchor, nil
+}
+
+// commenterProfile is how authors and mentioned users appear on comments. Everyone
+// who can read the note sees its comments, so emails are left out.
+func commenterProfile(userID primitive.ObjectID, user models.User) models.UserProfileDto {
+    profile := publicProfile(user)
+    profile.ID = userID.Hex()
+    profile.Email = ""
+    return profile
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+    "testing"
+    "notes-app/models"
+)
+
+func TestAnchorIn(t *testing.T) {
+    content := "Café ☕ notes: naïve plan"
+    tests := []struct {
+        start, end int
+        quote      string
+        valid      bool
+    }{
+        {0, 4, "Café", true},
+        {5, 6, "☕", true},
+        {14, 19, "naïve", true},
+        {0, 24, content, true},
+        {3, 3, "", true},
+        {0, 25, "", false},
+        {-1, 2, "", false},
+        {6, 5, "", false},
+    }
+    for _, test := range tests {
+        anchor, err := anchorIn(content, models.CommentAnchor{Start: test.start, End: test.end, Quote: "ignored"})
+        if !test.valid {
+            if err == nil {
+                t.Errorf("[%d:%d] accepted, want an error", test.start, test.end)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("[%d:%d]: %v", test.start, test.end, err)
+            continue
+        }
+        if anchor.Quote != test.quote {
+            t.Errorf("[%d:%d] quoted %q, want %q", test.start, test.end, anchor.Quote, test.quote)
+        }
+    }
+}
+
+func TestCommenterProfileHasNoEmail(t *testing.T) {
+    user := models.User{Username: "alice", Name: "Alice", Email: "alice@example.com"}
+    profile := commenterProfile(user.ID, user)
+    if profile.Email != "" {
+        t.Errorf("comment profile includes the email %q", profile.Email)
+    }
+    if profile.Username != "alice" || profile.Name != "Alice" {
+        t.Errorf("profile = %+v", profile)
+    }
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+    "bytes"
+    "context"
+    htmltemplate "html/template"
+    "log"
+    texttemplate "text/template"
+    "time"
+    "notes-app/config"
+    "notes-app/models"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var digestTextTemplate = texttemplate.Must(texttemplate.New("digest").Parse(
+`Hi {{.Name}},
+
+Here is what changed in your notes since {{.Since.Format "Jan 2, 15:04"}}:
+{{range .Items}}
+- {{.Title}} ({{.Edits}} edit{{if ne .Edits 1}}s{{end}}, last {{.UpdatedAt.Format "Jan 2, 15:04"}})
+  {{.URL}}
+{{end}}
+You can change how often you get this email in your notification preferences.
+`))
+
+var digestHTMLTemplate = htmltemplate.Must(htmltemplate.New("digest").Parse(
+`<p>Hi {{.Name}},</p>
+<p>Here is what changed in your notes since {{.Since.Format "Jan 2, 15:04"}}:</p>
+<ul>
+{{range .Items}}<li><a href="{{.URL}}">{{.Title}}</a> &mdash; {{.Edits}} edit{{if ne .Edits 1}}s{{end}}, last {{.UpdatedAt.Format "Jan 2, 15:04"}}</li>
+{{end}}</ul>
+<p>You can change how often you get this email in your notification preferences.</p>
+`))
+
+type DigestService struct {
+    mailer Mailer
+}
+
+func NewDigestService(mailer Mailer) *DigestService {
+    return &DigestService{mailer: mailer}
+}
+
+// Start sends due digests periodically until ctx is cancelled
+func (s *DigestService) Start(ctx context.Context) {
+    ticker := time.NewTicker(config.GetEnvDuration("DIGEST_CHECK_INTERVAL", time.Hour))
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if err := s.SendDueDigests(time.Now()); err != nil {
+                log.Println("Failed to send digests:", err)
+            }
+        }
+    }
+}
+
+// SendDueDigests sends a digest to every user whose digest period has elapsed
+func (s *DigestService) SendDueDigests(now time.Time) error {
+    ctx := context.Background()
+
+    cursor, err := config.DB.Collection("notification_preferences").Find(ctx, bson.M{
+        "digestFrequency": bson.M{"$in": []string{models.DigestDaily, models.DigestWeekly}},
+    })
+    if err != nil {
+        return err
+    }
+    defer cursor.Close(ctx)
+
+    var prefs []models.NotificationPreferences
+    if err = cursor.All(ctx, &prefs); err != nil {
+        return err
+    }
+
+    for _, pref := range prefs {
+        period := 24 * time.Hour
+        if pref.DigestFrequency == models.DigestWeekly {
+            period = 7 * 24 * time.Hour
+        }
+        since := now.Add(-period)
+        if pref.LastDigestAt != nil {
+            if pref.LastDigestAt.Add(period).After(now) {
+                continue
+            }
+            since = *pref.LastDigestAt
+        }
+        if !s.claim(pref, now) {
+            continue
+        }
+        if err := s.SendDigest(pref.UserID, since, now); err != nil {
+            log.Println("Failed to send digest to user", pref.UserID.Hex(), err)
+            s.release(pref, now)
+        }
+    }
+    return nil
+}
+
+// claim moves lastDigestAt forward, failing if another instance already did
+func (s *DigestService) claim(pref models.NotificationPreferences, now time.Time) bool {
+    ctx := context.Background()
+
+    filter := bson.M{"userId": pref.UserID, "lastDigestAt": nil}
+    if pref.LastDigestAt != nil {
+        filter["lastDigestAt"] = *pref.LastDigestAt
+    }
+    result, err := config.DB.Collection("notification_preferences").UpdateOne(ctx, filter, bson.M{
+        "$set": bson.M{"lastDigestAt": now},
+    })
+    return err == nil && result.ModifiedCount == 1
+}
+
+// release undoes a claim after the digest couldn't be sent, so the next run sends it
+func (s *DigestService) release(pref models.NotificationPreferences, now time.Time) {
+    ctx := context.Background()
+
+    update := bson.M{"$unset": bson.M{"lastDigestAt": ""}}
+    if pref.LastDigestAt != nil {
+        update = bson.M{"$set": bson.M{"lastDigestAt": *pref.LastDigestAt}}
+    }
+    _, err := config.DB.Collection("notification_preferences").UpdateOne(ctx, bson.M{
+        "userId": pref.UserID,
+        "lastDigestAt": now,
+    }, update)
+    if err != nil {
+        log.Println("Failed to release digest claim for user", pref.UserID.Hex(), err)
+    }
+}
+
+// SendDigest emails the user a summary of changes to notes they own or collaborate on
+// between since and until. Nothing is sent if nothing changed.
+func (s *DigestService) SendDigest(userID primitive.ObjectID, since, until time.Time) error {
+    ctx := context.Background()
+
+    var user models.User
+    err := config.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+    if err != nil {
+        return err
+    }
+
+    items, err := s.collectItems(userID, since, until)
+    if err != nil {
+        return err
+    }
+    return s.deliver(user, since, items)
+}
+
+// deliver renders and sends the digest email. Nothing is sent without items.
+func (s *DigestService) deliver(user models.User, since time.Time, items []models.DigestItem) error {
+    if len(items) == 0 {
+        return nil
+    }
+
+    data := struct {
+        Name  string
+        Since time.Time
+        Items []models.DigestItem
+    }{user.Name, since, items}
+
+    var text, html bytes.Buffer
+    if err := digestTextTemplate.Execute(&text, data); err != nil {
+        return err
+    }
+    if err := digestHTMLTemplate.Execute(&html, data); err != nil {
+        return err
+    }
+
+    return s.mailer.Send(Email{
+        To:      user.Email,
+        Subject: "Your notes digest",
+        Text:    text.String(),
+        HTML:    html.String(),
+    })
+}
+
+// collectItems lists the notes the user owns or collaborates on that someone else
+// changed between since and until. The user's own edits aren't news to them.
+func (s *DigestService) collectItems(userID primitive.ObjectID, since, until time.Time) ([]models.DigestItem, error) {
+    ctx := context.Background()
+
+    opts := options.Find().SetSort(bson.D{{Key: "updatedAt", Value: -1}})
+    cursor, err := config.DB.Collection("notes").Find(ctx, bson.M{
+        "trashed": false,
+        "updatedAt": bson.M{"$gte": since, "$lt": until},
+        "$or": []bson.M{
+            {"userId": userID},
+            {"collaborators": userID},
+        },
+    }, opts)
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+
+    var notes []models.Note
+    if err = cursor.All(ctx, &notes); err != nil {
+        return nil, err
+    }
+
+    baseURL := config.GetEnv("APP_BASE_URL", "http://localhost:5173")
+    var items []models.DigestItem
+    for _, note := range notes {
+        var activities []models.NoteActivity
+        cursor, err := config.DB.Collection("note_activity").Find(ctx, bson.M{
+            "noteId": note.ID,
+            "actorId": bson.M{"$ne": userID},
+            "type": bson.M{"$in": []string{models.ActivityCreated, models.ActivityEdited, models.ActivityVersionRestored}},
+            "createdAt": bson.M{"$gte": since, "$lt": until},
+        }, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+        if err != nil {
+            return nil, err
+        }
+        if err := cursor.All(ctx, &activities); err != nil {
+            return nil, err
+        }
+        if len(activities) == 0 {
+            continue
+        }
+        items = append(items, models.DigestItem{
+            NoteID:    note.ID.Hex(),
+            Title:     note.Title,
+            Edits:     len(activities),
+            UpdatedAt: activities[0].CreatedAt,
+            URL:       baseURL + "/view/" + note.ID.Hex(),
+        })
+    }
+    return items, nil
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+    "errors"
+    "strings"
+    "testing"
+    "time"
+    "notes-app/models"
+)
+
+// failingMailer fails every send, like an unreachable SMTP server
+type failingMailer struct{}
+
+func (failingMailer) Send(email Email) error {
+    return errors.New("connection refused")
+}
+
+func TestDigestDeliver(t *testing.T) {
+    since := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+    user := models.User{Name: "Alice", Email: "alice@example.com"}
+    items := []models.DigestItem{
+        {NoteID: "1", Title: "Plans <draft>", Edits: 1, UpdatedAt: since.Add(time.Hour), URL: "http://app/view/1"},
+        {NoteID: "2", Title: "Budget", Edits: 3, UpdatedAt: since.Add(2 * time.Hour), URL: "http://app/view/2"},
+    }
+
+    mailer := NewCaptureMailer()
+    service := NewDigestService(mailer)
+    if err := service.deliver(user, since, items); err != nil {
+        t.Fatalf("deliver: %v", err)
+    }
+
+    sent := mailer.Sent()
+    if len(sent) != 1 {
+        t.Fatalf("sent %d emails, want 1", len(sent))
+    }
+    email := sent[0]
+    if email.To != user.Email || email.Subject != "Your notes digest" {
+        t.Errorf("email to %q with subject %q", email.To, email.Subject)
+    }
+    for _, want := range []string{"Hi Alice", "Plans <draft> (1 edit,", "Budget (3 edits,", "http://app/view/2", "Mar 1, 09:00"} {
+        if !strings.Contains(email.Text, want) {
+            t.Errorf("text body is missing %q:\n%s", want, email.Text)
+        }
+    }
+    if !strings.Contains(email.HTML, "Plans &lt;draft&gt;") {
+        t.Errorf("HTML body doesn't escape titles:\n%s", email.HTML)
+    }
+}
+
+func TestDigestDeliverWithoutItems(t *testing.T) {
+    mailer := NewCaptureMailer()
+    service := NewDigestService(mailer)
+
+    if err := service.deliver(models.User{Email: "alice@example.com"}, time.Now(), nil); err != nil {
+        t.Fatalf("deliver: %v", err)
+    }
+    if sent := mailer.Sent(); len(sent) != 0 {
+        t.Errorf("sent %d emails for an empty digest", len(sent))
+    }
+}
+
+func TestDigestDeliverReportsSendFailure(t *testing.T) {
+    service := NewDigestService(failingMailer{})
+    items := []models.DigestItem{{Title: "Budget", Edits: 1, UpdatedAt: time.Now()}}
+
+    // SendDueDigests releases the period's claim on this error so the digest isn't lost
+    if err := service.deliver(models.User{Email: "alice@example.com"}, time.Now(), items); err == nil {
+        t.Error("deliver succeeded although the mailer failed")
+    }
+}
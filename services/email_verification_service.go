@@ -0,0 +1,243 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "log"
+    "strings"
+    "time"
+    "notes-app/config"
+    "notes-app/models"
+    "github.com/go-redis/redis/v8"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Features UNVERIFIED_RESTRICTIONS can withhold from accounts whose email isn't verified
+const (
+    RestrictShare   = "share"    // share notes or be added to someone else's note
+    RestrictSearch  = "search"   // show up in user search
+    RestrictAPIKeys = "api_keys" // create API keys
+)
+
+var (
+    // ErrEmailNotVerified is returned when an unverified account tries a restricted feature
+    ErrEmailNotVerified = errors.New("email address must be verified first")
+    // ErrVerificationRateLimited is returned when verification emails are requested too often
+    ErrVerificationRateLimited = errors.New("too many verification emails, please try again later")
+)
+
+const (
+    verificationResendCooldown = time.Minute
+    verificationDailyLimit     = 5
+)
+
+// UnverifiedRestricts reports whether accounts with an unverified email are kept from a feature.
+// UNVERIFIED_RESTRICTIONS is a comma separated list, it defaults to "share,search".
+func UnverifiedRestricts(feature string) bool {
+    for _, restricted := range strings.Split(config.GetEnv("UNVERIFIED_RESTRICTIONS", RestrictShare+","+RestrictSearch), ",") {
+        if strings.TrimSpace(restricted) == feature {
+            return true
+        }
+    }
+    return false
+}
+
+// requireVerified returns ErrEmailNotVerified if the feature is restricted
+// and any of the users hasn't verified their email
+func requireVerified(feature string, userIDs ...primitive.ObjectID) error {
+    if !UnverifiedRestricts(feature) || len(userIDs) == 0 {
+        return nil
+    }
+    ctx := context.Background()
+    count, err := config.DB.Collection("users").CountDocuments(ctx, bson.M{
+        "_id": bson.M{"$in": userIDs},
+        "emailVerified": false,
+    })
+    if err != nil {
+        return err
+    }
+    if count > 0 {
+        return ErrEmailNotVerified
+    }
+    return nil
+}
+
+// EmailVerificationService confirms that users own their email address. Tokens are
+// stored hashed as email_verify:<hash> -> user ID, and a new token replaces the previous one.
+type EmailVerificationService struct {
+    auditService *AuditService
+    mailer       Mailer
+    tokenTTL     time.Duration
+}
+
+func NewEmailVerificationService(auditService *AuditService, mailer Mailer) *EmailVerificationService {
+    return &EmailVerificationService{
+        auditService: auditService,
+        mailer:       mailer,
+        tokenTTL:     config.GetEnvDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+    }
+}
+
+// BackfillExisting marks accounts created before verification existed as verified,
+// so they don't lose features they already had
+func (s *EmailVerificationService) BackfillExisting() error {
+    ctx := context.Background()
+    _, err := config.DB.Collection("users").UpdateMany(ctx,
+        bson.M{"emailVerified": bson.M{"$exists": false}},
+        bson.M{"$set": bson.M{"emailVerified": true}},
+    )
+    return err
+}
+
+// SendVerification emails the user a new verification link in the background
+func (s *EmailVerificationService) SendVerification(user models.User) error {
+    return s.send(user, user.Email, user.ID.Hex())
+}
+
+// SendEmailChange emails a link to a new address. The user's email only changes when it's opened.
+// The current address is told about the change in the background, in case it wasn't the owner.
+func (s *EmailVerificationService) SendEmailChange(user models.User, newEmail string) error {
+    if err := s.send(user, newEmail, user.ID.Hex()+" "+newEmail); err != nil {
+        return err
+    }
+
+    go func() {
+        message, err := renderAccountEmail(user.Email, "Your email address is being changed", emailChangeNoticeTextTemplate, emailChangeNoticeHTMLTemplate, accountEmailData{
+            Name:  user.Name,
+            URL:   config.GetEnv("APP_BASE_URL", "http://localhost:5173") + "/forgot-password",
+            Email: newEmail,
+        })
+        if err == nil {
+            err = s.mailer.Send(message)
+        }
+        if err != nil {
+            log.Println("Failed to send email change notice to user", user.ID.Hex(), err)
+        }
+    }()
+    return nil
+}
+
+// send stores a token for value, the user ID optionally followed by a new email,
+// and emails the link to the address in the background
+func (s *EmailVerificationService) send(user models.User, to, value string) error {
+    ctx := context.Background()
+
+    token := generateSessionID()
+    hash := hashToken(token)
+    previous, _ := config.RedisClient.Get(ctx, "email_verify_user:"+user.ID.Hex()).Result()
+    _, err := config.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+        if previous != "" {
+            pipe.Del(ctx, "email_verify:"+previous)
+        }
+        pipe.Set(ctx, "email_verify:"+hash, value, s.tokenTTL)
+        pipe.Set(ctx, "email_verify_user:"+user.ID.Hex(), hash, s.tokenTTL)
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+
+    go func() {
+        message, err := renderAccountEmail(to, "Verify your email", verificationTextTemplate, verificationHTMLTemplate, accountEmailData{
+            Name:      user.Name,
+            URL:       config.GetEnv("APP_BASE_URL", "http://localhost:5173") + "/verify-email?token=" + token,
+            ExpiresIn: humanDuration(s.tokenTTL),
+        })
+        if err == nil {
+            err = s.mailer.Send(message)
+        }
+        if err != nil {
+            log.Println("Failed to send verification email to user", user.ID.Hex(), err)
+        }
+    }()
+    return nil
+}
+
+// Resend sends another verification email, at most once a minute and five times a day
+func (s *EmailVerificationService) Resend(userID primitive.ObjectID) error {
+    ctx := context.Background()
+
+    var user models.User
+    if err := config.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+        return errors.New("user not found")
+    }
+    if user.EmailVerified && user.PendingEmail == "" {
+        return errors.New("email is already verified")
+    }
+
+    allowed, err := config.RedisClient.SetNX(ctx, "email_verify_cooldown:"+userID.Hex(), 1, verificationResendCooldown).Result()
+    if err != nil {
+        return err
+    }
+    if !allowed {
+        return ErrVerificationRateLimited
+    }
+    dailyKey := "email_verify_daily:" + userID.Hex()
+    sent, err := config.RedisClient.Incr(ctx, dailyKey).Result()
+    if err != nil {
+        return err
+    }
+    if sent == 1 {
+        config.RedisClient.Expire(ctx, dailyKey, 24*time.Hour)
+    }
+    if sent > verificationDailyLimit {
+        return ErrVerificationRateLimited
+    }
+
+    if user.PendingEmail != "" {
+        return s.SendEmailChange(user, user.PendingEmail)
+    }
+    return s.SendVerification(user)
+}
+
+// Verify marks the email of the token's user as verified, or switches to the new
+// email for links sent by SendEmailChange. Tokens work once.
+func (s *EmailVerificationService) Verify(token string, client models.ClientInfo) error {
+    ctx := context.Background()
+
+    value, err := config.RedisClient.GetDel(ctx, "email_verify:"+hashToken(token)).Result()
+    if err != nil {
+        return errors.New("verification link is invalid or has expired")
+    }
+    userID, newEmail, _ := strings.Cut(value, " ")
+    objID, err := primitive.ObjectIDFromHex(userID)
+    if err != nil {
+        return errors.New("verification link is invalid or has expired")
+    }
+    config.RedisClient.Del(ctx, "email_verify_user:"+userID)
+
+    filter := bson.M{"_id": objID}
+    update := bson.M{"$set": bson.M{"emailVerified": true, "emailVerifiedAt": time.Now()}}
+    if newEmail != "" {
+        // Someone may have registered the address since the change was requested
+        taken, err := config.DB.Collection("users").CountDocuments(ctx, bson.M{"email": newEmail, "_id": bson.M{"$ne": objID}},
+            options.Count().SetCollation(caseInsensitive))
+        if err != nil {
+            return err
+        }
+        if taken > 0 {
+            return errors.New("email is already used by another account")
+        }
+        filter["pendingEmail"] = newEmail
+        update["$set"].(bson.M)["email"] = newEmail
+        update["$unset"] = bson.M{"pendingEmail": ""}
+    }
+
+    var previous models.User
+    err = config.DB.Collection("users").FindOneAndUpdate(ctx, filter, update).Decode(&previous)
+    if err != nil {
+        return errors.New("verification link is invalid or has expired")
+    }
+
+    if newEmail != "" {
+        s.auditService.Record(&objID, models.AuditEmailChanged, "user", userID, client, map[string]string{
+            "from": previous.Email,
+            "to":   newEmail,
+        })
+        return nil
+    }
+    s.auditService.Record(&objID, models.AuditEmailVerified, "user", userID, client, nil)
+    return nil
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "notes-app/config"
+    "notes-app/models"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const MaxGraphDepth = 3
+
+type GraphService struct{}
+
+func NewGraphService() *GraphService {
+    return &GraphService{}
+}
+
+// GetGraph builds the graph of the notes visible to the user. When tag is set only notes
+// carrying it are included. When centerID is set the graph is limited to notes within
+// depth hops of it, where a hop is a link in either direction or a shared tag.
+func (s *GraphService) GetGraph(userID primitive.ObjectID, centerID *primitive.ObjectID, depth int, tag string) (models.GraphResponse, error) {
+    ctx := context.Background()
+
+    filter := bson.M{
+        "trashed": false,
+        "$or": []bson.M{
+            {"userId": userID},
+            {"collaborators": userID},
+        },
+    }
+    if tag != "" {
+        filter["tags"] = tag
+    }
+
+    cursor, err := config.DB.Collection("notes").Find(ctx, filter)
+    if err != nil {
+        return models.GraphResponse{}, err
+    }
+    defer cursor.Close(ctx)
+
+    var notes []models.Note
+    if err = cursor.All(ctx, &notes); err != nil {
+        return models.GraphResponse{}, err
+    }
+
+    visible := map[primitive.ObjectID]models.Note{}
+    var noteIDs []primitive.ObjectID
+    for _, note := range notes {
+        visible[note.ID] = note
+        noteIDs = append(noteIDs, note.ID)
+    }
+
+    links, err := s.findLinks(noteIDs)
+    if err != nil {
+        return models.GraphResponse{}, err
+    }
+
+    // Only keep links whose both ends are visible
+    var linkEdges []models.NoteLink
+    for _, link := range links {
+        if link.TargetID == nil || *link.TargetID == link.SourceID {
+            continue
+        }
+        if _, ok := visible[*link.TargetID]; ok {
+            linkEdges = append(linkEdges, link)
+        }
+    }
+
+    included := visible
+    if centerID != nil {
+        if _, ok := visible[*centerID]; !ok {
+            return models.GraphResponse{}, errors.New("note not found or access denied")
+        }
+        included = s.neighborhood(*centerID, depth, notes, linkEdges)
+    }
+
+    graph := models.GraphResponse{Nodes: []models.GraphNode{}, Edges: []models.GraphEdge{}}
+    tagNodes := map[string]bool{}
+    for _, note := range notes {
+        if _, ok := included[note.ID]; !ok {
+            continue
+        }
+        graph.Nodes = append(graph.Nodes, models.GraphNode{
+            ID:    note.ID.Hex(),
+            Type:  "note",
+            Label: note.Title,
+        })
+        for _, noteTag := range note.Tags {
+            tagID := "tag:" + noteTag
+            if !tagNodes[tagID] {
+                tagNodes[tagID] = true
+                graph.Nodes = append(graph.Nodes, models.GraphNode{
+                    ID:    tagID,
+                    Type:  "tag",
+                    Label: noteTag,
+                })
+            }
+            graph.Edges = append(graph.Edges, models.GraphEdge{
+                Source: note.ID.Hex(),
+                Target: tagID,
+                Type:   "tag",
+            })
+        }
+    }
+    for _, link := range linkEdges {
+        _, sourceIncluded := included[link.SourceID]
+        _, targetIncluded := included[*link.TargetID]
+        if sourceIncluded && targetIncluded {
+            graph.Edges = append(graph.Edges, models.GraphEdge{
+                Source: link.SourceID.Hex(),
+                Target: link.TargetID.Hex(),
+                Type:   "link",
+            })
+        }
+    }
+
+    return graph, nil
+}
+
+// neighborhood returns the notes reachable from center within depth hops
+func (s *GraphService) neighborhood(center primitive.ObjectID, depth int, notes []models.Note, links []models.NoteLink) map[primitive.ObjectID]models.Note {
+    adjacent := map[primitive.ObjectID][]primitive.ObjectID{}
+    for _, link := range links {
+        adjacent[link.SourceID] = append(adjacent[link.SourceID], *link.TargetID)
+        adjacent[*link.TargetID] = append(adjacent[*link.TargetID], link.SourceID)
+    }
+    byTag := map[string][]primitive.ObjectID{}
+    byID := map[primitive.ObjectID]models.Note{}
+    for _, note := range notes {
+        byID[note.ID] = note
+        for _, tag := range note.Tags {
+            byTag[tag] = append(byTag[tag], note.ID)
+        }
+    }
+
+    included := map[primitive.ObjectID]models.Note{center: byID[center]}
+    frontier := []primitive.ObjectID{center}
+    for hop := 0; hop < depth && len(frontier) > 0; hop++ {
+        var next []primitive.ObjectID
+        visit := func(id primitive.ObjectID) {
+            if _, ok := included[id]; !ok {
+                included[id] = byID[id]
+                next = append(next, id)
+            }
+        }
+        for _, id := range frontier {
+            for _, neighbor := range adjacent[id] {
+                visit(neighbor)
+            }
+            for _, tag := range byID[id].Tags {
+                for _, neighbor := range byTag[tag] {
+                    visit(neighbor)
+                }
+            }
+        }
+        frontier = next
+    }
+    return included
+}
+
+func (s *GraphService) findLinks(noteIDs []primitive.ObjectID) ([]models.NoteLink, error) {
+    ctx := context.Background()
+
+    if len(noteIDs) == 0 {
+        return nil, nil
+    }
+
+    cursor, err := config.DB.Collection("note_links").Find(ctx, bson.M{"sourceId": bson.M{"$in": noteIDs}})
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+
+    var links []models.NoteLink
+    if err = cursor.All(ctx, &links); err != nil {
+        return nil, err
+    }
+    return links, nil
+}
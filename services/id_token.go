@@ -0,0 +1,184 @@
+package services
+
+import (
+    "crypto"
+    "crypto/rsa"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "math/big"
+    "strings"
+    "time"
+)
+
+// IDTokenClaims are the OpenID Connect ID token claims we use
+type IDTokenClaims struct {
+    Issuer            string       `json:"iss"`
+    Subject           string       `json:"sub"`
+    Audience          audience     `json:"aud"`
+    AuthorizedParty   string       `json:"azp"`
+    Expiry            int64        `json:"exp"`
+    IssuedAt          int64        `json:"iat"`
+    AuthTime          int64        `json:"auth_time"`
+    Nonce             string       `json:"nonce"`
+    Email             string       `json:"email"`
+    EmailVerified     flexibleBool `json:"email_verified"`
+    Name              string       `json:"name"`
+    PreferredUsername string       `json:"preferred_username"`
+}
+
+// audience is a single audience or a list of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+    var single string
+    if err := json.Unmarshal(data, &single); err == nil {
+        *a = audience{single}
+        return nil
+    }
+    var list []string
+    if err := json.Unmarshal(data, &list); err != nil {
+        return err
+    }
+    *a = list
+    return nil
+}
+
+func (a audience) contains(value string) bool {
+    for _, item := range a {
+        if item == value {
+            return true
+        }
+    }
+    return false
+}
+
+// flexibleBool accepts true as well as "true", some providers send booleans as strings
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+    *b = flexibleBool(strings.Trim(string(data), `"`) == "true")
+    return nil
+}
+
+// jsonWebKey is an RSA key from a JWKS document
+type jsonWebKey struct {
+    KeyID     string `json:"kid"`
+    KeyType   string `json:"kty"`
+    Algorithm string `json:"alg"`
+    Use       string `json:"use"`
+    N         string `json:"n"`
+    E         string `json:"e"`
+}
+
+func (k jsonWebKey) publicKey() (*rsa.PublicKey, error) {
+    if k.KeyType != "RSA" {
+        return nil, errors.New("unsupported key type " + k.KeyType)
+    }
+    n, err := base64.RawURLEncoding.DecodeString(k.N)
+    if err != nil {
+        return nil, err
+    }
+    e, err := base64.RawURLEncoding.DecodeString(k.E)
+    if err != nil {
+        return nil, err
+    }
+    exponent := new(big.Int).SetBytes(e)
+    if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+        return nil, errors.New("invalid key exponent")
+    }
+    return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+// parseIDToken checks the RS256 signature of an ID token and returns its claims.
+// keyFor looks up the signing key by its key ID. The claims aren't validated here.
+func parseIDToken(token string, keyFor func(kid string) (*rsa.PublicKey, error)) (IDTokenClaims, error) {
+    parts := strings.Split(token, ".")
+    if len(parts) != 3 {
+        return IDTokenClaims{}, errors.New("malformed ID token")
+    }
+
+    var header struct {
+        Algorithm string `json:"alg"`
+        KeyID     string `json:"kid"`
+    }
+    if err := decodeTokenPart(parts[0], &header); err != nil {
+        return IDTokenClaims{}, err
+    }
+    // Only RS256, so a token can't choose "none" or an HMAC keyed with our public key
+    if header.Algorithm != "RS256" {
+        return IDTokenClaims{}, errors.New("unsupported ID token algorithm " + header.Algorithm)
+    }
+
+    key, err := keyFor(header.KeyID)
+    if err != nil {
+        return IDTokenClaims{}, err
+    }
+    signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+    if err != nil {
+        return IDTokenClaims{}, errors.New("malformed ID token signature")
+    }
+    digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+    if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+        return IDTokenClaims{}, errors.New("invalid ID token signature")
+    }
+
+    var claims IDTokenClaims
+    if err := decodeTokenPart(parts[1], &claims); err != nil {
+        return IDTokenClaims{}, err
+    }
+    return claims, nil
+}
+
+// validate checks the claims are for us, from the expected issuer, unexpired and for this login
+func (c IDTokenClaims) validate(issuer, clientID, nonce string, now time.Time) error {
+    const leeway = time.Minute
+    if c.Issuer != issuer {
+        return errors.New("ID token has the wrong issuer")
+    }
+    if !c.Audience.contains(clientID) {
+        return errors.New("ID token is for another client")
+    }
+    if len(c.Audience) > 1 && c.AuthorizedParty != clientID {
+        return errors.New("ID token is for another client")
+    }
+    if now.After(time.Unix(c.Expiry, 0).Add(leeway)) {
+        return errors.New("ID token has expired")
+    }
+    if c.IssuedAt != 0 && time.Unix(c.IssuedAt, 0).After(now.Add(leeway)) {
+        return errors.New("ID token is issued in the future")
+    }
+    if c.Nonce != nonce {
+        return errors.New("ID token nonce doesn't match")
+    }
+    if c.Subject == "" {
+        return errors.New("ID token has no subject")
+    }
+    return nil
+}
+
+// freshSignIn checks the user actually signed in at the provider within maxAge,
+// rather than being let through by an existing provider session
+func (c IDTokenClaims) freshSignIn(now time.Time, maxAge time.Duration) error {
+    const leeway = time.Minute
+    if c.AuthTime == 0 {
+        return errors.New("ID token has no sign-in time")
+    }
+    authTime := time.Unix(c.AuthTime, 0)
+    if authTime.Before(now.Add(-maxAge)) || authTime.After(now.Add(leeway)) {
+        return errors.New("sign-in at the provider isn't recent")
+    }
+    return nil
+}
+
+func decodeTokenPart(part string, v interface{}) error {
+    data, err := base64.RawURLEncoding.DecodeString(part)
+    if err != nil {
+        return errors.New("malformed ID token")
+    }
+    if err := json.Unmarshal(data, v); err != nil {
+        return errors.New("malformed ID token")
+    }
+    return nil
+}
@@ -0,0 +1,397 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "regexp"
+    "strings"
+    "time"
+    "notes-app/config"
+    "notes-app/models"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+)
+
+// linkPattern matches [[Note Title]], [[Note Title|label]] and [[<note id>]]
+var linkPattern = regexp.MustCompile(`\[\[([^\[\]|]+)(\|[^\[\]]*)?\]\]`)
+
+type LinkService struct{}
+
+func NewLinkService() *LinkService {
+    return &LinkService{}
+}
+
+func (s *LinkService) EnsureIndexes() error {
+    ctx := context.Background()
+    _, err := config.DB.Collection("note_links").Indexes().CreateMany(ctx, []mongo.IndexModel{
+        {Keys: bson.M{"sourceId": 1}},
+        {Keys: bson.M{"targetId": 1}},
+        {Keys: bson.M{"targetKey": 1}},
+    })
+    return err
+}
+
+// ParseLinks returns the distinct link targets referenced in content
+func ParseLinks(content string) []string {
+    seen := map[string]bool{}
+    var targets []string
+    for _, match := range linkPattern.FindAllStringSubmatch(content, -1) {
+        target := strings.TrimSpace(match[1])
+        key := strings.ToLower(target)
+        if target == "" || seen[key] {
+            continue
+        }
+        seen[key] = true
+        targets = append(targets, target)
+    }
+    return targets
+}
+
+// IndexNote rebuilds the outgoing links of a note from its current content
+// and resolves links from other notes that were waiting for its title.
+func (s *LinkService) IndexNote(noteID primitive.ObjectID) error {
+    ctx := context.Background()
+
+    var note models.Note
+    err := config.DB.Collection("notes").FindOne(ctx, bson.M{"_id": noteID}).Decode(&note)
+    if err != nil {
+        return err
+    }
+
+    _, err = config.DB.Collection("note_links").DeleteMany(ctx, bson.M{"sourceId": noteID})
+    if err != nil {
+        return err
+    }
+
+    var links []interface{}
+    for _, target := range ParseLinks(note.Content) {
+        link := models.NoteLink{
+            ID:          primitive.NewObjectID(),
+            SourceID:    noteID,
+            TargetTitle: target,
+            TargetKey:   strings.ToLower(target),
+            CreatedAt:   time.Now(),
+        }
+        if targetNote, err := s.resolveTarget(note, target); err == nil {
+            link.TargetID = &targetNote.ID
+            link.TargetTitle = targetNote.Title
+            link.TargetKey = strings.ToLower(targetNote.Title)
+        }
+        links = append(links, link)
+    }
+
+    if len(links) > 0 {
+        if _, err = config.DB.Collection("note_links").InsertMany(ctx, links); err != nil {
+            return err
+        }
+    }
+
+    return s.resolvePending(note)
+}
+
+// linkRewrite is a note whose content RenameTarget rewrote, with the note as it was
+// before and the version saved of it
+type linkRewrite struct {
+    before    models.Note
+    content   string
+    versionID primitive.ObjectID
+}
+
+// RenameTarget rewrites [[Old Title]] references in linking notes after the target
+// was renamed. A linking note is only rewritten if everyone who can read it can also
+// read the target, otherwise the new title would reach people without access to it.
+// Rewritten notes get a version and a new updatedAt like any other edit.
+func (s *LinkService) RenameTarget(target models.Note, oldTitle string) ([]linkRewrite, error) {
+    ctx := context.Background()
+
+    newTitle := target.Title
+    if oldTitle == newTitle || strings.TrimSpace(newTitle) == "" {
+        return nil, nil
+    }
+
+    cursor, err := config.DB.Collection("note_links").Find(ctx, bson.M{
+        "targetId": target.ID,
+        "targetKey": strings.ToLower(oldTitle),
+    })
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+
+    var links []models.NoteLink
+    if err = cursor.All(ctx, &links); err != nil {
+        return nil, err
+    }
+
+    readers := map[primitive.ObjectID]bool{target.UserID: true}
+    for _, collaboratorID := range target.Collaborators {
+        readers[collaboratorID] = true
+    }
+
+    var rewrites []linkRewrite
+    oldRef := regexp.MustCompile(`(?i)\[\[\s*` + regexp.QuoteMeta(oldTitle) + `\s*(\|[^\[\]]*)?\]\]`)
+    for _, link := range links {
+        var source models.Note
+        err := config.DB.Collection("notes").FindOne(ctx, bson.M{"_id": link.SourceID}).Decode(&source)
+        if err != nil {
+            continue
+        }
+        if !readers[source.UserID] {
+            continue
+        }
+        shared := true
+        for _, collaboratorID := range source.Collaborators {
+            if !readers[collaboratorID] {
+                shared = false
+                break
+            }
+        }
+        if !shared {
+            continue
+        }
+
+        content := oldRef.ReplaceAllString(source.Content, "[["+strings.ReplaceAll(newTitle, "$", "$$")+"$1]]")
+        if content == source.Content {
+            continue
+        }
+
+        version := models.NoteVersion{
+            ID:          primitive.NewObjectID(),
+            NoteID:      source.ID,
+            Title:       source.Title,
+            Content:     source.Content,
+            VersionedAt: time.Now(),
+        }
+        if _, err := config.DB.Collection("note_versions").InsertOne(ctx, version); err != nil {
+            return rewrites, err
+        }
+        _, err = config.DB.Collection("notes").UpdateOne(ctx, bson.M{"_id": source.ID}, bson.M{
+            "$set": bson.M{"content": content, "updatedAt": time.Now()},
+        })
+        if err != nil {
+            return rewrites, err
+        }
+        rewrites = append(rewrites, linkRewrite{before: source, content: content, versionID: version.ID})
+    }
+
+    _, err = config.DB.Collection("note_links").UpdateMany(ctx, bson.M{
+        "targetId": target.ID,
+    }, bson.M{
+        "$set": bson.M{"targetTitle": newTitle, "targetKey": strings.ToLower(newTitle)},
+    })
+    return rewrites, err
+}
+
+// GetBacklinks returns the notes visible to the user that link to the given note
+func (s *LinkService) GetBacklinks(noteID, userID primitive.ObjectID) ([]models.LinkedNoteResponse, error) {
+    ctx := context.Background()
+
+    if _, err := s.findAccessibleNote(noteID, userID); err != nil {
+        return nil, err
+    }
+
+    cursor, err := config.DB.Collection("note_links").Find(ctx, bson.M{"targetId": noteID})
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+
+    var links []models.NoteLink
+    if err = cursor.All(ctx, &links); err != nil {
+        return nil, err
+    }
+
+    var sourceIDs []primitive.ObjectID
+    for _, link := range links {
+        sourceIDs = append(sourceIDs, link.SourceID)
+    }
+
+    notes, err := s.findAccessibleNotes(sourceIDs, userID)
+    if err != nil {
+        return nil, err
+    }
+
+    result := []models.LinkedNoteResponse{}
+    for _, note := range notes {
+        result = append(result, models.LinkedNoteResponse{
+            ID:       note.ID.Hex(),
+            Title:    note.Title,
+            Resolved: true,
+        })
+    }
+    return result, nil
+}
+
+// GetOutlinks returns the links of a note. Targets the user can't see are left out,
+// links that don't point at any note yet are returned unresolved.
+func (s *LinkService) GetOutlinks(noteID, userID primitive.ObjectID) ([]models.LinkedNoteResponse, error) {
+    ctx := context.Background()
+
+    if _, err := s.findAccessibleNote(noteID, userID); err != nil {
+        return nil, err
+    }
+
+    cursor, err := config.DB.Collection("note_links").Find(ctx, bson.M{"sourceId": noteID})
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+
+    var links []models.NoteLink
+    if err = cursor.All(ctx, &links); err != nil {
+        return nil, err
+    }
+
+    var targetIDs []primitive.ObjectID
+    for _, link := range links {
+        if link.TargetID != nil {
+            targetIDs = append(targetIDs, *link.TargetID)
+        }
+    }
+
+    notes, err := s.findAccessibleNotes(targetIDs, userID)
+    if err != nil {
+        return nil, err
+    }
+    visible := map[primitive.ObjectID]models.Note{}
+    for _, note := range notes {
+        visible[note.ID] = note
+    }
+
+    result := []models.LinkedNoteResponse{}
+    for _, link := range links {
+        if link.TargetID == nil {
+            result = append(result, models.LinkedNoteResponse{Title: link.TargetTitle})
+            continue
+        }
+        if note, ok := visible[*link.TargetID]; ok {
+            result = append(result, models.LinkedNoteResponse{
+                ID:       note.ID.Hex(),
+                Title:    note.Title,
+                Resolved: true,
+            })
+        }
+    }
+    return result, nil
+}
+
+// resolveTarget finds the note a link points at, as seen by the owner of the linking note
+func (s *LinkService) resolveTarget(source models.Note, target string) (models.Note, error) {
+    ctx := context.Background()
+
+    filter := bson.M{
+        "trashed": false,
+        "$or": []bson.M{
+            {"userId": source.UserID},
+            {"collaborators": source.UserID},
+        },
+    }
+    if targetID, err := primitive.ObjectIDFromHex(target); err == nil {
+        filter["_id"] = targetID
+    } else {
+        filter["title"] = bson.M{"$regex": "^" + regexp.QuoteMeta(target) + "$", "$options": "i"}
+    }
+
+    var note models.Note
+    err := config.DB.Collection("notes").FindOne(ctx, filter).Decode(&note)
+    if err != nil {
+        return models.Note{}, err
+    }
+    if note.ID == source.ID {
+        return models.Note{}, errors.New("self link")
+    }
+    return note, nil
+}
+
+// resolvePending points unresolved links at a note whose title they reference,
+// as long as the owner of the linking note can access it
+func (s *LinkService) resolvePending(note models.Note) error {
+    ctx := context.Background()
+
+    if note.Title == "" {
+        return nil
+    }
+
+    cursor, err := config.DB.Collection("note_links").Find(ctx, bson.M{
+        "targetId": nil,
+        "targetKey": strings.ToLower(note.Title),
+    })
+    if err != nil {
+        return err
+    }
+    defer cursor.Close(ctx)
+
+    var links []models.NoteLink
+    if err = cursor.All(ctx, &links); err != nil {
+        return err
+    }
+
+    allowed := map[primitive.ObjectID]bool{note.UserID: true}
+    for _, collaboratorID := range note.Collaborators {
+        allowed[collaboratorID] = true
+    }
+
+    for _, link := range links {
+        if link.SourceID == note.ID {
+            continue
+        }
+        var source models.Note
+        err := config.DB.Collection("notes").FindOne(ctx, bson.M{"_id": link.SourceID}).Decode(&source)
+        if err != nil || !allowed[source.UserID] {
+            continue
+        }
+        _, err = config.DB.Collection("note_links").UpdateOne(ctx, bson.M{"_id": link.ID}, bson.M{
+            "$set": bson.M{"targetId": note.ID, "targetTitle": note.Title},
+        })
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (s *LinkService) findAccessibleNote(noteID, userID primitive.ObjectID) (models.Note, error) {
+    ctx := context.Background()
+
+    var note models.Note
+    err := config.DB.Collection("notes").FindOne(ctx, bson.M{
+        "_id": noteID,
+        "$or": []bson.M{
+            {"userId": userID},
+            {"collaborators": userID},
+        },
+    }).Decode(&note)
+
+    if err != nil {
+        return models.Note{}, errors.New("note not found or access denied")
+    }
+    return note, nil
+}
+
+func (s *LinkService) findAccessibleNotes(noteIDs []primitive.ObjectID, userID primitive.ObjectID) ([]models.Note, error) {
+    ctx := context.Background()
+
+    if len(noteIDs) == 0 {
+        return nil, nil
+    }
+
+    cursor, err := config.DB.Collection("notes").Find(ctx, bson.M{
+        "_id": bson.M{"$in": noteIDs},
+        "trashed": false,
+        "$or": []bson.M{
+            {"userId": userID},
+            {"collaborators": userID},
+        },
+    })
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+
+    var notes []models.Note
+    if err = cursor.All(ctx, &notes); err != nil {
+        return nil, err
+    }
+    return notes, nil
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+    "strings"
+    "time"
+    "notes-app/config"
+)
+
+// LoginThrottledError is returned when a login is refused before the password is checked
+type LoginThrottledError struct {
+    RetryAfter time.Duration
+    // Locked is set when the account itself is locked, not just slowed down
+    Locked bool
+}
+
+func (e *LoginThrottledError) Error() string {
+    if e.Locked {
+        return "account is temporarily locked after too many failed sign-ins, try again in " + humanDuration(e.RetryAfter)
+    }
+    return "too many failed sign-ins, try again in " + humanDuration(e.RetryAfter)
+}
+
+// LoginGuard counts failed logins per username and per IP. Each failure on a username
+// doubles the wait before the next attempt, and enough failures lock the username or IP
+// for a while. IPs get a higher limit since many users can share one.
+type LoginGuard struct {
+    store         AttemptStore
+    maxFailures   int64
+    maxIPFailures int64
+    window        time.Duration
+    lockout       time.Duration
+    baseDelay     time.Duration
+    maxDelay      time.Duration
+}
+
+func NewLoginGuard(store AttemptStore) *LoginGuard {
+    return &LoginGuard{
+        store:         store,
+        maxFailures:   int64(config.GetEnvInt("LOGIN_MAX_FAILURES", 5)),
+        maxIPFailures: int64(config.GetEnvInt("LOGIN_MAX_IP_FAILURES", 50)),
+        window:        config.GetEnvDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+        lockout:       config.GetEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+        baseDelay:     config.GetEnvDuration("LOGIN_DELAY_BASE", time.Second),
+        maxDelay:      config.GetEnvDuration("LOGIN_DELAY_MAX", 30*time.Second),
+    }
+}
+
+// Check returns a *LoginThrottledError if the username or IP may not try to log in right now
+func (g *LoginGuard) Check(username, ip string) error {
+    name := normalizeLoginName(username)
+
+    if remaining, err := g.store.Remaining("login_lock:user:" + name); err != nil {
+        return err
+    } else if remaining > 0 {
+        return &LoginThrottledError{RetryAfter: remaining, Locked: true}
+    }
+    if remaining, err := g.store.Remaining("login_lock:ip:" + ip); err != nil {
+        return err
+    } else if remaining > 0 {
+        return &LoginThrottledError{RetryAfter: remaining}
+    }
+    if remaining, err := g.store.Remaining("login_delay:user:" + name); err != nil {
+        return err
+    } else if remaining > 0 {
+        return &LoginThrottledError{RetryAfter: remaining}
+    }
+    return nil
+}
+
+// RecordFailure counts a failed login and returns true if it just locked the username
+func (g *LoginGuard) RecordFailure(username, ip string) (bool, error) {
+    name := normalizeLoginName(username)
+
+    ipFailures, err := g.store.Increment("login_fail:ip:"+ip, g.window)
+    if err != nil {
+        return false, err
+    }
+    if ipFailures >= g.maxIPFailures {
+        if err := g.store.Mark("login_lock:ip:"+ip, g.lockout); err != nil {
+            return false, err
+        }
+        g.store.Delete("login_fail:ip:" + ip)
+    }
+
+    failures, err := g.store.Increment("login_fail:user:"+name, g.window)
+    if err != nil {
+        return false, err
+    }
+    if failures >= g.maxFailures {
+        // Counting starts over once the lock runs out
+        if err := g.store.Mark("login_lock:user:"+name, g.lockout); err != nil {
+            return false, err
+        }
+        g.store.Delete("login_fail:user:"+name, "login_delay:user:"+name)
+        return true, nil
+    }
+    if delay := g.delay(failures); delay > 0 {
+        if err := g.store.Mark("login_delay:user:"+name, delay); err != nil {
+            return false, err
+        }
+    }
+    return false, nil
+}
+
+// RecordSuccess clears the failures of a username. IP counters are kept so one
+// working account can't be used to reset the limit for guessing others.
+func (g *LoginGuard) RecordSuccess(username string) error {
+    name := normalizeLoginName(username)
+    return g.store.Delete("login_fail:user:"+name, "login_delay:user:"+name)
+}
+
+// Unlock lifts a lockout of a username and clears its failures
+func (g *LoginGuard) Unlock(username string) error {
+    name := normalizeLoginName(username)
+    return g.store.Delete("login_lock:user:"+name, "login_fail:user:"+name, "login_delay:user:"+name)
+}
+
+// LockoutDuration is how long a username stays locked
+func (g *LoginGuard) LockoutDuration() time.Duration {
+    return g.lockout
+}
+
+// delay is the wait after the given number of failures: none after the first,
+// then baseDelay, doubling up to maxDelay
+func (g *LoginGuard) delay(failures int64) time.Duration {
+    if failures < 2 {
+        return 0
+    }
+    delay := g.baseDelay
+    for i := int64(2); i < failures && delay < g.maxDelay; i++ {
+        delay *= 2
+    }
+    if delay > g.maxDelay {
+        delay = g.maxDelay
+    }
+    return delay
+}
+
+func normalizeLoginName(username string) string {
+    return strings.ToLower(strings.TrimSpace(username))
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+    "errors"
+    "testing"
+    "time"
+)
+
+// testLoginGuard returns a guard on a MemoryAttemptStore whose clock only moves with advance
+func testLoginGuard() (*LoginGuard, func(time.Duration)) {
+    now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+    store := NewMemoryAttemptStore()
+    store.now = func() time.Time { return now }
+    guard := &LoginGuard{
+        store:         store,
+        maxFailures:   5,
+        maxIPFailures: 8,
+        window:        15 * time.Minute,
+        lockout:       10 * time.Minute,
+        baseDelay:     time.Second,
+        maxDelay:      4 * time.Second,
+    }
+    return guard, func(d time.Duration) { now = now.Add(d) }
+}
+
+// throttled returns the *LoginThrottledError of Check, or nil if the login may go ahead
+func throttled(t *testing.T, guard *LoginGuard, username, ip string) *LoginThrottledError {
+    t.Helper()
+    err := guard.Check(username, ip)
+    if err == nil {
+        return nil
+    }
+    var throttledErr *LoginThrottledError
+    if !errors.As(err, &throttledErr) {
+        t.Fatalf("Check: %v", err)
+    }
+    return throttledErr
+}
+
+func TestLoginGuardDelay(t *testing.T) {
+    guard, _ := testLoginGuard()
+    tests := []struct {
+        failures int64
+        want     time.Duration
+    }{
+        {1, 0},
+        {2, time.Second},
+        {3, 2 * time.Second},
+        {4, 4 * time.Second},
+        {5, 4 * time.Second},
+        {20, 4 * time.Second},
+    }
+    for _, test := range tests {
+        if got := guard.delay(test.failures); got != test.want {
+            t.Errorf("delay after %d failures = %v, want %v", test.failures, got, test.want)
+        }
+    }
+}
+
+func TestLoginGuardProgressiveDelay(t *testing.T) {
+    guard, advance := testLoginGuard()
+
+    guard.RecordFailure("alice", "10.0.0.1")
+    if err := throttled(t, guard, "alice", "10.0.0.1"); err != nil {
+        t.Fatalf("throttled after one failure: %v", err)
+    }
+
+    guard.RecordFailure("alice", "10.0.0.1")
+    err := throttled(t, guard, "alice", "10.0.0.2")
+    if err == nil || err.Locked || err.RetryAfter != time.Second {
+        t.Fatalf("after two failures got %+v, want a 1s delay from any IP", err)
+    }
+    advance(time.Second)
+    if err := throttled(t, guard, "alice", "10.0.0.1"); err != nil {
+        t.Fatalf("still throttled after the delay: %v", err)
+    }
+
+    guard.RecordFailure("alice", "10.0.0.1")
+    if err := throttled(t, guard, "alice", "10.0.0.1"); err == nil || err.RetryAfter != 2*time.Second {
+        t.Fatalf("after three failures got %+v, want a 2s delay", err)
+    }
+    if err := throttled(t, guard, "bob", "10.0.0.3"); err != nil {
+        t.Errorf("another username was throttled: %v", err)
+    }
+}
+
+func TestLoginGuardLockout(t *testing.T) {
+    guard, advance := testLoginGuard()
+
+    for i := 1; i < 5; i++ {
+        if locked, _ := guard.RecordFailure("Alice", "10.0.0.1"); locked {
+            t.Fatalf("locked after %d failures", i)
+        }
+    }
+    if locked, _ := guard.RecordFailure(" alice ", "10.0.0.2"); !locked {
+        t.Fatal("not locked after 5 failures")
+    }
+
+    err := throttled(t, guard, "ALICE", "10.0.0.3")
+    if err == nil || !err.Locked || err.RetryAfter != 10*time.Minute {
+        t.Fatalf("got %+v, want a 10m lockout", err)
+    }
+
+    advance(10 * time.Minute)
+    if err := throttled(t, guard, "alice", "10.0.0.3"); err != nil {
+        t.Fatalf("still locked after the lockout ran out: %v", err)
+    }
+    // Counting starts over after a lockout
+    if locked, _ := guard.RecordFailure("alice", "10.0.0.3"); locked {
+        t.Error("locked again by the first failure after a lockout")
+    }
+}
+
+func TestLoginGuardWindowExpiry(t *testing.T) {
+    guard, advance := testLoginGuard()
+
+    for i := 0; i < 4; i++ {
+        guard.RecordFailure("alice", "10.0.0.1")
+    }
+    advance(15 * time.Minute)
+
+    if locked, _ := guard.RecordFailure("alice", "10.0.0.1"); locked {
+        t.Error("failures from an expired window counted towards the lockout")
+    }
+    if err := throttled(t, guard, "alice", "10.0.0.1"); err != nil {
+        t.Errorf("throttled by the first failure of a new window: %v", err)
+    }
+}
+
+func TestLoginGuardIPLockout(t *testing.T) {
+    guard, _ := testLoginGuard()
+
+    for i := 0; i < 8; i++ {
+        guard.RecordFailure(string(rune('a'+i)), "10.0.0.1")
+    }
+
+    err := throttled(t, guard, "someone", "10.0.0.1")
+    if err == nil || err.Locked {
+        t.Fatalf("got %+v, want the IP to be throttled", err)
+    }
+    if err := throttled(t, guard, "someone", "10.0.0.2"); err != nil {
+        t.Errorf("another IP was throttled: %v", err)
+    }
+}
+
+func TestLoginGuardRecordSuccessKeepsIPFailures(t *testing.T) {
+    guard, _ := testLoginGuard()
+
+    for i := 0; i < 4; i++ {
+        guard.RecordFailure("alice", "10.0.0.1")
+    }
+    guard.RecordSuccess("alice")
+    if err := throttled(t, guard, "alice", "10.0.0.1"); err != nil {
+        t.Fatalf("throttled after a successful login: %v", err)
+    }
+    if locked, _ := guard.RecordFailure("alice", "10.0.0.1"); locked {
+        t.Error("failures before the successful login still counted for the username")
+    }
+
+    // The IP has 5 failures now, 3 more lock it although a login succeeded in between
+    for i := 0; i < 3; i++ {
+        guard.RecordFailure("bob", "10.0.0.1")
+    }
+    if err := throttled(t, guard, "carol", "10.0.0.1"); err == nil {
+        t.Error("a successful login reset the IP's failures")
+    }
+}
+
+func TestLoginGuardUnlock(t *testing.T) {
+    guard, _ := testLoginGuard()
+
+    for i := 0; i < 5; i++ {
+        guard.RecordFailure("alice", "10.0.0.1")
+    }
+    if err := throttled(t, guard, "alice", "10.0.0.1"); err == nil || !err.Locked {
+        t.Fatalf("got %+v, want a lockout", err)
+    }
+
+    if err := guard.Unlock("Alice"); err != nil {
+        t.Fatalf("Unlock: %v", err)
+    }
+    if err := throttled(t, guard, "alice", "10.0.0.1"); err != nil {
+        t.Errorf("still throttled after Unlock: %v", err)
+    }
+    if locked, _ := guard.RecordFailure("alice", "10.0.0.1"); locked {
+        t.Error("failures before Unlock still counted")
+    }
+}
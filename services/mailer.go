@@ -0,0 +1,112 @@
+package services
+
+import (
+    "bytes"
+    "fmt"
+    "log"
+    "mime/multipart"
+    "net/smtp"
+    "net/textproto"
+    "sync"
+    "notes-app/config"
+)
+
+type Email struct {
+    To      string
+    Subject string
+    Text    string
+    HTML    string
+}
+
+// Mailer sends emails. SMTPMailer is used when SMTP_HOST is configured,
+// otherwise emails are kept in a CaptureMailer.
+type Mailer interface {
+    Send(email Email) error
+}
+
+// NewMailer returns an SMTPMailer configured from the environment, or a CaptureMailer if SMTP_HOST is unset
+func NewMailer() Mailer {
+    host := config.GetEnv("SMTP_HOST", "")
+    if host == "" {
+        log.Println("SMTP_HOST not set, emails will be captured locally")
+        return NewCaptureMailer()
+    }
+    return &SMTPMailer{
+        Host:     host,
+        Port:     config.GetEnv("SMTP_PORT", "587"),
+        Username: config.GetEnv("SMTP_USERNAME", ""),
+        Password: config.GetEnv("SMTP_PASSWORD", ""),
+        From:     config.GetEnv("SMTP_FROM", "notes@localhost"),
+    }
+}
+
+type SMTPMailer struct {
+    Host     string
+    Port     string
+    Username string
+    Password string
+    From     string
+}
+
+func (m *SMTPMailer) Send(email Email) error {
+    var body bytes.Buffer
+    writer := multipart.NewWriter(&body)
+
+    fmt.Fprintf(&body, "From: %s\r\n", m.From)
+    fmt.Fprintf(&body, "To: %s\r\n", email.To)
+    fmt.Fprintf(&body, "Subject: %s\r\n", email.Subject)
+    fmt.Fprintf(&body, "MIME-Version: 1.0\r\n")
+    fmt.Fprintf(&body, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+
+    parts := []struct{ contentType, content string }{
+        {"text/plain; charset=utf-8", email.Text},
+        {"text/html; charset=utf-8", email.HTML},
+    }
+    for _, part := range parts {
+        if part.content == "" {
+            continue
+        }
+        w, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+        if err != nil {
+            return err
+        }
+        if _, err := w.Write([]byte(part.content)); err != nil {
+            return err
+        }
+    }
+    if err := writer.Close(); err != nil {
+        return err
+    }
+
+    var auth smtp.Auth
+    if m.Username != "" {
+        auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+    }
+    return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{email.To}, body.Bytes())
+}
+
+// CaptureMailer keeps sent emails in memory instead of delivering them.
+// It is meant for local development and tests.
+type CaptureMailer struct {
+    mu   sync.Mutex
+    sent []Email
+}
+
+func NewCaptureMailer() *CaptureMailer {
+    return &CaptureMailer{}
+}
+
+func (m *CaptureMailer) Send(email Email) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.sent = append(m.sent, email)
+    log.Printf("Captured email to %s: %s\n", email.To, email.Subject)
+    return nil
+}
+
+// Sent returns a copy of the emails captured so far
+func (m *CaptureMailer) Sent() []Email {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return append([]Email{}, m.sent...)
+}
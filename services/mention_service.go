@@ -0,0 +1,189 @@
+package services
+
+import (
+    "context"
+    "regexp"
+    "strings"
+    "time"
+    "notes-app/config"
+    "notes-app/models"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mentionPattern matches @username at the start of the text or after whitespace or punctuation,
+// so email addresses aren't picked up as mentions
+var mentionPattern = regexp.MustCompile(`(?:^|[\s(\[{,;:])@([A-Za-z0-9_.-]+)`)
+
+type MentionService struct {
+    authService *AuthService
+}
+
+func NewMentionService(authService *AuthService) *MentionService {
+    return &MentionService{authService: authService}
+}
+
+func (s *MentionService) EnsureIndexes() error {
+    ctx := context.Background()
+    _, err := config.DB.Collection("note_mentions").Indexes().CreateMany(ctx, []mongo.IndexModel{
+        {
+            Keys:    bson.D{{Key: "noteId", Value: 1}, {Key: "userId", Value: 1}},
+            Options: options.Index().SetUnique(true),
+        },
+        {Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}}},
+    })
+    return err
+}
+
+// ParseMentions returns the distinct usernames mentioned in text
+func ParseMentions(text string) []string {
+    seen := map[string]bool{}
+    var usernames []string
+    for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+        username := strings.TrimRight(match[1], ".-")
+        if username == "" || seen[username] {
+            continue
+        }
+        seen[username] = true
+        usernames = append(usernames, username)
+    }
+    return usernames
+}
+
+// ResolveMentions returns the existing users mentioned in content
+func (s *MentionService) ResolveMentions(content string) ([]models.User, error) {
+    return s.authService.ResolveUsernames(ParseMentions(content))
+}
+
+// Sync makes userIDs the set of users mentioned in a note and returns
+// the ones that weren't mentioned in it before
+func (s *MentionService) Sync(noteID, actorID primitive.ObjectID, userIDs []primitive.ObjectID) ([]primitive.ObjectID, error) {
+    ctx := context.Background()
+
+    if userIDs == nil {
+        userIDs = []primitive.ObjectID{}
+    }
+    _, err := config.DB.Collection("note_mentions").DeleteMany(ctx, bson.M{
+        "noteId": noteID,
+        "userId": bson.M{"$nin": userIDs},
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    var added []primitive.ObjectID
+    for _, userID := range userIDs {
+        result, err := config.DB.Collection("note_mentions").UpdateOne(ctx, bson.M{
+            "noteId": noteID,
+            "userId": userID,
+        }, bson.M{
+            "$setOnInsert": bson.M{"mentionedBy": actorID, "createdAt": time.Now()},
+        }, options.Update().SetUpsert(true))
+        if err != nil {
+            return nil, err
+        }
+        if result.UpsertedCount > 0 {
+            added = append(added, userID)
+        }
+    }
+    return added, nil
+}
+
+// GetMentions returns the notes the user is mentioned in and can still access, newest mention first
+func (s *MentionService) GetMentions(userID primitive.ObjectID, page, limit int) (models.MentionListResponse, error) {
+    ctx := context.Background()
+
+    // Mentions of notes the user can no longer access are filtered out before paging
+    pipeline := mongo.Pipeline{
+        {{Key: "$match", Value: bson.M{"userId": userID}}},
+        {{Key: "$lookup", Value: bson.M{
+            "from":     "notes",
+            "let":      bson.M{"noteId": "$noteId"},
+            "pipeline": bson.A{
+                bson.M{"$match": bson.M{
+                    "$expr":   bson.M{"$eq": bson.A{"$_id", "$$noteId"}},
+                    "trashed": false,
+                    "$or": bson.A{
+                        bson.M{"userId": userID},
+                        bson.M{"collaborators": userID},
+                    },
+                }},
+                bson.M{"$project": bson.M{"title": 1}},
+            },
+            "as": "note",
+        }}},
+        {{Key: "$unwind", Value: "$note"}},
+        {{Key: "$sort", Value: bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}}},
+        {{Key: "$facet", Value: bson.M{
+            "items": bson.A{bson.M{"$skip": (page - 1) * limit}, bson.M{"$limit": limit}},
+            "total": bson.A{bson.M{"$count": "count"}},
+        }}},
+    }
+    cursor, err := config.DB.Collection("note_mentions").Aggregate(ctx, pipeline)
+    if err != nil {
+        return models.MentionListResponse{}, err
+    }
+    var results []struct {
+        Items []struct {
+            models.NoteMention `bson:",inline"`
+            Note               models.Note `bson:"note"`
+        } `bson:"items"`
+        Total []struct {
+            Count int `bson:"count"`
+        } `bson:"total"`
+    }
+    if err = cursor.All(ctx, &results); err != nil {
+        return models.MentionListResponse{}, err
+    }
+    total := 0
+    var pageItems []models.NoteMention
+    notes := map[primitive.ObjectID]models.Note{}
+    if len(results) > 0 {
+        for _, item := range results[0].Items {
+            pageItems = append(pageItems, item.NoteMention)
+            notes[item.NoteID] = item.Note
+        }
+        if len(results[0].Total) > 0 {
+            total = results[0].Total[0].Count
+        }
+    }
+
+    var actorIDs []primitive.ObjectID
+    for _, mention := range pageItems {
+        actorIDs = append(actorIDs, mention.MentionedBy)
+    }
+    actors := map[primitive.ObjectID]models.User{}
+    if len(actorIDs) > 0 {
+        cursor, err = config.DB.Collection("users").Find(ctx, bson.M{"_id": bson.M{"$in": actorIDs}})
+        if err != nil {
+            return models.MentionListResponse{}, err
+        }
+        var users []models.User
+        if err = cursor.All(ctx, &users); err != nil {
+            return models.MentionListResponse{}, err
+        }
+        for _, user := range users {
+            actors[user.ID] = user
+        }
+    }
+
+    responses := []models.MentionResponse{}
+    for _, mention := range pageItems {
+        actor := actors[mention.MentionedBy]
+        responses = append(responses, models.MentionResponse{
+            NoteID:      mention.NoteID.Hex(),
+            NoteTitle:   notes[mention.NoteID].Title,
+            MentionedBy: commenterProfile(mention.MentionedBy, actor),
+            MentionedAt: mention.CreatedAt,
+        })
+    }
+
+    return models.MentionListResponse{
+        Mentions: responses,
+        Total:    total,
+        Page:     page,
+        Limit:    limit,
+    }, nil
+}
@@ -3,6 +3,9 @@ package services
 import ( 
     "context"
     "errors"
+    "log"
+    "regexp"
+    "strconv"
     "time"
     "notes-app/config"
     "notes-app/models"
@@ -11,14 +14,44 @@ import (
     "go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type NoteService struct{}
+type NoteService struct {
+    templateService     *TemplateService
+    linkService         *LinkService
+    reminderService     *ReminderService
+    notificationService *NotificationService
+    webhookService      *WebhookService
+    auditService        *AuditService
+    activityService     *ActivityService
+    mentionService      *MentionService
+}
 
-func NewNoteService() *NoteService {
-    return &NoteService{}
+func NewNoteService(templateService *TemplateService, linkService *LinkService, reminderService *ReminderService, notificationService *NotificationService, webhookService *WebhookService, auditService *AuditService, activityService *ActivityService, mentionService *MentionService) *NoteService {
+    return &NoteService{
+        templateService:     templateService,
+        linkService:         linkService,
+        reminderService:     reminderService,
+        notificationService: notificationService,
+        webhookService:      webhookService,
+        auditService:        auditService,
+        activityService:     activityService,
+        mentionService:      mentionService,
+    }
 }
 
-func (s *NoteService) CreateNote(userID primitive.ObjectID, req models.NoteRequest) (models.NoteResponse, error) {
+func (s *NoteService) CreateNote(userID primitive.ObjectID, req models.NoteRequest, client models.ClientInfo) (models.NoteResponse, error) {
     ctx := context.Background()
+
+    // Prefill from template if requested
+    if req.TemplateID != "" {
+        templateID, err := primitive.ObjectIDFromHex(req.TemplateID)
+        if err != nil {
+            return models.NoteResponse{}, errors.New("invalid template ID")
+        }
+        req, err = s.templateService.ApplyTemplate(templateID, userID, req)
+        if err != nil {
+            return models.NoteResponse{}, err
+        }
+    }
     
     note := models.Note{
         ID:              primitive.NewObjectID(),
@@ -27,18 +60,137 @@ func (s *NoteService) CreateNote(userID primitive.ObjectID, req models.NoteReque
         UserID:          userID,
         Tags:            req.Tags,
         AutoSaveEnabled: req.AutoSaveEnabled,
+        ReminderAt:      req.ReminderAt,
+        DueAt:           req.DueAt,
         CreatedAt:       time.Now(),
         UpdatedAt:       time.Now(),
     }
+    if req.ShareOnMention != nil {
+        note.ShareOnMention = *req.ShareOnMention
+    }
 
     _, err := config.DB.Collection("notes").InsertOne(ctx, note)
     if err != nil {
         return models.NoteResponse{}, err
     }
+    s.indexLinks(note.ID)
+    s.scheduleReminder(note.ID, note.ReminderAt)
+    s.emitWebhook(models.WebhookNoteCreated, note.ID)
+    s.activityService.Record(models.NoteActivity{NoteID: note.ID, ActorID: userID, Type: models.ActivityCreated})
+    s.processMentions(note.ID, userID, client)
 
     return s.noteToResponse(note), nil
 }
 
+// DuplicateNote copies a note the user owns or collaborates on into a new note owned by the user
+func (s *NoteService) DuplicateNote(noteID, userID primitive.ObjectID, req models.DuplicateNoteRequest) (models.NoteResponse, error) {
+    ctx := context.Background()
+
+    var source models.Note
+    err := config.DB.Collection("notes").FindOne(ctx, bson.M{
+        "_id": noteID,
+        "$or": []bson.M{
+            {"userId": userID},
+            {"collaborators": userID},
+        },
+    }).Decode(&source)
+
+    if err != nil {
+        return models.NoteResponse{}, errors.New("note not found or access denied")
+    }
+
+    // The caller becomes the owner, so they can't also be a collaborator. When a
+    // collaborator duplicates, the owner of the source becomes an editor of the copy.
+    var collaborators []primitive.ObjectID
+    roles := map[string]string{}
+    if req.IncludeCollaborators {
+        if source.UserID != userID {
+            collaborators = append(collaborators, source.UserID)
+            roles[source.UserID.Hex()] = models.RoleEditor
+        }
+        for _, collaboratorID := range source.Collaborators {
+            if collaboratorID != userID {
+                collaborators = append(collaborators, collaboratorID)
+                if role, ok := source.Roles[collaboratorID.Hex()]; ok {
+                    roles[collaboratorID.Hex()] = role
+                }
+            }
+        }
+        // Copying the collaborators shares the new note with them
+        if len(collaborators) > 0 {
+            if err := requireVerified(RestrictShare, append([]primitive.ObjectID{userID}, collaborators...)...); err != nil {
+                return models.NoteResponse{}, err
+            }
+        }
+    }
+
+    title, err := s.copyTitle(userID, source.Title)
+    if err != nil {
+        return models.NoteResponse{}, err
+    }
+
+    note := models.Note{
+        ID:              primitive.NewObjectID(),
+        Title:           title,
+        Content:         source.Content,
+        UserID:          userID,
+        Tags:            append([]string{}, source.Tags...),
+        Collaborators:   collaborators,
+        Roles:           roles,
+        AutoSaveEnabled: source.AutoSaveEnabled,
+        CreatedAt:       time.Now(),
+        UpdatedAt:       time.Now(),
+    }
+
+    _, err = config.DB.Collection("notes").InsertOne(ctx, note)
+    if err != nil {
+        return models.NoteResponse{}, err
+    }
+    s.indexLinks(note.ID)
+    s.emitWebhook(models.WebhookNoteCreated, note.ID)
+    s.activityService.Record(models.NoteActivity{NoteID: note.ID, ActorID: userID, Type: models.ActivityCreated})
+
+    return s.noteToResponse(note), nil
+}
+
+// copySuffix matches the suffix DuplicateNote adds, like " (copy)" or " (copy 2)"
+var copySuffix = regexp.MustCompile(` \(copy(?: \d+)?\)$`)
+
+// copyTitle returns the title for a copy of a note: "Plan (copy)", then "Plan (copy 2)"
+// and so on, numbered after the user's existing copies. Copying a copy doesn't stack suffixes.
+func (s *NoteService) copyTitle(userID primitive.ObjectID, title string) (string, error) {
+    ctx := context.Background()
+
+    base := copySuffix.ReplaceAllString(title, "")
+    pattern := "^" + regexp.QuoteMeta(base) + ` \(copy(?: (\d+))?\)$`
+    cursor, err := config.DB.Collection("notes").Find(ctx,
+        bson.M{"userId": userID, "title": bson.M{"$regex": pattern}},
+        options.Find().SetProjection(bson.M{"title": 1}),
+    )
+    if err != nil {
+        return "", err
+    }
+    defer cursor.Close(ctx)
+    var copies []models.Note
+    if err := cursor.All(ctx, &copies); err != nil {
+        return "", err
+    }
+    if len(copies) == 0 {
+        return base + " (copy)", nil
+    }
+
+    highest := 1
+    numbered := regexp.MustCompile(pattern)
+    for _, existing := range copies {
+        if match := numbered.FindStringSubmatch(existing.Title); match != nil && match[1] != "" {
+            if n, err := strconv.Atoi(match[1]); err == nil && n > highest {
+                highest = n
+            }
+        }
+    }
+    return base + " (copy " + strconv.Itoa(highest+1) + ")", nil
+}
+
 func (s *NoteService) GetUserNotes(userID primitive.ObjectID) ([]models.NoteResponse, error) {
     ctx := context.Background()
     
@@ -83,18 +235,12 @@ func (s *NoteService) GetNote(noteID, userID primitive.ObjectID) (models.NoteRes
     return s.noteToResponse(note), nil
 }
 
-func (s *NoteService) UpdateNote(noteID, userID primitive.ObjectID, req models.NoteRequest) (models.NoteResponse, error) {
+func (s *NoteService) UpdateNote(noteID, userID primitive.ObjectID, req models.NoteRequest, client models.ClientInfo) (models.NoteResponse, error) {
     ctx := context.Background()
     
-    // Check if user is owner or collaborator
+    // Check if user is owner or a collaborator allowed to edit
     var currentNote models.Note
-    err := config.DB.Collection("notes").FindOne(ctx, bson.M{
-        "_id": noteID,
-        "$or": []bson.M{
-            {"userId": userID},
-            {"collaborators": userID},
-        },
-    }).Decode(&currentNote)
+    err := config.DB.Collection("notes").FindOne(ctx, editableFilter(noteID, userID)).Decode(&currentNote)
     
     if err != nil {
         return models.NoteResponse{}, errors.New("note not found or access denied")
@@ -111,27 +257,59 @@ func (s *NoteService) UpdateNote(noteID, userID primitive.ObjectID, req models.N
     config.DB.Collection("note_versions").InsertOne(ctx, version)
 
     // Update note
-    update := bson.M{
-        "$set": bson.M{
-            "title":           req.Title,
-            "content":         req.Content,
-            "tags":            req.Tags,
-            "autoSaveEnabled": req.AutoSaveEnabled,
-            "updatedAt":       time.Now(),
-        },
+    fields := bson.M{
+        "title":           req.Title,
+        "content":         req.Content,
+        "tags":            req.Tags,
+        "autoSaveEnabled": req.AutoSaveEnabled,
+        "updatedAt":       time.Now(),
+    }
+    if req.ShareOnMention != nil && currentNote.UserID == userID {
+        fields["shareOnMention"] = *req.ShareOnMention
     }
 
-    result, err := config.DB.Collection("notes").UpdateOne(ctx, bson.M{
-        "_id": noteID,
-        "$or": []bson.M{
-            {"userId": userID},
-            {"collaborators": userID},
-        },
-    }, update)
+    // Reminder and due date only change when the request sets or clears them
+    reminderAt := currentNote.ReminderAt
+    if req.ClearReminder {
+        reminderAt = nil
+    } else if req.ReminderAt != nil {
+        reminderAt = req.ReminderAt
+    }
+    if req.ClearDueAt {
+        fields["dueAt"] = nil
+    } else if req.DueAt != nil {
+        fields["dueAt"] = req.DueAt
+    }
+    reminderChanged := !sameTime(currentNote.ReminderAt, reminderAt)
+    if reminderChanged {
+        fields["reminderAt"] = reminderAt
+        fields["reminderSentAt"] = nil
+    }
+    update := bson.M{"$set": fields}
+
+    result, err := config.DB.Collection("notes").UpdateOne(ctx, editableFilter(noteID, userID), update)
 
     if err != nil || result.MatchedCount == 0 {
         return models.NoteResponse{}, errors.New("failed to update note or access denied")
     }
+    s.activityService.RecordEdit(currentNote, req, userID, &version.ID, false)
+
+    // Keep [[links]] pointing at this note in sync with the new title
+    if currentNote.Title != req.Title {
+        s.renameLinks(noteID, currentNote.Title, userID)
+    }
+    s.indexLinks(noteID)
+    if reminderChanged {
+        s.scheduleReminder(noteID, reminderAt)
+    }
+    s.emitWebhook(models.WebhookNoteUpdated, noteID)
+    if len(currentNote.Collaborators) > 0 {
+        edited := currentNote
+        edited.Title = req.Title
+        recipients := append([]primitive.ObjectID{currentNote.UserID}, currentNote.Collaborators...)
+        s.notificationService.NotifyNoteEvent(recipients, models.NotificationNoteEdited, edited, userID)
+    }
+    s.processMentions(noteID, userID, client)
 
     // Return updated note
     return s.GetNote(noteID, userID)
@@ -150,6 +328,8 @@ func (s *NoteService) DeleteNote(noteID, userID primitive.ObjectID) error {
     if err != nil || result.MatchedCount == 0 {
         return errors.New("note not found")
     }
+    s.emitWebhook(models.WebhookNoteTrashed, noteID)
+    s.activityService.Record(models.NoteActivity{NoteID: noteID, ActorID: userID, Type: models.ActivityTrashed})
 
     return nil
 }
@@ -182,16 +362,23 @@ func (s *NoteService) GetTrashedNotes(userID primitive.ObjectID) ([]models.NoteR
 func (s *NoteService) RestoreNote(noteID, userID primitive.ObjectID) (models.NoteResponse, error) {
     ctx := context.Background()
     
-    result, err := config.DB.Collection("notes").UpdateOne(ctx, bson.M{
+    var note models.Note
+    err := config.DB.Collection("notes").FindOneAndUpdate(ctx, bson.M{
         "_id": noteID,
         "userId": userID,
     }, bson.M{
         "$set": bson.M{"trashed": false, "updatedAt": time.Now()},
-    })
+    }, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&note)
 
-    if err != nil || result.MatchedCount == 0 {
+    if err != nil {
         return models.NoteResponse{}, errors.New("note not found")
     }
+    // Reminders don't fire for trashed notes, queue it again in case it came due meanwhile
+    if note.ReminderAt != nil && note.ReminderSentAt == nil {
+        s.scheduleReminder(noteID, note.ReminderAt)
+    }
+    s.emitWebhook(models.WebhookNoteRestored, noteID)
+    s.activityService.Record(models.NoteActivity{NoteID: noteID, ActorID: userID, Type: models.ActivityRestored})
 
     return s.GetNote(noteID, userID)
 }
@@ -222,6 +409,12 @@ func (s *NoteService) TogglePin(noteID, userID primitive.ObjectID) (models.NoteR
         return models.NoteResponse{}, err
     }
 
+    activityType := models.ActivityUnpinned
+    if newPinnedState {
+        activityType = models.ActivityPinned
+    }
+    s.activityService.Record(models.NoteActivity{NoteID: noteID, ActorID: userID, Type: activityType})
+
     return s.GetNote(noteID, userID)
 }
 
@@ -265,7 +458,7 @@ func (s *NoteService) GetVersionHistory(noteID, userID primitive.ObjectID) ([]mo
     return responses, nil
 }
 
-func (s *NoteService) RestoreVersion(noteID, versionID, userID primitive.ObjectID) (models.NoteResponse, error) {
+func (s *NoteService) RestoreVersion(noteID, versionID, userID primitive.ObjectID, client models.ClientInfo) (models.NoteResponse, error) {
     ctx := context.Background()
     
     // Verify note ownership
@@ -315,6 +508,23 @@ func (s *NoteService) RestoreVersion(noteID, versionID, userID primitive.ObjectI
         return models.NoteResponse{}, err
     }
 
+    if note.Title != version.Title {
+        s.renameLinks(noteID, note.Title, userID)
+    }
+    s.indexLinks(noteID)
+
+    note.Title = version.Title
+    s.notificationService.NotifyNoteEvent(note.Collaborators, models.NotificationVersionRestored, note, userID)
+    s.emitWebhook(models.WebhookNoteUpdated, noteID)
+    s.activityService.Record(models.NoteActivity{
+        NoteID:      noteID,
+        ActorID:     userID,
+        Type:        models.ActivityVersionRestored,
+        VersionID:   &currentVersion.ID,
+        VersionedAt: &version.VersionedAt,
+    })
+    s.auditService.Record(&userID, models.AuditVersionRestored, "note", noteID.Hex(), client, map[string]string{"versionId": versionID.Hex()})
+
     return s.GetNote(noteID, userID)
 }
 
@@ -347,7 +557,7 @@ func (s *NoteService) GetNotesByTag(tag string, userID primitive.ObjectID) ([]mo
     return responses, nil
 }
 
-func (s *NoteService) AutoSaveNote(noteID, userID primitive.ObjectID, req models.NoteRequest) (models.NoteResponse, error) {
+func (s *NoteService) AutoSaveNote(noteID, userID primitive.ObjectID, req models.NoteRequest, client models.ClientInfo) (models.NoteResponse, error) {
     ctx := context.Background()
     
     // Update without creating version for autosave
@@ -360,48 +570,143 @@ func (s *NoteService) AutoSaveNote(noteID, userID primitive.ObjectID, req models
         },
     }
 
-    result, err := config.DB.Collection("notes").UpdateOne(ctx, bson.M{
+    // Fetch the previous state so title changes can be propagated to links
+    var previous models.Note
+    err := config.DB.Collection("notes").FindOneAndUpdate(ctx, bson.M{
         "_id": noteID,
         "userId": userID,
-    }, update)
+    }, update).Decode(&previous)
 
-    if err != nil || result.MatchedCount == 0 {
+    if err != nil {
         return models.NoteResponse{}, errors.New("failed to autosave note")
     }
 
+    if previous.Title != req.Title {
+        s.renameLinks(noteID, previous.Title, userID)
+    }
+    s.indexLinks(noteID)
+    s.emitWebhook(models.WebhookNoteUpdated, noteID)
+    s.activityService.RecordEdit(previous, req, userID, nil, true)
+    s.processMentions(noteID, userID, client)
+
     return s.GetNote(noteID, userID)
 }
 
-func (s *NoteService) AddCollaborator(noteID, ownerID, collaboratorID primitive.ObjectID) error {
+func (s *NoteService) AddCollaborator(noteID, ownerID, collaboratorID primitive.ObjectID, role string, client models.ClientInfo) error {
     ctx := context.Background()
+    if role == "" {
+        role = models.RoleEditor
+    }
+    if role != models.RoleEditor && role != models.RoleCommenter && role != models.RoleViewer {
+        return errors.New("role must be editor, commenter or viewer")
+    }
+    if err := requireVerified(RestrictShare, ownerID, collaboratorID); err != nil {
+        if err == ErrEmailNotVerified {
+            return errors.New("both accounts need a verified email address to share notes")
+        }
+        return err
+    }
+    // Editor is the default, only other roles are stored
+    update := bson.M{"$addToSet": bson.M{"collaborators": collaboratorID}}
+    if role == models.RoleEditor {
+        update["$unset"] = bson.M{"roles." + collaboratorID.Hex(): ""}
+    } else {
+        update["$set"] = bson.M{"roles." + collaboratorID.Hex(): role}
+    }
     // Only owner can add
-    result := config.DB.Collection("notes").FindOneAndUpdate(ctx, bson.M{
+    var note models.Note
+    err := config.DB.Collection("notes").FindOneAndUpdate(ctx, bson.M{
         "_id": noteID,
         "userId": ownerID,
-    }, bson.M{
-        "$addToSet": bson.M{"collaborators": collaboratorID},
-    })
-    if result.Err() != nil {
+    }, update).Decode(&note)
+    if err != nil {
         return errors.New("not found or not owner")
     }
+    if !containsID(note.Collaborators, collaboratorID) {
+        s.notificationService.NotifyNoteEvent([]primitive.ObjectID{collaboratorID}, models.NotificationNoteShared, note, ownerID)
+        s.emitWebhook(models.WebhookNoteShared, noteID)
+        s.activityService.Record(models.NoteActivity{NoteID: noteID, ActorID: ownerID, Type: models.ActivityShared, TargetUserID: &collaboratorID})
+        s.auditService.Record(&ownerID, models.AuditNoteShared, "note", noteID.Hex(), client, map[string]string{"collaboratorId": collaboratorID.Hex()})
+    }
     return nil
 }
 
 // RemoveCollaborator removes a collaborator from a note (only owner can do this)
-func (s *NoteService) RemoveCollaborator(noteID, ownerID, collaboratorID primitive.ObjectID) error {
+func (s *NoteService) RemoveCollaborator(noteID, ownerID, collaboratorID primitive.ObjectID, client models.ClientInfo) error {
     ctx := context.Background()
-    result := config.DB.Collection("notes").FindOneAndUpdate(ctx, bson.M{
+    var note models.Note
+    err := config.DB.Collection("notes").FindOneAndUpdate(ctx, bson.M{
         "_id": noteID,
         "userId": ownerID,
     }, bson.M{
         "$pull": bson.M{"collaborators": collaboratorID},
-    })
-    if result.Err() != nil {
+        "$unset": bson.M{"roles." + collaboratorID.Hex(): ""},
+    }).Decode(&note)
+    if err != nil {
         return errors.New("not found or not owner")
     }
+    if containsID(note.Collaborators, collaboratorID) {
+        s.notificationService.NotifyNoteEvent([]primitive.ObjectID{collaboratorID}, models.NotificationNoteUnshared, note, ownerID)
+        s.activityService.Record(models.NoteActivity{NoteID: noteID, ActorID: ownerID, Type: models.ActivityUnshared, TargetUserID: &collaboratorID})
+        s.auditService.Record(&ownerID, models.AuditNoteUnshared, "note", noteID.Hex(), client, map[string]string{"collaboratorId": collaboratorID.Hex()})
+    }
     return nil
 }
 
+// RemoveUserFromNotes takes a user off every note shared with them
+func (s *NoteService) RemoveUserFromNotes(userID primitive.ObjectID) error {
+    ctx := context.Background()
+    _, err := config.DB.Collection("notes").UpdateMany(ctx, bson.M{"collaborators": userID}, bson.M{
+        "$pull":  bson.M{"collaborators": userID},
+        "$unset": bson.M{"roles." + userID.Hex(): ""},
+    })
+    return err
+}
+
+// PurgeUserNotes permanently deletes every note the user owns, with their versions,
+// comments, mentions and activity. Links from other notes to them become unresolved.
+func (s *NoteService) PurgeUserNotes(userID primitive.ObjectID) (int64, error) {
+    ctx := context.Background()
+
+    cursor, err := config.DB.Collection("notes").Find(ctx, bson.M{"userId": userID},
+        options.Find().SetProjection(bson.M{"_id": 1}))
+    if err != nil {
+        return 0, err
+    }
+    var notes []models.Note
+    if err := cursor.All(ctx, &notes); err != nil {
+        return 0, err
+    }
+    if len(notes) == 0 {
+        return 0, nil
+    }
+    var noteIDs []primitive.ObjectID
+    for _, note := range notes {
+        noteIDs = append(noteIDs, note.ID)
+    }
+
+    // The notes go last, so a failure part way can be retried
+    for _, collection := range []string{"note_versions", "comments", "note_mentions", "note_activity"} {
+        if _, err := config.DB.Collection(collection).DeleteMany(ctx, bson.M{"noteId": bson.M{"$in": noteIDs}}); err != nil {
+            return 0, err
+        }
+    }
+    if _, err := config.DB.Collection("note_links").DeleteMany(ctx, bson.M{"sourceId": bson.M{"$in": noteIDs}}); err != nil {
+        return 0, err
+    }
+    _, err = config.DB.Collection("note_links").UpdateMany(ctx, bson.M{"targetId": bson.M{"$in": noteIDs}}, bson.M{
+        "$set": bson.M{"targetId": nil},
+    })
+    if err != nil {
+        return 0, err
+    }
+    result, err := config.DB.Collection("notes").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": noteIDs}})
+    if err != nil {
+        return 0, err
+    }
+    return result.DeletedCount, nil
+}
+
 // ListCollaborators returns the list of collaborators for a note
 func (s *NoteService) ListCollaborators(noteID, userID primitive.ObjectID) ([]models.UserProfileDto, error) {
     ctx := context.Background()
@@ -431,15 +736,228 @@ func (s *NoteService) ListCollaborators(noteID, userID primitive.ObjectID) ([]mo
     }
     var result []models.UserProfileDto
     for _, u := range users {
-        result = append(result, models.UserProfileDto{
-            ID: u.ID.Hex(),
-            Username: u.Username,
-            Email: u.Email,
-        })
+        profile := publicProfile(u)
+        profile.Role = CollaboratorRole(note, u.ID)
+        result = append(result, profile)
     }
     return result, nil
 }
 
+// GetUpcomingNotes returns visible notes with a reminder or due date within the given window
+func (s *NoteService) GetUpcomingNotes(userID primitive.ObjectID, within time.Duration) ([]models.NoteResponse, error) {
+    now := time.Now()
+    window := bson.M{"$gte": now, "$lte": now.Add(within)}
+    return s.findScheduledNotes(bson.M{
+        "trashed": false,
+        "$and": []bson.M{
+            {"$or": []bson.M{{"userId": userID}, {"collaborators": userID}}},
+            {"$or": []bson.M{{"reminderAt": window}, {"dueAt": window}}},
+        },
+    })
+}
+
+// GetOverdueNotes returns visible notes whose due date has passed
+func (s *NoteService) GetOverdueNotes(userID primitive.ObjectID) ([]models.NoteResponse, error) {
+    return s.findScheduledNotes(bson.M{
+        "trashed": false,
+        "dueAt": bson.M{"$lt": time.Now()},
+        "$or": []bson.M{
+            {"userId": userID},
+            {"collaborators": userID},
+        },
+    })
+}
+
+func (s *NoteService) findScheduledNotes(filter bson.M) ([]models.NoteResponse, error) {
+    ctx := context.Background()
+
+    opts := options.Find().SetSort(bson.D{{Key: "dueAt", Value: 1}, {Key: "reminderAt", Value: 1}})
+    cursor, err := config.DB.Collection("notes").Find(ctx, filter, opts)
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+
+    var notes []models.Note
+    if err = cursor.All(ctx, &notes); err != nil {
+        return nil, err
+    }
+
+    responses := []models.NoteResponse{}
+    for _, note := range notes {
+        responses = append(responses, s.noteToResponse(note))
+    }
+
+    return responses, nil
+}
+
+func (s *NoteService) scheduleReminder(noteID primitive.ObjectID, at *time.Time) {
+    if err := s.reminderService.Schedule(noteID, at); err != nil {
+        log.Println("Failed to schedule reminder for note", noteID.Hex(), err)
+    }
+}
+
+// CollaboratorRole returns the user's role on a note, or "" if they have no access
+func CollaboratorRole(note models.Note, userID primitive.ObjectID) string {
+    if note.UserID == userID {
+        return models.RoleOwner
+    }
+    if !containsID(note.Collaborators, userID) {
+        return ""
+    }
+    if role, ok := note.Roles[userID.Hex()]; ok {
+        return role
+    }
+    return models.RoleEditor
+}
+
+// editableFilter matches the note if the user owns it or collaborates on it with edit rights
+func editableFilter(noteID, userID primitive.ObjectID) bson.M {
+    return bson.M{
+        "_id": noteID,
+        "$or": []bson.M{
+            {"userId": userID},
+            {"collaborators": userID, "roles." + userID.Hex(): bson.M{"$exists": false}},
+            {"collaborators": userID, "roles." + userID.Hex(): models.RoleEditor},
+        },
+    }
+}
+
+func containsID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+    for _, candidate := range ids {
+        if candidate == id {
+            return true
+        }
+    }
+    return false
+}
+
+func sameTime(a, b *time.Time) bool {
+    if a == nil || b == nil {
+        return a == b
+    }
+    return a.Equal(*b)
+}
+
+// emitWebhook queues the event for the webhooks of everyone with access to the note.
+// Like link indexing it never fails the write that triggered it.
+func (s *NoteService) emitWebhook(event string, noteID primitive.ObjectID) {
+    ctx := context.Background()
+
+    var note models.Note
+    if err := config.DB.Collection("notes").FindOne(ctx, bson.M{"_id": noteID}).Decode(&note); err != nil {
+        log.Println("Failed to load note for webhook", noteID.Hex(), err)
+        return
+    }
+
+    recipients := append([]primitive.ObjectID{note.UserID}, note.Collaborators...)
+    if err := s.webhookService.Dispatch(recipients, event, s.noteToResponse(note)); err != nil {
+        log.Println("Failed to queue webhook for note", noteID.Hex(), err)
+    }
+}
+
+// processMentions brings the note's mention index up to date with its content and notifies
+// users who are newly mentioned. If the owner enabled it, mentioned users without access
+// are added as viewers, anyone else without access is ignored so the note isn't revealed
+// to them. Like link indexing it never fails the write that triggered it.
+func (s *NoteService) processMentions(noteID, actorID primitive.ObjectID, client models.ClientInfo) {
+    ctx := context.Background()
+
+    var note models.Note
+    if err := config.DB.Collection("notes").FindOne(ctx, bson.M{"_id": noteID}).Decode(&note); err != nil {
+        log.Println("Failed to load note for mentions", noteID.Hex(), err)
+        return
+    }
+
+    users, err := s.mentionService.ResolveMentions(note.Content)
+    if err != nil {
+        log.Println("Failed to resolve mentions in note", noteID.Hex(), err)
+        return
+    }
+
+    var mentioned []primitive.ObjectID
+    for _, user := range users {
+        if user.ID == actorID {
+            continue
+        }
+        if CollaboratorRole(note, user.ID) == "" {
+            if !note.ShareOnMention || !s.grantViewAccess(note, user.ID, actorID, client) {
+                continue
+            }
+        }
+        mentioned = append(mentioned, user.ID)
+    }
+
+    added, err := s.mentionService.Sync(noteID, actorID, mentioned)
+    if err != nil {
+        log.Println("Failed to index mentions in note", noteID.Hex(), err)
+        return
+    }
+    s.notificationService.NotifyNoteEvent(added, models.NotificationMentioned, note, actorID)
+}
+
+// grantViewAccess adds a mentioned user to the note as a viewer
+func (s *NoteService) grantViewAccess(note models.Note, userID, actorID primitive.ObjectID, client models.ClientInfo) bool {
+    ctx := context.Background()
+
+    if err := requireVerified(RestrictShare, note.UserID, userID); err != nil {
+        return false
+    }
+
+    result, err := config.DB.Collection("notes").UpdateOne(ctx, bson.M{
+        "_id": note.ID,
+        "userId": bson.M{"$ne": userID},
+        "collaborators": bson.M{"$ne": userID},
+    }, bson.M{
+        "$addToSet": bson.M{"collaborators": userID},
+        "$set": bson.M{"roles." + userID.Hex(): models.RoleViewer},
+    })
+    if err != nil || result.ModifiedCount == 0 {
+        if err != nil {
+            log.Println("Failed to share note", note.ID.Hex(), "with mentioned user", err)
+        }
+        return false
+    }
+
+    s.emitWebhook(models.WebhookNoteShared, note.ID)
+    s.activityService.Record(models.NoteActivity{NoteID: note.ID, ActorID: actorID, Type: models.ActivityShared, TargetUserID: &userID})
+    s.auditService.Record(&actorID, models.AuditNoteShared, "note", note.ID.Hex(), client, map[string]string{
+        "collaboratorId": userID.Hex(),
+        "role":           models.RoleViewer,
+        "reason":         "mention",
+    })
+    return true
+}
+
+// renameLinks rewrites [[links]] to the note in other notes after the note was renamed
+// from oldTitle. The rewritten notes show the edit in their timeline and send webhooks.
+func (s *NoteService) renameLinks(noteID primitive.ObjectID, oldTitle string, actorID primitive.ObjectID) {
+    ctx := context.Background()
+
+    var note models.Note
+    if err := config.DB.Collection("notes").FindOne(ctx, bson.M{"_id": noteID}).Decode(&note); err != nil {
+        log.Println("Failed to load note for link rewrite", noteID.Hex(), err)
+        return
+    }
+    rewrites, err := s.linkService.RenameTarget(note, oldTitle)
+    if err != nil {
+        log.Println("Failed to rewrite links to note", noteID.Hex(), err)
+    }
+    for _, rewrite := range rewrites {
+        after := models.NoteRequest{Title: rewrite.before.Title, Content: rewrite.content, Tags: rewrite.before.Tags}
+        s.activityService.RecordEdit(rewrite.before, after, actorID, &rewrite.versionID, false)
+        s.emitWebhook(models.WebhookNoteUpdated, rewrite.before.ID)
+    }
+}
+
+// indexLinks refreshes the link index for a note. Link indexing is best effort
+// and never fails the write that triggered it.
+func (s *NoteService) indexLinks(noteID primitive.ObjectID) {
+    if err := s.linkService.IndexNote(noteID); err != nil {
+        log.Println("Failed to index links for note", noteID.Hex(), err)
+    }
+}
+
 func (s *NoteService) noteToResponse(note models.Note) models.NoteResponse {
     return models.NoteResponse{
         ID:              note.ID.Hex(),
@@ -449,6 +967,9 @@ func (s *NoteService) noteToResponse(note models.Note) models.NoteResponse {
         Trashed:         note.Trashed,
         AutoSaveEnabled: note.AutoSaveEnabled,
         Tags:            note.Tags,
+        ReminderAt:      note.ReminderAt,
+        DueAt:           note.DueAt,
+        ShareOnMention:  note.ShareOnMention,
         CreatedAt:       note.CreatedAt,
         UpdatedAt:       note.UpdatedAt,
         UserID:          note.UserID.Hex(), // Add this line
@@ -0,0 +1,239 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "time"
+    "notes-app/config"
+    "notes-app/models"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type NotificationService struct{}
+
+func NewNotificationService() *NotificationService {
+    return &NotificationService{}
+}
+
+func (s *NotificationService) EnsureIndexes() error {
+    ctx := context.Background()
+    _, err := config.DB.Collection("notifications").Indexes().CreateOne(ctx, mongo.IndexModel{
+        Keys: bson.D{{Key: "userId", Value: 1}, {Key: "read", Value: 1}, {Key: "createdAt", Value: -1}},
+    })
+    if err != nil {
+        return err
+    }
+    _, err = config.DB.Collection("notification_preferences").Indexes().CreateOne(ctx, mongo.IndexModel{
+        Keys:    bson.M{"userId": 1},
+        Options: options.Index().SetUnique(true),
+    })
+    return err
+}
+
+// Notify stores a notification for a user unless they muted its type
+func (s *NotificationService) Notify(notification models.Notification) error {
+    ctx := context.Background()
+
+    prefs, err := s.GetPreferences(notification.UserID)
+    if err != nil {
+        return err
+    }
+    for _, muted := range prefs.Muted {
+        if muted == notification.Type {
+            return nil
+        }
+    }
+
+    notification.ID = primitive.NewObjectID()
+    notification.Read = false
+    notification.CreatedAt = time.Now()
+
+    _, err = config.DB.Collection("notifications").InsertOne(ctx, notification)
+    return err
+}
+
+// NotifyNoteEvent tells each recipient, except the actor, that the actor did something to a note.
+// Delivery is best effort, failures are logged and don't affect the caller.
+func (s *NotificationService) NotifyNoteEvent(recipients []primitive.ObjectID, notificationType string, note models.Note, actorID primitive.ObjectID) {
+    ctx := context.Background()
+
+    actorName := "Someone"
+    var actor models.User
+    if err := config.DB.Collection("users").FindOne(ctx, bson.M{"_id": actorID}).Decode(&actor); err == nil {
+        actorName = actor.Username
+    }
+
+    var message string
+    switch notificationType {
+    case models.NotificationNoteShared:
+        message = fmt.Sprintf("%s shared \"%s\" with you", actorName, note.Title)
+    case models.NotificationNoteUnshared:
+        message = fmt.Sprintf("%s removed you from \"%s\"", actorName, note.Title)
+    case models.NotificationNoteEdited:
+        message = fmt.Sprintf("%s edited \"%s\"", actorName, note.Title)
+    case models.NotificationVersionRestored:
+        message = fmt.Sprintf("%s restored an earlier version of \"%s\"", actorName, note.Title)
+    case models.NotificationComment:
+        message = fmt.Sprintf("%s commented on \"%s\"", actorName, note.Title)
+    case models.NotificationMentioned:
+        message = fmt.Sprintf("%s mentioned you in \"%s\"", actorName, note.Title)
+    default:
+        message = fmt.Sprintf("%s updated \"%s\"", actorName, note.Title)
+    }
+
+    for _, recipient := range recipients {
+        if recipient == actorID {
+            continue
+        }
+        err := s.Notify(models.Notification{
+            UserID:  recipient,
+            Type:    notificationType,
+            NoteID:  &note.ID,
+            ActorID: &actorID,
+            Message: message,
+        })
+        if err != nil {
+            log.Println("Failed to notify user", recipient.Hex(), err)
+        }
+    }
+}
+
+// GetNotifications returns a page of the user's notifications, newest first
+func (s *NotificationService) GetNotifications(userID primitive.ObjectID, unreadOnly bool, page, limit int) (models.NotificationListResponse, error) {
+    ctx := context.Background()
+
+    filter := bson.M{"userId": userID}
+    if unreadOnly {
+        filter["read"] = false
+    }
+    opts := options.Find().
+        SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+        SetSkip(int64((page - 1) * limit)).
+        SetLimit(int64(limit))
+
+    cursor, err := config.DB.Collection("notifications").Find(ctx, filter, opts)
+    if err != nil {
+        return models.NotificationListResponse{}, err
+    }
+    defer cursor.Close(ctx)
+
+    notifications := []models.Notification{}
+    if err = cursor.All(ctx, &notifications); err != nil {
+        return models.NotificationListResponse{}, err
+    }
+
+    unread, err := s.GetUnreadCount(userID)
+    if err != nil {
+        return models.NotificationListResponse{}, err
+    }
+
+    return models.NotificationListResponse{
+        Notifications: notifications,
+        UnreadCount:   unread,
+        Page:          page,
+        Limit:         limit,
+    }, nil
+}
+
+func (s *NotificationService) GetUnreadCount(userID primitive.ObjectID) (int64, error) {
+    ctx := context.Background()
+    return config.DB.Collection("notifications").CountDocuments(ctx, bson.M{"userId": userID, "read": false})
+}
+
+func (s *NotificationService) MarkRead(notificationID, userID primitive.ObjectID) error {
+    ctx := context.Background()
+
+    result, err := config.DB.Collection("notifications").UpdateOne(ctx, bson.M{
+        "_id": notificationID,
+        "userId": userID,
+    }, bson.M{
+        "$set": bson.M{"read": true},
+    })
+
+    if err != nil || result.MatchedCount == 0 {
+        return errors.New("notification not found")
+    }
+
+    return nil
+}
+
+func (s *NotificationService) MarkAllRead(userID primitive.ObjectID) error {
+    ctx := context.Background()
+
+    _, err := config.DB.Collection("notifications").UpdateMany(ctx, bson.M{
+        "userId": userID,
+        "read": false,
+    }, bson.M{
+        "$set": bson.M{"read": true},
+    })
+    return err
+}
+
+// GetPreferences returns the user's preferences, nothing is muted by default
+func (s *NotificationService) GetPreferences(userID primitive.ObjectID) (models.NotificationPreferences, error) {
+    ctx := context.Background()
+
+    var prefs models.NotificationPreferences
+    err := config.DB.Collection("notification_preferences").FindOne(ctx, bson.M{"userId": userID}).Decode(&prefs)
+    if err == mongo.ErrNoDocuments {
+        return models.NotificationPreferences{UserID: userID, Muted: []string{}, DigestFrequency: models.DigestOff}, nil
+    }
+    if err != nil {
+        return models.NotificationPreferences{}, err
+    }
+    if prefs.Muted == nil {
+        prefs.Muted = []string{}
+    }
+    if prefs.DigestFrequency == "" {
+        prefs.DigestFrequency = models.DigestOff
+    }
+    return prefs, nil
+}
+
+func (s *NotificationService) UpdatePreferences(userID primitive.ObjectID, req models.NotificationPreferencesRequest) (models.NotificationPreferences, error) {
+    ctx := context.Background()
+
+    fields := bson.M{"updatedAt": time.Now()}
+    if req.Muted != nil {
+        muted := []string{}
+        for _, notificationType := range req.Muted {
+            if !isNotificationType(notificationType) {
+                return models.NotificationPreferences{}, errors.New("unknown notification type: " + notificationType)
+            }
+            muted = append(muted, notificationType)
+        }
+        fields["muted"] = muted
+    }
+    switch req.DigestFrequency {
+    case "":
+    case models.DigestOff, models.DigestDaily, models.DigestWeekly:
+        fields["digestFrequency"] = req.DigestFrequency
+    default:
+        return models.NotificationPreferences{}, errors.New("digest frequency must be off, daily or weekly")
+    }
+
+    _, err := config.DB.Collection("notification_preferences").UpdateOne(ctx, bson.M{
+        "userId": userID,
+    }, bson.M{
+        "$set": fields,
+    }, options.Update().SetUpsert(true))
+    if err != nil {
+        return models.NotificationPreferences{}, err
+    }
+
+    return s.GetPreferences(userID)
+}
+
+func isNotificationType(notificationType string) bool {
+    for _, known := range models.NotificationTypes {
+        if known == notificationType {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,591 @@
+package services
+
+import (
+    "context"
+    "crypto/rsa"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "net/url"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "notes-app/config"
+    "notes-app/models"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+    oidcLoginTTL       = 10 * time.Minute
+    oidcDiscoveryTTL   = time.Hour
+    oidcKeyRefetchWait = time.Minute
+    // oidcReauthMaxAge is how recent a confirming sign-in at the provider has to be,
+    // and how long it can be used for afterwards
+    oidcReauthMaxAge = 5 * time.Minute
+)
+
+// ErrOIDCDisabled is returned when single sign-on isn't configured
+var ErrOIDCDisabled = errors.New("single sign-on is not configured")
+
+// Sign-in failures whose message can be shown to the user. Anything else may carry
+// details of the provider or the server and is reported as a generic failure.
+var (
+    ErrOIDCLoginExpired     = errors.New("sign-in expired, please try again")
+    ErrOIDCNoEmail          = errors.New("the provider didn't share an email address")
+    ErrOIDCEmailNotVerified = errors.New("the provider hasn't verified your email address")
+    ErrOIDCDomainNotAllowed = errors.New("this email domain isn't allowed to sign in")
+    ErrOIDCAccountExists    = errors.New("an account with this email already exists, sign in with your password")
+    ErrOIDCAccountNotLinked = errors.New("no account is linked to this sign-in, ask an admin for access")
+    ErrOIDCReauthMismatch   = errors.New("confirm with the account you're signed in with")
+    ErrOIDCReauthNotFresh   = errors.New("the provider didn't ask you to sign in again, please try again")
+)
+
+// ErrRecentLoginRequired is returned when an account without a password has to confirm
+// a sensitive change by signing in at the provider again, see OIDCService.StartReauth
+var ErrRecentLoginRequired = errors.New("confirm by signing in again at /api/auth/oidc/reauth")
+
+// usernameUnsafe matches characters that can't be part of a username, see mentionPattern
+var usernameUnsafe = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+type oidcDiscovery struct {
+    Issuer                string `json:"issuer"`
+    AuthorizationEndpoint string `json:"authorization_endpoint"`
+    TokenEndpoint         string `json:"token_endpoint"`
+    JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcLogin is what is kept about a login between the redirect to the provider and the callback.
+// UserID is set when a signed in user confirms their identity rather than signing in.
+type oidcLogin struct {
+    Nonce    string `json:"nonce"`
+    Verifier string `json:"verifier"`
+    ReturnTo string `json:"returnTo"`
+    UserID   string `json:"userId,omitempty"`
+}
+
+// OIDCService signs users in through an OpenID Connect provider with the authorization
+// code flow and PKCE. It is enabled by setting OIDC_ISSUER and OIDC_CLIENT_ID.
+// The provider's discovery document and signing keys are fetched on first use and cached.
+type OIDCService struct {
+    authService    *AuthService
+    auditService   *AuditService
+    httpClient     *http.Client
+    issuer         string
+    clientID       string
+    clientSecret   string
+    redirectURL    string
+    scopes         string
+    autoProvision  bool
+    linkByEmail    bool
+    allowedDomains []string
+
+    mu           sync.Mutex
+    discovery    *oidcDiscovery
+    discoveredAt time.Time
+    keys         map[string]*rsa.PublicKey
+    keysFetched  time.Time
+}
+
+func NewOIDCService(authService *AuthService, auditService *AuditService, httpClient *http.Client) *OIDCService {
+    var domains []string
+    for _, domain := range strings.Split(config.GetEnv("OIDC_ALLOWED_DOMAINS", ""), ",") {
+        if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+            domains = append(domains, domain)
+        }
+    }
+    return &OIDCService{
+        authService:    authService,
+        auditService:   auditService,
+        httpClient:     httpClient,
+        issuer:         strings.TrimRight(config.GetEnv("OIDC_ISSUER", ""), "/"),
+        clientID:       config.GetEnv("OIDC_CLIENT_ID", ""),
+        clientSecret:   config.GetEnv("OIDC_CLIENT_SECRET", ""),
+        redirectURL:    config.GetEnv("OIDC_REDIRECT_URL", "http://localhost:8080/api/auth/oidc/callback"),
+        scopes:         config.GetEnv("OIDC_SCOPES", "openid email profile"),
+        autoProvision:  config.GetEnvBool("OIDC_AUTO_PROVISION", true),
+        linkByEmail:    config.GetEnvBool("OIDC_LINK_BY_EMAIL", true),
+        allowedDomains: domains,
+    }
+}
+
+func (s *OIDCService) EnsureIndexes() error {
+    ctx := context.Background()
+    _, err := config.DB.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+        Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+        Options: options.Index().
+            SetUnique(true).
+            SetPartialFilterExpression(bson.M{"identities": bson.M{"$exists": true}}),
+    })
+    return err
+}
+
+func (s *OIDCService) Enabled() bool {
+    return s.issuer != "" && s.clientID != ""
+}
+
+// StartLogin returns the provider URL to send the browser to and the state that
+// the callback has to come back with. returnTo is a path in the app to end up at.
+func (s *OIDCService) StartLogin(returnTo string) (string, string, error) {
+    return s.start(returnTo, oidcLogin{}, nil)
+}
+
+// StartReauth is StartLogin for a signed in user confirming a sensitive change, like
+// deleting an account without a password. The provider is asked for a fresh sign-in,
+// and once the callback checks it, RecentlyReauthenticated allows one change.
+func (s *OIDCService) StartReauth(userID primitive.ObjectID, returnTo string) (string, string, error) {
+    return s.start(returnTo, oidcLogin{UserID: userID.Hex()}, url.Values{
+        "prompt":  {"login"},
+        "max_age": {"0"},
+    })
+}
+
+func (s *OIDCService) start(returnTo string, login oidcLogin, extra url.Values) (string, string, error) {
+    ctx := context.Background()
+
+    if !s.Enabled() {
+        return "", "", ErrOIDCDisabled
+    }
+    discovery, err := s.getDiscovery()
+    if err != nil {
+        return "", "", err
+    }
+
+    // Only paths, so the login can't be used to redirect somewhere else
+    if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.HasPrefix(returnTo, "/\\") {
+        returnTo = "/"
+    }
+    state := generateSessionID()
+    login.Nonce = generateSessionID()
+    login.Verifier = generateSessionID()
+    login.ReturnTo = returnTo
+    data, err := json.Marshal(login)
+    if err != nil {
+        return "", "", err
+    }
+    if err := config.RedisClient.Set(ctx, "oidc_login:"+hashToken(state), data, oidcLoginTTL).Err(); err != nil {
+        return "", "", err
+    }
+
+    challenge := sha256.Sum256([]byte(login.Verifier))
+    query := url.Values{
+        "response_type":         {"code"},
+        "client_id":             {s.clientID},
+        "redirect_uri":          {s.redirectURL},
+        "scope":                 {s.scopes},
+        "state":                 {state},
+        "nonce":                 {login.Nonce},
+        "code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+        "code_challenge_method": {"S256"},
+    }
+    for key, values := range extra {
+        query[key] = values
+    }
+    separator := "?"
+    if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+        separator = "&"
+    }
+    return discovery.AuthorizationEndpoint + separator + query.Encode(), state, nil
+}
+
+// CompleteLogin exchanges the authorization code, verifies the ID token and starts a
+// session for the linked user, creating or linking the user if needed. It also returns
+// the path given to StartLogin.
+func (s *OIDCService) CompleteLogin(state, code string, client models.ClientInfo) (models.LoginResponse, string, error) {
+    ctx := context.Background()
+
+    if !s.Enabled() {
+        return models.LoginResponse{}, "", ErrOIDCDisabled
+    }
+    data, err := config.RedisClient.GetDel(ctx, "oidc_login:"+hashToken(state)).Bytes()
+    if err != nil {
+        return models.LoginResponse{}, "", ErrOIDCLoginExpired
+    }
+    var login oidcLogin
+    if err := json.Unmarshal(data, &login); err != nil {
+        return models.LoginResponse{}, "", err
+    }
+
+    idToken, err := s.exchangeCode(code, login.Verifier)
+    if err != nil {
+        return models.LoginResponse{}, "", err
+    }
+    claims, err := parseIDToken(idToken, s.key)
+    if err != nil {
+        return models.LoginResponse{}, "", err
+    }
+    if err := s.validateClaims(claims, login.Nonce, time.Now()); err != nil {
+        return models.LoginResponse{}, "", err
+    }
+    if login.UserID != "" {
+        if err := s.completeReauth(login.UserID, claims, time.Now(), client); err != nil {
+            return models.LoginResponse{}, "", err
+        }
+        return models.LoginResponse{Reauthenticated: true}, login.ReturnTo, nil
+    }
+
+    user, err := s.findOrCreateUser(claims, client)
+    if err != nil {
+        return models.LoginResponse{}, "", err
+    }
+    // The provider only stands in for the password. Accounts with 2FA still need a code,
+    // the login is completed through /login/2fa like a password login.
+    if user.TOTPEnabled {
+        if err := checkAccountUsable(user); err != nil {
+            return models.LoginResponse{}, "", err
+        }
+        pendingToken, err := s.authService.twoFactorService.CreatePending(user.ID, false)
+        if err != nil {
+            return models.LoginResponse{}, "", err
+        }
+        return models.LoginResponse{TwoFactorRequired: true, PendingToken: pendingToken}, login.ReturnTo, nil
+    }
+    response, err := s.authService.startSession(user, false, client, map[string]string{"method": "oidc", "provider": s.issuer})
+    if err != nil {
+        return models.LoginResponse{}, "", err
+    }
+    return response, login.ReturnTo, nil
+}
+
+// validateClaims checks the ID token claims against the issuer exactly as the provider's
+// discovery document gives it. OIDC_ISSUER is compared without a trailing slash, but
+// tokens of providers like Auth0 carry the issuer with one.
+func (s *OIDCService) validateClaims(claims IDTokenClaims, nonce string, now time.Time) error {
+    discovery, err := s.getDiscovery()
+    if err != nil {
+        return err
+    }
+    return claims.validate(discovery.Issuer, s.clientID, nonce, now)
+}
+
+// completeReauth checks the sign-in was fresh and for the user's own linked identity,
+// then records it for RecentlyReauthenticated
+func (s *OIDCService) completeReauth(userID string, claims IDTokenClaims, now time.Time, client models.ClientInfo) error {
+    ctx := context.Background()
+
+    objID, err := primitive.ObjectIDFromHex(userID)
+    if err != nil {
+        return err
+    }
+    if err := claims.freshSignIn(now, oidcReauthMaxAge); err != nil {
+        return ErrOIDCReauthNotFresh
+    }
+    count, err := config.DB.Collection("users").CountDocuments(ctx, bson.M{
+        "_id": objID,
+        "identities": bson.M{"$elemMatch": bson.M{"provider": s.issuer, "subject": claims.Subject}},
+    })
+    if err != nil {
+        return err
+    }
+    if count == 0 {
+        return ErrOIDCReauthMismatch
+    }
+
+    if err := config.RedisClient.Set(ctx, "oidc_reauth:"+userID, 1, oidcReauthMaxAge).Err(); err != nil {
+        return err
+    }
+    s.auditService.Record(&objID, models.AuditLogin, "user", userID, client, map[string]string{"method": "oidc", "provider": s.issuer, "purpose": "reauth"})
+    return nil
+}
+
+// RecentlyReauthenticated reports whether the user confirmed their identity at the provider
+// in the last few minutes. Each confirmation is good for one change.
+func RecentlyReauthenticated(userID primitive.ObjectID) bool {
+    ctx := context.Background()
+    deleted, err := config.RedisClient.Del(ctx, "oidc_reauth:"+userID.Hex()).Result()
+    return err == nil && deleted == 1
+}
+
+// findOrCreateUser returns the user linked to the identity. An unlinked identity is linked
+// to the user with the same email, see canLinkByEmail, otherwise a user is created.
+// Either needs an email the provider verified.
+func (s *OIDCService) findOrCreateUser(claims IDTokenClaims, client models.ClientInfo) (models.User, error) {
+    ctx := context.Background()
+
+    var user models.User
+    err := config.DB.Collection("users").FindOne(ctx, bson.M{
+        "identities": bson.M{"$elemMatch": bson.M{"provider": s.issuer, "subject": claims.Subject}},
+    }).Decode(&user)
+    if err == nil {
+        return user, nil
+    }
+    if err != mongo.ErrNoDocuments {
+        return models.User{}, err
+    }
+
+    email, err := s.verifiedEmail(claims)
+    if err != nil {
+        return models.User{}, err
+    }
+    identity := models.ExternalIdentity{
+        Provider: s.issuer,
+        Subject:  claims.Subject,
+        Email:    email,
+        LinkedAt: time.Now(),
+    }
+
+    err = config.DB.Collection("users").FindOne(ctx, bson.M{"email": email}, options.FindOne().SetCollation(caseInsensitive)).Decode(&user)
+    if err == nil {
+        if !s.canLinkByEmail(user) {
+            return models.User{}, ErrOIDCAccountExists
+        }
+        _, err = config.DB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+            "$push": bson.M{"identities": identity},
+        })
+        if err != nil {
+            return models.User{}, err
+        }
+        s.auditService.Record(&user.ID, models.AuditIdentityLinked, "user", user.ID.Hex(), client, map[string]string{
+            "provider": s.issuer,
+            "reason":   "email",
+        })
+        return user, nil
+    }
+    if err != mongo.ErrNoDocuments {
+        return models.User{}, err
+    }
+
+    if !s.autoProvision {
+        return models.User{}, ErrOIDCAccountNotLinked
+    }
+    username, err := s.availableUsername(claims)
+    if err != nil {
+        return models.User{}, err
+    }
+    name := claims.Name
+    if name == "" {
+        name = username
+    }
+    user = models.User{
+        ID:            primitive.NewObjectID(),
+        Name:          name,
+        Email:         email,
+        Username:      username,
+        UsernameLower: strings.ToLower(username),
+        EmailVerified: true,
+        Identities:    []models.ExternalIdentity{identity},
+    }
+    now := time.Now()
+    user.EmailVerifiedAt = &now
+    if _, err := config.DB.Collection("users").InsertOne(ctx, user); err != nil {
+        return models.User{}, err
+    }
+    s.auditService.Record(&user.ID, models.AuditIdentityLinked, "user", user.ID.Hex(), client, map[string]string{
+        "provider": s.issuer,
+        "reason":   "provisioned",
+    })
+    return user, nil
+}
+
+// verifiedEmail returns the normalized email of the claims. Only an email the provider
+// verified is used, otherwise anyone could sign up at the provider with someone else's
+// address to get past the allowed domains or into their account.
+func (s *OIDCService) verifiedEmail(claims IDTokenClaims) (string, error) {
+    email := normalizeEmail(claims.Email)
+    if email == "" {
+        return "", ErrOIDCNoEmail
+    }
+    if !bool(claims.EmailVerified) {
+        return "", ErrOIDCEmailNotVerified
+    }
+    if !s.domainAllowed(email) {
+        return "", ErrOIDCDomainNotAllowed
+    }
+    return email, nil
+}
+
+// canLinkByEmail reports whether an identity with the user's email may be linked to them.
+// The user's own email has to be verified too, or someone could register the address
+// first with a password of their own and keep access once the real owner signs in.
+// Accounts with 2FA are never linked by email, an identity at the provider shouldn't be
+// tied to them without the owner signing in with their second factor.
+func (s *OIDCService) canLinkByEmail(user models.User) bool {
+    return s.linkByEmail && user.EmailVerified && !user.TOTPEnabled
+}
+
+// availableUsername derives a free username from the preferred username or the email
+func (s *OIDCService) availableUsername(claims IDTokenClaims) (string, error) {
+    ctx := context.Background()
+
+    base := claims.PreferredUsername
+    if base == "" || strings.Contains(base, "@") {
+        base = strings.SplitN(claims.Email, "@", 2)[0]
+    }
+    base = strings.Trim(usernameUnsafe.ReplaceAllString(base, ""), ".-")
+    if base == "" {
+        base = "user"
+    }
+
+    for i := 1; i <= 20; i++ {
+        candidate := base
+        if i > 1 {
+            candidate = base + strconv.Itoa(i)
+        }
+        count, err := config.DB.Collection("users").CountDocuments(ctx, UsernameFilter(candidate))
+        if err != nil {
+            return "", err
+        }
+        if count == 0 {
+            return candidate, nil
+        }
+    }
+    return base + "-" + generateSessionID()[:6], nil
+}
+
+func (s *OIDCService) domainAllowed(email string) bool {
+    if len(s.allowedDomains) == 0 {
+        return true
+    }
+    domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+    for _, allowed := range s.allowedDomains {
+        if domain == allowed {
+            return true
+        }
+    }
+    return false
+}
+
+// exchangeCode trades the authorization code for tokens and returns the ID token
+func (s *OIDCService) exchangeCode(code, verifier string) (string, error) {
+    discovery, err := s.getDiscovery()
+    if err != nil {
+        return "", err
+    }
+
+    form := url.Values{
+        "grant_type":    {"authorization_code"},
+        "code":          {code},
+        "redirect_uri":  {s.redirectURL},
+        "client_id":     {s.clientID},
+        "code_verifier": {verifier},
+    }
+    req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+    if err != nil {
+        return "", err
+    }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.Header.Set("Accept", "application/json")
+    if s.clientSecret != "" {
+        req.SetBasicAuth(url.QueryEscape(s.clientID), url.QueryEscape(s.clientSecret))
+    }
+
+    resp, err := s.httpClient.Do(req)
+    if err != nil {
+        return "", err
+    }
+    defer resp.Body.Close()
+
+    var body struct {
+        IDToken          string `json:"id_token"`
+        Error            string `json:"error"`
+        ErrorDescription string `json:"error_description"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+        return "", fmt.Errorf("invalid token response: %w", err)
+    }
+    if resp.StatusCode != http.StatusOK || body.Error != "" {
+        return "", errors.New(strings.TrimSpace("provider rejected the sign-in: " + body.Error + " " + body.ErrorDescription))
+    }
+    if body.IDToken == "" {
+        return "", errors.New("provider didn't return an ID token")
+    }
+    return body.IDToken, nil
+}
+
+func (s *OIDCService) getDiscovery() (*oidcDiscovery, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.discovery != nil && time.Since(s.discoveredAt) < oidcDiscoveryTTL {
+        return s.discovery, nil
+    }
+    var discovery oidcDiscovery
+    if err := s.getJSON(s.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+        return nil, err
+    }
+    if strings.TrimRight(discovery.Issuer, "/") != s.issuer {
+        return nil, errors.New("provider issuer doesn't match OIDC_ISSUER")
+    }
+    if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+        return nil, errors.New("provider discovery document is incomplete")
+    }
+    s.discovery = &discovery
+    s.discoveredAt = time.Now()
+    return s.discovery, nil
+}
+
+// key returns the provider's signing key with the given ID. Unknown IDs refetch
+// the keys, at most once a minute, so rotated keys are picked up.
+func (s *OIDCService) key(kid string) (*rsa.PublicKey, error) {
+    discovery, err := s.getDiscovery()
+    if err != nil {
+        return nil, err
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if key := s.lookupKey(kid); key != nil {
+        return key, nil
+    }
+    if time.Since(s.keysFetched) < oidcKeyRefetchWait {
+        return nil, errors.New("unknown ID token signing key")
+    }
+
+    var jwks struct {
+        Keys []jsonWebKey `json:"keys"`
+    }
+    if err := s.getJSON(discovery.JWKSURI, &jwks); err != nil {
+        return nil, err
+    }
+    keys := map[string]*rsa.PublicKey{}
+    for _, jwk := range jwks.Keys {
+        if jwk.KeyType != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+            continue
+        }
+        key, err := jwk.publicKey()
+        if err != nil {
+            log.Println("Skipping invalid OIDC signing key", jwk.KeyID, err)
+            continue
+        }
+        keys[jwk.KeyID] = key
+    }
+    s.keys = keys
+    s.keysFetched = time.Now()
+
+    if key := s.lookupKey(kid); key != nil {
+        return key, nil
+    }
+    return nil, errors.New("unknown ID token signing key")
+}
+
+// lookupKey finds a cached key. Tokens without a key ID match if there is only one key.
+// Callers hold mu.
+func (s *OIDCService) lookupKey(kid string) *rsa.PublicKey {
+    if kid == "" && len(s.keys) == 1 {
+        for _, key := range s.keys {
+            return key
+        }
+    }
+    return s.keys[kid]
+}
+
+func (s *OIDCService) getJSON(address string, v interface{}) error {
+    resp, err := s.httpClient.Get(address)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("fetching %s: status %d", address, resp.StatusCode)
+    }
+    return json.NewDecoder(resp.Body).Decode(v)
+}
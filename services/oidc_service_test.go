@@ -0,0 +1,271 @@
+package services
+
+import (
+    "crypto"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "math/big"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+    "notes-app/models"
+)
+
+// testProvider is an OpenID Connect provider serving discovery, JWKS and a token endpoint
+// that accepts one authorization code
+type testProvider struct {
+    server  *httptest.Server
+    key     *rsa.PrivateKey
+    keyID   string
+    code    string
+    claims  map[string]interface{}
+    issuer  string
+    secret  string
+    idToken string
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+    t.Helper()
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+    p := &testProvider{key: key, keyID: "key-1", code: "good-code", secret: "client-secret"}
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+        json.NewEncoder(w).Encode(map[string]string{
+            "issuer":                 p.issuer,
+            "authorization_endpoint": p.server.URL + "/authorize",
+            "token_endpoint":         p.server.URL + "/token",
+            "jwks_uri":               p.server.URL + "/jwks",
+        })
+    })
+    mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+        json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+            "kid": p.keyID,
+            "kty": "RSA",
+            "use": "sig",
+            "alg": "RS256",
+            "n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+            "e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+        }}})
+    })
+    mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+        user, secret, _ := r.BasicAuth()
+        challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+        if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("code") != p.code ||
+            user != "notes" || secret != p.secret ||
+            base64.RawURLEncoding.EncodeToString(challenge[:]) != testChallenge {
+            w.WriteHeader(http.StatusBadRequest)
+            json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "code is invalid"})
+            return
+        }
+        json.NewEncoder(w).Encode(map[string]string{"id_token": p.idToken, "token_type": "Bearer"})
+    })
+    p.server = httptest.NewServer(mux)
+    t.Cleanup(p.server.Close)
+    p.issuer = p.server.URL
+
+    p.claims = map[string]interface{}{
+        "iss":            p.issuer,
+        "sub":            "user-123",
+        "aud":            "notes",
+        "exp":            time.Now().Add(time.Hour).Unix(),
+        "iat":            time.Now().Unix(),
+        "nonce":          "the-nonce",
+        "email":          "Alice@Example.com",
+        "email_verified": "true",
+    }
+    p.idToken = p.sign(t, key, p.keyID, p.claims)
+    return p
+}
+
+// testChallenge is the PKCE challenge of the verifier "the-verifier"
+var testChallenge = func() string {
+    sum := sha256.Sum256([]byte("the-verifier"))
+    return base64.RawURLEncoding.EncodeToString(sum[:])
+}()
+
+// sign returns an RS256 JWT of the claims
+func (p *testProvider) sign(t *testing.T, key *rsa.PrivateKey, keyID string, claims map[string]interface{}) string {
+    t.Helper()
+    header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": keyID, "typ": "JWT"})
+    payload, _ := json.Marshal(claims)
+    signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+    digest := sha256.Sum256([]byte(signed))
+    signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+    if err != nil {
+        t.Fatal(err)
+    }
+    return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (p *testProvider) service() *OIDCService {
+    return &OIDCService{
+        httpClient:   p.server.Client(),
+        issuer:       p.issuer,
+        clientID:     "notes",
+        clientSecret: p.secret,
+        redirectURL:  "http://localhost:8080/api/auth/oidc/callback",
+    }
+}
+
+func TestOIDCCodeExchange(t *testing.T) {
+    provider := newTestProvider(t)
+    service := provider.service()
+
+    idToken, err := service.exchangeCode("good-code", "the-verifier")
+    if err != nil {
+        t.Fatalf("exchangeCode: %v", err)
+    }
+    claims, err := parseIDToken(idToken, service.key)
+    if err != nil {
+        t.Fatalf("parseIDToken: %v", err)
+    }
+    if err := claims.validate(provider.issuer, "notes", "the-nonce", time.Now()); err != nil {
+        t.Fatalf("validate: %v", err)
+    }
+    if claims.Subject != "user-123" || claims.Email != "Alice@Example.com" || !bool(claims.EmailVerified) {
+        t.Errorf("claims = %+v", claims)
+    }
+    if err := claims.validate(provider.issuer, "notes", "another-nonce", time.Now()); err == nil {
+        t.Error("a token for another login was accepted")
+    }
+}
+
+func TestOIDCCodeExchangeRejected(t *testing.T) {
+    provider := newTestProvider(t)
+    service := provider.service()
+
+    if _, err := service.exchangeCode("stolen-code", "the-verifier"); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+        t.Errorf("unknown code: %v, want the provider's invalid_grant", err)
+    }
+    if _, err := service.exchangeCode("good-code", "wrong-verifier"); err == nil {
+        t.Error("code accepted with the wrong PKCE verifier")
+    }
+}
+
+func TestOIDCRejectsForeignSignature(t *testing.T) {
+    provider := newTestProvider(t)
+    service := provider.service()
+
+    other, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+    forged := provider.sign(t, other, provider.keyID, provider.claims)
+    if _, err := parseIDToken(forged, service.key); err == nil {
+        t.Error("token signed with another key was accepted")
+    }
+
+    unknown := provider.sign(t, other, "key-2", provider.claims)
+    if _, err := parseIDToken(unknown, service.key); err == nil {
+        t.Error("token with an unknown key ID was accepted")
+    }
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+    provider := newTestProvider(t)
+    provider.issuer = "https://login.example.com"
+    service := provider.service()
+    service.issuer = provider.server.URL
+
+    if _, err := service.getDiscovery(); err == nil {
+        t.Error("discovery document of another issuer was accepted")
+    }
+}
+
+func TestIDTokenFreshSignIn(t *testing.T) {
+    now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+    tests := []struct {
+        authTime time.Time
+        fresh    bool
+    }{
+        {now, true},
+        {now.Add(-4 * time.Minute), true},
+        {now.Add(-6 * time.Minute), false},
+        {now.Add(30 * time.Second), true},
+        {now.Add(5 * time.Minute), false},
+        {time.Time{}, false},
+    }
+    for _, test := range tests {
+        claims := IDTokenClaims{}
+        if !test.authTime.IsZero() {
+            claims.AuthTime = test.authTime.Unix()
+        }
+        err := claims.freshSignIn(now, 5*time.Minute)
+        if test.fresh && err != nil {
+            t.Errorf("auth_time %v: %v", test.authTime, err)
+        }
+        if !test.fresh && err == nil {
+            t.Errorf("auth_time %v accepted as fresh", test.authTime)
+        }
+    }
+}
+
+func TestOIDCIssuerWithTrailingSlash(t *testing.T) {
+    provider := newTestProvider(t)
+    provider.issuer = provider.server.URL + "/"
+    provider.claims["iss"] = provider.issuer
+    service := provider.service()
+    service.issuer = provider.server.URL
+
+    claims, err := parseIDToken(provider.sign(t, provider.key, provider.keyID, provider.claims), service.key)
+    if err != nil {
+        t.Fatalf("parseIDToken: %v", err)
+    }
+    if err := service.validateClaims(claims, "the-nonce", time.Now()); err != nil {
+        t.Errorf("issuer with a trailing slash: %v", err)
+    }
+    claims.Issuer = provider.server.URL
+    if err := service.validateClaims(claims, "the-nonce", time.Now()); err == nil {
+        t.Error("token with an issuer differing from discovery was accepted")
+    }
+}
+
+func TestOIDCVerifiedEmail(t *testing.T) {
+    service := &OIDCService{allowedDomains: []string{"example.com"}}
+    tests := []struct {
+        email    string
+        verified flexibleBool
+        want     error
+    }{
+        {"Alice@Example.com", true, nil},
+        {"alice@example.com", false, ErrOIDCEmailNotVerified},
+        {"alice@other.com", false, ErrOIDCEmailNotVerified},
+        {"alice@other.com", true, ErrOIDCDomainNotAllowed},
+        {"", true, ErrOIDCNoEmail},
+    }
+    for _, test := range tests {
+        email, err := service.verifiedEmail(IDTokenClaims{Email: test.email, EmailVerified: test.verified})
+        if err != test.want {
+            t.Errorf("%q verified=%v: %v, want %v", test.email, test.verified, err, test.want)
+        }
+        if err == nil && email != "alice@example.com" {
+            t.Errorf("%q: email = %q", test.email, email)
+        }
+    }
+}
+
+func TestOIDCCanLinkByEmail(t *testing.T) {
+    service := &OIDCService{linkByEmail: true}
+    if !service.canLinkByEmail(models.User{Password: "hash", EmailVerified: true}) {
+        t.Error("user with a verified email wasn't linked")
+    }
+    if service.canLinkByEmail(models.User{Password: "hash"}) {
+        t.Error("user with an unverified email was linked, whoever registered it keeps the password")
+    }
+    if service.canLinkByEmail(models.User{EmailVerified: true, TOTPEnabled: true}) {
+        t.Error("user with 2FA was linked")
+    }
+    service.linkByEmail = false
+    if service.canLinkByEmail(models.User{EmailVerified: true}) {
+        t.Error("linked with OIDC_LINK_BY_EMAIL off")
+    }
+}
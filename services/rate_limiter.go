@@ -0,0 +1,207 @@
+package services
+
+import (
+    "context"
+    "log"
+    "math"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "notes-app/config"
+    "github.com/go-redis/redis/v8"
+)
+
+// RateLimit allows Requests per Period, with bursts of up to Requests.
+// It's a token bucket that holds Requests tokens and refills evenly over Period.
+type RateLimit struct {
+    Name     string
+    Requests int
+    Period   time.Duration
+}
+
+// RateLimitFromEnv reads RATE_LIMIT_<NAME>_REQUESTS and RATE_LIMIT_<NAME>_PERIOD,
+// e.g. RATE_LIMIT_SEARCH_REQUESTS=30 and RATE_LIMIT_SEARCH_PERIOD=1m. A period under
+// a millisecond is ignored in favour of the default, buckets refill per millisecond.
+func RateLimitFromEnv(name string, requests int, period time.Duration) RateLimit {
+    prefix := "RATE_LIMIT_" + strings.ToUpper(name)
+    configured := config.GetEnvDuration(prefix+"_PERIOD", period)
+    if configured.Milliseconds() <= 0 {
+        log.Println("Ignoring "+prefix+"_PERIOD, it must be at least 1ms:", configured)
+        configured = period
+    }
+    return RateLimit{
+        Name:     name,
+        Requests: config.GetEnvInt(prefix+"_REQUESTS", requests),
+        Period:   configured,
+    }
+}
+
+// refillPerMs is how many tokens the bucket regains per millisecond
+func (l RateLimit) refillPerMs() float64 {
+    return float64(l.Requests) / float64(l.Period.Milliseconds())
+}
+
+type RateLimitResult struct {
+    Allowed   bool
+    Remaining int
+    // RetryAfter is the wait until a request is allowed again, 0 if this one was
+    RetryAfter time.Duration
+    // Reset is the wait until the bucket is full again
+    Reset time.Duration
+}
+
+// tokenBucketScript takes a token from the bucket at KEYS[1] if it has one.
+// ARGV is the capacity, the refill per millisecond and the current time in milliseconds.
+// The token count is returned as a string since Redis truncates Lua numbers to integers.
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local refill = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * refill)
+local allowed = 0
+if tokens >= 1 then
+    tokens = tokens - 1
+    allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) / refill) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// RateLimiter keeps token buckets in Redis so limits hold across instances.
+// If Redis fails it falls back to buckets in this process rather than letting
+// every request through or failing them, and leaves Redis alone for redisRetry
+// before trying it again.
+type RateLimiter struct {
+    useRedis   bool
+    redisRetry time.Duration
+    mu         sync.Mutex
+    buckets    map[string]*tokenBucket
+    calls      int
+    // redisDownUntil is set while Redis is skipped after a failure, guarded by mu
+    redisDownUntil time.Time
+}
+
+type tokenBucket struct {
+    tokens  float64
+    updated time.Time
+}
+
+func NewRateLimiter() *RateLimiter {
+    return &RateLimiter{useRedis: true, redisRetry: 30 * time.Second, buckets: map[string]*tokenBucket{}}
+}
+
+// NewMemoryRateLimiter returns a RateLimiter that only uses in-process buckets,
+// for single-instance setups and tests
+func NewMemoryRateLimiter() *RateLimiter {
+    return &RateLimiter{buckets: map[string]*tokenBucket{}}
+}
+
+// Allow takes a token from the bucket of key under the given limit
+func (r *RateLimiter) Allow(key string, limit RateLimit) RateLimitResult {
+    now := time.Now()
+    if r.useRedis && r.redisAvailable(now) {
+        tokens, allowed, err := r.allowRedis(key, limit, now)
+        r.redisResult(now, err)
+        if err == nil {
+            return limit.result(tokens, allowed)
+        }
+    }
+    tokens, allowed := r.allowMemory(key, limit, now)
+    return limit.result(tokens, allowed)
+}
+
+// redisAvailable reports whether Redis should be tried, it's skipped for a while after a failure
+func (r *RateLimiter) redisAvailable(now time.Time) bool {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return !now.Before(r.redisDownUntil)
+}
+
+// redisResult backs off from Redis after a failure. Only the change between
+// Redis and memory is logged, not every request while Redis is down.
+func (r *RateLimiter) redisResult(now time.Time, err error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    down := !r.redisDownUntil.IsZero()
+    if err != nil {
+        if !down {
+            log.Println("Rate limiter falling back to memory:", err)
+        }
+        r.redisDownUntil = now.Add(r.redisRetry)
+        return
+    }
+    if down {
+        log.Println("Rate limiter using Redis again")
+        r.redisDownUntil = time.Time{}
+    }
+}
+
+func (r *RateLimiter) allowRedis(key string, limit RateLimit, now time.Time) (float64, bool, error) {
+    ctx := context.Background()
+    reply, err := tokenBucketScript.Run(ctx, config.RedisClient, []string{"ratelimit:" + key},
+        limit.Requests, limit.refillPerMs(), now.UnixMilli()).Slice()
+    if err != nil {
+        return 0, false, err
+    }
+    allowed, _ := reply[0].(int64)
+    tokensText, _ := reply[1].(string)
+    tokens, err := strconv.ParseFloat(tokensText, 64)
+    if err != nil {
+        return 0, false, err
+    }
+    return tokens, allowed == 1, nil
+}
+
+func (r *RateLimiter) allowMemory(key string, limit RateLimit, now time.Time) (float64, bool) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    capacity := float64(limit.Requests)
+    r.calls++
+    if r.calls%1000 == 0 {
+        r.sweep(now)
+    }
+
+    bucket, ok := r.buckets[key]
+    if !ok {
+        bucket = &tokenBucket{tokens: capacity, updated: now}
+        r.buckets[key] = bucket
+    }
+    elapsed := float64(now.Sub(bucket.updated).Milliseconds())
+    if elapsed > 0 {
+        bucket.tokens = math.Min(capacity, bucket.tokens+elapsed*limit.refillPerMs())
+        bucket.updated = now
+    }
+    if bucket.tokens < 1 {
+        return bucket.tokens, false
+    }
+    bucket.tokens--
+    return bucket.tokens, true
+}
+
+// sweep drops buckets that haven't been used for an hour, they'd be full by now
+// for any reasonable limit. Callers hold mu.
+func (r *RateLimiter) sweep(now time.Time) {
+    for key, bucket := range r.buckets {
+        if now.Sub(bucket.updated) > time.Hour {
+            delete(r.buckets, key)
+        }
+    }
+}
+
+func (l RateLimit) result(tokens float64, allowed bool) RateLimitResult {
+    result := RateLimitResult{
+        Allowed:   allowed,
+        Remaining: int(math.Floor(tokens)),
+        Reset:     time.Duration((float64(l.Requests) - tokens) / l.refillPerMs() * float64(time.Millisecond)),
+    }
+    if !allowed {
+        result.RetryAfter = time.Duration((1 - tokens) / l.refillPerMs() * float64(time.Millisecond))
+    }
+    return result
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+    "errors"
+    "testing"
+    "time"
+)
+
+var errTestRedisDown = errors.New("dial tcp: connection refused")
+
+func TestRateLimitFromEnvIgnoresInvalidPeriod(t *testing.T) {
+    for _, period := range []string{"0s", "-1m", "500us"} {
+        t.Setenv("RATE_LIMIT_TEST_PERIOD", period)
+        if limit := RateLimitFromEnv("test", 10, time.Minute); limit.Period != time.Minute {
+            t.Errorf("period %s gave %v, want the 1m default", period, limit.Period)
+        }
+    }
+
+    t.Setenv("RATE_LIMIT_TEST_PERIOD", "10s")
+    if limit := RateLimitFromEnv("test", 10, time.Minute); limit.Period != 10*time.Second {
+        t.Errorf("period 10s gave %v", limit.Period)
+    }
+}
+
+func TestMemoryRateLimiter(t *testing.T) {
+    limiter := NewMemoryRateLimiter()
+    limit := RateLimit{Name: "test", Requests: 3, Period: time.Minute}
+
+    for i := 0; i < 3; i++ {
+        if result := limiter.Allow("user", limit); !result.Allowed || result.Remaining != 2-i {
+            t.Fatalf("request %d: %+v", i+1, result)
+        }
+    }
+    result := limiter.Allow("user", limit)
+    if result.Allowed || result.RetryAfter <= 0 || result.RetryAfter > 20*time.Second {
+        t.Errorf("request over the limit: %+v, want a refusal with a wait of up to 20s", result)
+    }
+    if result := limiter.Allow("other", limit); !result.Allowed {
+        t.Error("another key was limited")
+    }
+}
+
+func TestRateLimiterBacksOffFromRedis(t *testing.T) {
+    limiter := NewRateLimiter()
+    now := time.Now()
+
+    limiter.redisResult(now, errTestRedisDown)
+    if limiter.redisAvailable(now.Add(29 * time.Second)) {
+        t.Error("Redis tried again right after failing")
+    }
+    if !limiter.redisAvailable(now.Add(30 * time.Second)) {
+        t.Error("Redis not tried again after the back-off")
+    }
+
+    limiter.redisResult(now.Add(30*time.Second), nil)
+    if !limiter.redisDownUntil.IsZero() {
+        t.Error("a working Redis didn't clear the back-off")
+    }
+}
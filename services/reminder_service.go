@@ -0,0 +1,169 @@
+package services
+
+import (
+    "context"
+    "log"
+    "strconv"
+    "time"
+    "notes-app/config"
+    "notes-app/models"
+    "github.com/go-redis/redis/v8"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// reminderQueueKey is a sorted set of note IDs scored by their reminder time
+const reminderQueueKey = "reminders"
+
+// removeDueReminder only removes a queue entry if it wasn't rescheduled into the future meanwhile
+var removeDueReminder = redis.NewScript(`
+local score = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if score and tonumber(score) <= tonumber(ARGV[2]) then
+    return redis.call("ZREM", KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+type ReminderService struct {
+    notificationService *NotificationService
+}
+
+func NewReminderService(notificationService *NotificationService) *ReminderService {
+    return &ReminderService{notificationService: notificationService}
+}
+
+// Schedule queues a reminder for a note, or removes it when at is nil
+func (s *ReminderService) Schedule(noteID primitive.ObjectID, at *time.Time) error {
+    ctx := context.Background()
+
+    if at == nil {
+        return config.RedisClient.ZRem(ctx, reminderQueueKey, noteID.Hex()).Err()
+    }
+    return config.RedisClient.ZAdd(ctx, reminderQueueKey, &redis.Z{
+        Score:  float64(at.Unix()),
+        Member: noteID.Hex(),
+    }).Err()
+}
+
+// Start polls for due reminders until ctx is cancelled. Pending reminders are
+// re-queued from MongoDB first, so nothing is lost if Redis was flushed.
+func (s *ReminderService) Start(ctx context.Context) {
+    if err := s.requeuePending(); err != nil {
+        log.Println("Failed to requeue reminders:", err)
+    }
+
+    ticker := time.NewTicker(config.GetEnvDuration("REMINDER_POLL_INTERVAL", 30*time.Second))
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if err := s.processDue(); err != nil {
+                log.Println("Failed to process reminders:", err)
+            }
+        }
+    }
+}
+
+func (s *ReminderService) processDue() error {
+    ctx := context.Background()
+    now := time.Now()
+    max := strconv.FormatInt(now.Unix(), 10)
+
+    members, err := config.RedisClient.ZRangeByScore(ctx, reminderQueueKey, &redis.ZRangeBy{
+        Min:   "-inf",
+        Max:   max,
+        Count: 100,
+    }).Result()
+    if err != nil {
+        return err
+    }
+
+    for _, member := range members {
+        noteID, err := primitive.ObjectIDFromHex(member)
+        if err == nil {
+            s.fire(noteID, now)
+        }
+        removeDueReminder.Run(ctx, config.RedisClient, []string{reminderQueueKey}, member, max)
+    }
+    return nil
+}
+
+// fire delivers a reminder. Marking the note as reminded is a conditional update,
+// so when several instances see the same due entry only one of them delivers it.
+func (s *ReminderService) fire(noteID primitive.ObjectID, now time.Time) {
+    ctx := context.Background()
+
+    var note models.Note
+    err := config.DB.Collection("notes").FindOneAndUpdate(ctx, bson.M{
+        "_id": noteID,
+        "trashed": false,
+        "reminderAt": bson.M{"$lte": now},
+        "reminderSentAt": nil,
+    }, bson.M{
+        "$set": bson.M{"reminderSentAt": now},
+    }, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&note)
+    if err != nil {
+        return
+    }
+
+    err = s.notificationService.Notify(models.Notification{
+        UserID:  note.UserID,
+        Type:    models.NotificationReminder,
+        NoteID:  &note.ID,
+        Message: "Reminder: " + note.Title,
+    })
+    if err != nil {
+        log.Println("Failed to deliver reminder for note", noteID.Hex(), err)
+        s.release(noteID, now)
+    }
+}
+
+// release gives up the claim fire took on a reminder it failed to deliver and
+// queues it again a minute later
+func (s *ReminderService) release(noteID primitive.ObjectID, claimedAt time.Time) {
+    ctx := context.Background()
+
+    result, err := config.DB.Collection("notes").UpdateOne(ctx, bson.M{
+        "_id": noteID,
+        "reminderSentAt": claimedAt,
+    }, bson.M{
+        "$set": bson.M{"reminderSentAt": nil},
+    })
+    if err != nil || result.ModifiedCount == 0 {
+        return
+    }
+    retryAt := time.Now().Add(time.Minute)
+    if err := s.Schedule(noteID, &retryAt); err != nil {
+        log.Println("Failed to requeue reminder for note", noteID.Hex(), err)
+    }
+}
+
+func (s *ReminderService) requeuePending() error {
+    ctx := context.Background()
+
+    cursor, err := config.DB.Collection("notes").Find(ctx, bson.M{
+        "trashed": false,
+        "reminderAt": bson.M{"$ne": nil},
+        "reminderSentAt": nil,
+    })
+    if err != nil {
+        return err
+    }
+    defer cursor.Close(ctx)
+
+    var notes []models.Note
+    if err = cursor.All(ctx, &notes); err != nil {
+        return err
+    }
+
+    for _, note := range notes {
+        if err := s.Schedule(note.ID, note.ReminderAt); err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,488 @@
+package services
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "sort"
+    "strings"
+    "time"
+    "notes-app/config"
+    "notes-app/models"
+    "github.com/go-redis/redis/v8"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Sessions are stored in Redis as
+//   session:<token>         -> user ID
+//   session_meta:<token>    -> hash of userId, ip, userAgent, device, createdAt, lastSeenAt, family
+//   user_sessions:<user ID> -> set of the user's session tokens
+// session:<token> and session_meta:<token> expire together, the set is pruned when it's read.
+//
+// Remember-me logins also get a refresh token. Each refresh swaps it for a new one in the
+// same family, and a family ends after the remember-me lifetime no matter how often it's used:
+//   refresh:<hash>           -> hash of userId, family, expiresAt for the current token
+//   refresh_used:<hash>      -> "<user ID> <family>" of a token that was already swapped
+//   refresh_family:<family>  -> hash of the family's current token
+//   user_refresh:<user ID>   -> set of the user's families
+// Presenting a swapped token again means it was copied, so the whole family is revoked.
+
+// ErrRefreshTokenReused is returned when an already rotated refresh token is presented
+var ErrRefreshTokenReused = errors.New("refresh token was already used, please sign in again")
+
+type SessionService struct {
+    idleTimeout      time.Duration
+    maxLifetime      time.Duration
+    rememberLifetime time.Duration
+}
+
+func NewSessionService() *SessionService {
+    return &SessionService{
+        idleTimeout:      config.GetEnvDuration("SESSION_IDLE_TIMEOUT", 24*time.Hour),
+        maxLifetime:      config.GetEnvDuration("SESSION_MAX_LIFETIME", 7*24*time.Hour),
+        rememberLifetime: config.GetEnvDuration("REMEMBER_ME_LIFETIME", 30*24*time.Hour),
+    }
+}
+
+// MaxLifetime is the longest a session can last, however active it is
+func (s *SessionService) MaxLifetime() time.Duration {
+    return s.maxLifetime
+}
+
+// RememberLifetime is how long a remember-me login lasts before signing in again
+func (s *SessionService) RememberLifetime() time.Duration {
+    return s.rememberLifetime
+}
+
+// Create starts a session for the user and returns its token
+func (s *SessionService) Create(userID primitive.ObjectID, client models.ClientInfo) (string, error) {
+    return s.create(userID, client, "")
+}
+
+// CreateRemembered starts a session together with a new refresh token family
+// and returns the session token and the refresh token
+func (s *SessionService) CreateRemembered(userID primitive.ObjectID, client models.ClientInfo) (string, string, error) {
+    ctx := context.Background()
+
+    family := generateSessionID()
+    expiresAt := time.Now().Add(s.rememberLifetime)
+    refreshToken, err := s.issueRefreshToken(userID, family, expiresAt)
+    if err != nil {
+        return "", "", err
+    }
+    if err := config.RedisClient.SAdd(ctx, "user_refresh:"+userID.Hex(), family).Err(); err != nil {
+        return "", "", err
+    }
+
+    sessionID, err := s.create(userID, client, family)
+    if err != nil {
+        return "", "", err
+    }
+    return sessionID, refreshToken, nil
+}
+
+func (s *SessionService) create(userID primitive.ObjectID, client models.ClientInfo, family string) (string, error) {
+    ctx := context.Background()
+
+    sessionID := generateSessionID()
+    now := time.Now().UTC().Format(time.RFC3339)
+    _, err := config.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+        pipe.Set(ctx, "session:"+sessionID, userID.Hex(), s.idleTimeout)
+        pipe.HSet(ctx, "session_meta:"+sessionID, map[string]interface{}{
+            "userId":     userID.Hex(),
+            "ip":         client.IP,
+            "userAgent":  client.UserAgent,
+            "device":     describeDevice(client.UserAgent),
+            "createdAt":  now,
+            "lastSeenAt": now,
+            "family":     family,
+        })
+        pipe.Expire(ctx, "session_meta:"+sessionID, s.idleTimeout)
+        pipe.SAdd(ctx, "user_sessions:"+userID.Hex(), sessionID)
+        return nil
+    })
+    if err != nil {
+        return "", err
+    }
+    return sessionID, nil
+}
+
+// Lookup returns the user ID of a session, records that it was seen and slides its
+// idle timeout forward, without going past the session's maximum lifetime
+func (s *SessionService) Lookup(sessionID string) (primitive.ObjectID, error) {
+    ctx := context.Background()
+
+    userID, err := config.RedisClient.Get(ctx, "session:"+sessionID).Result()
+    if err != nil {
+        return primitive.NilObjectID, errors.New("invalid session")
+    }
+    objID, err := primitive.ObjectIDFromHex(userID)
+    if err != nil {
+        return primitive.NilObjectID, err
+    }
+
+    // Sessions from before metadata was recorded have no meta hash, they keep their fixed TTL
+    createdAtValue, err := config.RedisClient.HGet(ctx, "session_meta:"+sessionID, "createdAt").Result()
+    if err != nil {
+        return objID, nil
+    }
+    createdAt, err := time.Parse(time.RFC3339, createdAtValue)
+    if err != nil {
+        return objID, nil
+    }
+
+    remaining := time.Until(createdAt.Add(s.maxLifetime))
+    if remaining <= 0 {
+        s.Delete(sessionID)
+        return primitive.NilObjectID, errors.New("session expired")
+    }
+    ttl := s.idleTimeout
+    if remaining < ttl {
+        ttl = remaining
+    }
+
+    _, err = config.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+        pipe.Expire(ctx, "session:"+sessionID, ttl)
+        pipe.HSet(ctx, "session_meta:"+sessionID, "lastSeenAt", time.Now().UTC().Format(time.RFC3339))
+        pipe.Expire(ctx, "session_meta:"+sessionID, ttl)
+        return nil
+    })
+    if err != nil {
+        return primitive.NilObjectID, err
+    }
+    return objID, nil
+}
+
+// Refresh swaps a refresh token for a new session and a new refresh token in the same family.
+// Reusing a token that was already swapped revokes the family and its sessions, and
+// returns ErrRefreshTokenReused with the ID of the family's user.
+func (s *SessionService) Refresh(refreshToken string, client models.ClientInfo) (primitive.ObjectID, string, string, error) {
+    ctx := context.Background()
+    hash := hashToken(refreshToken)
+
+    data, err := config.RedisClient.HGetAll(ctx, "refresh:"+hash).Result()
+    if err != nil {
+        return primitive.NilObjectID, "", "", err
+    }
+    // Deleting the token claims it, a concurrent refresh with the same token sees it as reused
+    deleted, err := config.RedisClient.Del(ctx, "refresh:"+hash).Result()
+    if err != nil {
+        return primitive.NilObjectID, "", "", err
+    }
+
+    if len(data) == 0 || deleted == 0 {
+        used, err := config.RedisClient.Get(ctx, "refresh_used:"+hash).Result()
+        if err == nil {
+            // Tokens swapped before the user ID was kept only have the family
+            userID, family, found := strings.Cut(used, " ")
+            if !found {
+                userID, family = "", used
+            }
+            s.revokeFamily(family)
+            objID, _ := primitive.ObjectIDFromHex(userID)
+            return objID, "", "", ErrRefreshTokenReused
+        }
+        return primitive.NilObjectID, "", "", errors.New("invalid refresh token")
+    }
+
+    userID, err := primitive.ObjectIDFromHex(data["userId"])
+    if err != nil {
+        return primitive.NilObjectID, "", "", errors.New("invalid refresh token")
+    }
+    expiresAt, err := time.Parse(time.RFC3339, data["expiresAt"])
+    if err != nil || !time.Now().Before(expiresAt) {
+        return primitive.NilObjectID, "", "", errors.New("refresh token expired")
+    }
+    family := data["family"]
+
+    if err := config.RedisClient.Set(ctx, "refresh_used:"+hash, userID.Hex()+" "+family, time.Until(expiresAt)).Err(); err != nil {
+        return primitive.NilObjectID, "", "", err
+    }
+
+    newToken, err := s.issueRefreshToken(userID, family, expiresAt)
+    if err != nil {
+        return primitive.NilObjectID, "", "", err
+    }
+    sessionID, err := s.create(userID, client, family)
+    if err != nil {
+        return primitive.NilObjectID, "", "", err
+    }
+    return userID, sessionID, newToken, nil
+}
+
+// RevokeRefreshToken ends the refresh token's family and its sessions, used on logout
+func (s *SessionService) RevokeRefreshToken(refreshToken string) error {
+    ctx := context.Background()
+
+    family, err := config.RedisClient.HGet(ctx, "refresh:"+hashToken(refreshToken), "family").Result()
+    if err == redis.Nil {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    return s.revokeFamily(family)
+}
+
+func (s *SessionService) issueRefreshToken(userID primitive.ObjectID, family string, expiresAt time.Time) (string, error) {
+    ctx := context.Background()
+
+    token := generateSessionID()
+    hash := hashToken(token)
+    ttl := time.Until(expiresAt)
+    _, err := config.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+        pipe.HSet(ctx, "refresh:"+hash, map[string]interface{}{
+            "userId":    userID.Hex(),
+            "family":    family,
+            "expiresAt": expiresAt.UTC().Format(time.RFC3339),
+        })
+        pipe.Expire(ctx, "refresh:"+hash, ttl)
+        pipe.Set(ctx, "refresh_family:"+family, hash, ttl)
+        return nil
+    })
+    if err != nil {
+        return "", err
+    }
+    return token, nil
+}
+
+// revokeFamily deletes the family's current refresh token and ends every session it created
+func (s *SessionService) revokeFamily(family string) error {
+    ctx := context.Background()
+
+    hash, err := config.RedisClient.Get(ctx, "refresh_family:"+family).Result()
+    if err != nil && err != redis.Nil {
+        return err
+    }
+    userID := ""
+    if hash != "" {
+        userID, _ = config.RedisClient.HGet(ctx, "refresh:"+hash, "userId").Result()
+        config.RedisClient.Del(ctx, "refresh:"+hash)
+    }
+    config.RedisClient.Del(ctx, "refresh_family:"+family)
+
+    if userID == "" {
+        return nil
+    }
+    objID, err := primitive.ObjectIDFromHex(userID)
+    if err != nil {
+        return nil
+    }
+    config.RedisClient.SRem(ctx, "user_refresh:"+userID, family)
+
+    tokens, err := s.activeTokens(objID)
+    if err != nil {
+        return err
+    }
+    for _, token := range tokens {
+        if config.RedisClient.HGet(ctx, "session_meta:"+token, "family").Val() == family {
+            if err := s.Delete(token); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// Delete ends a single session
+func (s *SessionService) Delete(sessionID string) error {
+    ctx := context.Background()
+
+    userID, _ := config.RedisClient.Get(ctx, "session:"+sessionID).Result()
+    _, err := config.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+        pipe.Del(ctx, "session:"+sessionID, "session_meta:"+sessionID)
+        if userID != "" {
+            pipe.SRem(ctx, "user_sessions:"+userID, sessionID)
+        }
+        return nil
+    })
+    return err
+}
+
+// List returns the user's active sessions, most recently used first.
+// currentSessionID marks the session making the request.
+func (s *SessionService) List(userID primitive.ObjectID, currentSessionID string) ([]models.Session, error) {
+    ctx := context.Background()
+
+    tokens, err := s.activeTokens(userID)
+    if err != nil {
+        return nil, err
+    }
+
+    sessions := []models.Session{}
+    for _, token := range tokens {
+        meta, err := config.RedisClient.HGetAll(ctx, "session_meta:"+token).Result()
+        if err != nil {
+            return nil, err
+        }
+        createdAt, _ := time.Parse(time.RFC3339, meta["createdAt"])
+        lastSeenAt, _ := time.Parse(time.RFC3339, meta["lastSeenAt"])
+        device := meta["device"]
+        if device == "" {
+            device = "Unknown device"
+        }
+        sessions = append(sessions, models.Session{
+            ID:         publicSessionID(token),
+            Device:     device,
+            IP:         meta["ip"],
+            UserAgent:  meta["userAgent"],
+            CreatedAt:  createdAt,
+            LastSeenAt: lastSeenAt,
+            Remembered: meta["family"] != "",
+            Current:    token == currentSessionID,
+        })
+    }
+
+    sort.Slice(sessions, func(i, j int) bool {
+        return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+    })
+    return sessions, nil
+}
+
+// Revoke ends the user's session with the given public ID. If it came from
+// a remember-me login, its refresh token is revoked too.
+func (s *SessionService) Revoke(userID primitive.ObjectID, id string) error {
+    ctx := context.Background()
+
+    tokens, err := s.activeTokens(userID)
+    if err != nil {
+        return err
+    }
+    for _, token := range tokens {
+        if publicSessionID(token) != id {
+            continue
+        }
+        if family := config.RedisClient.HGet(ctx, "session_meta:"+token, "family").Val(); family != "" {
+            if err := s.revokeFamily(family); err != nil {
+                return err
+            }
+        }
+        return s.Delete(token)
+    }
+    return errors.New("session not found")
+}
+
+// RevokeAll ends every session and remember-me login of the user except keepSessionID
+// and the login it belongs to. keepSessionID may be empty. It returns the number of sessions ended.
+func (s *SessionService) RevokeAll(userID primitive.ObjectID, keepSessionID string) (int, error) {
+    ctx := context.Background()
+
+    keepFamily := ""
+    if keepSessionID != "" {
+        keepFamily = config.RedisClient.HGet(ctx, "session_meta:"+keepSessionID, "family").Val()
+    }
+
+    families, err := config.RedisClient.SMembers(ctx, "user_refresh:"+userID.Hex()).Result()
+    if err != nil {
+        return 0, err
+    }
+    for _, family := range families {
+        if family == keepFamily {
+            continue
+        }
+        if err := s.revokeFamily(family); err != nil {
+            return 0, err
+        }
+        config.RedisClient.SRem(ctx, "user_refresh:"+userID.Hex(), family)
+    }
+
+    tokens, err := s.activeTokens(userID)
+    if err != nil {
+        return 0, err
+    }
+    revoked := 0
+    for _, token := range tokens {
+        if token == keepSessionID {
+            continue
+        }
+        if err := s.Delete(token); err != nil {
+            return revoked, err
+        }
+        revoked++
+    }
+    return revoked, nil
+}
+
+// activeTokens returns the user's session tokens, dropping expired ones from the set
+func (s *SessionService) activeTokens(userID primitive.ObjectID) ([]string, error) {
+    ctx := context.Background()
+
+    key := "user_sessions:" + userID.Hex()
+    tokens, err := config.RedisClient.SMembers(ctx, key).Result()
+    if err != nil {
+        return nil, err
+    }
+
+    var active []string
+    for _, token := range tokens {
+        exists, err := config.RedisClient.Exists(ctx, "session:"+token).Result()
+        if err != nil {
+            return nil, err
+        }
+        if exists == 0 {
+            config.RedisClient.SRem(ctx, key, token)
+            continue
+        }
+        active = append(active, token)
+    }
+    return active, nil
+}
+
+func hashToken(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])
+}
+
+// publicSessionID identifies a session in API responses without revealing its token
+func publicSessionID(token string) string {
+    return hashToken(token)[:16]
+}
+
+// describeDevice turns a user agent into something like "Firefox on Windows"
+func describeDevice(userAgent string) string {
+    ua := strings.ToLower(userAgent)
+    if ua == "" {
+        return "Unknown device"
+    }
+
+    browser := ""
+    switch {
+    case strings.Contains(ua, "edg/"):
+        browser = "Edge"
+    case strings.Contains(ua, "opr/") || strings.Contains(ua, "opera"):
+        browser = "Opera"
+    case strings.Contains(ua, "firefox/"):
+        browser = "Firefox"
+    case strings.Contains(ua, "chrome/") || strings.Contains(ua, "crios/"):
+        browser = "Chrome"
+    case strings.Contains(ua, "safari/"):
+        browser = "Safari"
+    case strings.Contains(ua, "curl/"):
+        browser = "curl"
+    }
+
+    os := ""
+    switch {
+    case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad"):
+        os = "iOS"
+    case strings.Contains(ua, "android"):
+        os = "Android"
+    case strings.Contains(ua, "windows"):
+        os = "Windows"
+    case strings.Contains(ua, "mac os"):
+        os = "macOS"
+    case strings.Contains(ua, "linux"):
+        os = "Linux"
+    }
+
+    switch {
+    case browser != "" && os != "":
+        return browser + " on " + os
+    case browser != "":
+        return browser
+    case os != "":
+        return os
+    }
+    return "Unknown device"
+}
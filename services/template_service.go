@@ -0,0 +1,309 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "regexp"
+    "strings"
+    "time"
+    "notes-app/config"
+    "notes-app/models"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// placeholderPattern matches template variables like {{date}} or {{ user.name }}
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.]+)\s*\}\}`)
+
+type TemplateService struct {
+    authService *AuthService
+}
+
+func NewTemplateService(authService *AuthService) *TemplateService {
+    return &TemplateService{authService: authService}
+}
+
+func (s *TemplateService) CreateTemplate(userID primitive.ObjectID, req models.TemplateRequest) (models.TemplateResponse, error) {
+    ctx := context.Background()
+
+    sharedWith, err := s.resolveSharedWith(userID, req.SharedWith)
+    if err != nil {
+        return models.TemplateResponse{}, err
+    }
+
+    template := models.NoteTemplate{
+        ID:          primitive.NewObjectID(),
+        UserID:      userID,
+        Name:        req.Name,
+        Description: req.Description,
+        Title:       req.Title,
+        Content:     req.Content,
+        Tags:        req.Tags,
+        SharedWith:  sharedWith,
+        CreatedAt:   time.Now(),
+        UpdatedAt:   time.Now(),
+    }
+
+    _, err = config.DB.Collection("templates").InsertOne(ctx, template)
+    if err != nil {
+        return models.TemplateResponse{}, err
+    }
+
+    return s.templateToResponse(template, userID), nil
+}
+
+// GetTemplates returns the user's own templates followed by templates shared with them
+func (s *TemplateService) GetTemplates(userID primitive.ObjectID) ([]models.TemplateResponse, error) {
+    ctx := context.Background()
+
+    filter := bson.M{"$or": []bson.M{
+        {"userId": userID},
+        {"sharedWith": userID},
+    }}
+    opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+
+    cursor, err := config.DB.Collection("templates").Find(ctx, filter, opts)
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+
+    var templates []models.NoteTemplate
+    if err = cursor.All(ctx, &templates); err != nil {
+        return nil, err
+    }
+
+    responses := []models.TemplateResponse{}
+    for _, template := range templates {
+        if template.UserID == userID {
+            responses = append(responses, s.templateToResponse(template, userID))
+        }
+    }
+    for _, template := range templates {
+        if template.UserID != userID {
+            responses = append(responses, s.templateToResponse(template, userID))
+        }
+    }
+
+    return responses, nil
+}
+
+func (s *TemplateService) GetTemplate(templateID, userID primitive.ObjectID) (models.TemplateResponse, error) {
+    template, err := s.findAccessibleTemplate(templateID, userID)
+    if err != nil {
+        return models.TemplateResponse{}, err
+    }
+    return s.templateToResponse(template, userID), nil
+}
+
+// UpdateTemplate updates a template (only owner can do this)
+func (s *TemplateService) UpdateTemplate(templateID, userID primitive.ObjectID, req models.TemplateRequest) (models.TemplateResponse, error) {
+    ctx := context.Background()
+
+    sharedWith, err := s.resolveSharedWith(userID, req.SharedWith)
+    if err != nil {
+        return models.TemplateResponse{}, err
+    }
+
+    update := bson.M{
+        "$set": bson.M{
+            "name":        req.Name,
+            "description": req.Description,
+            "title":       req.Title,
+            "content":     req.Content,
+            "tags":        req.Tags,
+            "sharedWith":  sharedWith,
+            "updatedAt":   time.Now(),
+        },
+        // Templates used to be shareable with everyone, that's no longer possible
+        "$unset": bson.M{"shared": ""},
+    }
+
+    result, err := config.DB.Collection("templates").UpdateOne(ctx, bson.M{
+        "_id": templateID,
+        "userId": userID,
+    }, update)
+
+    if err != nil || result.MatchedCount == 0 {
+        return models.TemplateResponse{}, errors.New("template not found or not owner")
+    }
+
+    return s.GetTemplate(templateID, userID)
+}
+
+// DeleteTemplate deletes a template (only owner can do this)
+func (s *TemplateService) DeleteTemplate(templateID, userID primitive.ObjectID) error {
+    ctx := context.Background()
+
+    result, err := config.DB.Collection("templates").DeleteOne(ctx, bson.M{
+        "_id": templateID,
+        "userId": userID,
+    })
+
+    if err != nil || result.DeletedCount == 0 {
+        return errors.New("template not found or not owner")
+    }
+
+    return nil
+}
+
+// ApplyTemplate fills the title, content and tags of a note request from a template.
+// Fields already set on the request take precedence over the template, tags are merged.
+func (s *TemplateService) ApplyTemplate(templateID, userID primitive.ObjectID, req models.NoteRequest) (models.NoteRequest, error) {
+    ctx := context.Background()
+
+    template, err := s.findAccessibleTemplate(templateID, userID)
+    if err != nil {
+        return req, err
+    }
+
+    var user models.User
+    err = config.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+    if err != nil {
+        return req, err
+    }
+
+    vars := templateVariables(user, time.Now(), req.Variables)
+
+    if req.Title == "" {
+        req.Title = renderTemplate(template.Title, vars)
+    }
+    if req.Content == "" {
+        req.Content = renderTemplate(template.Content, vars)
+    }
+
+    seen := map[string]bool{}
+    var tags []string
+    for _, tag := range append(template.Tags, req.Tags...) {
+        tag = renderTemplate(tag, vars)
+        if tag == "" || seen[tag] {
+            continue
+        }
+        seen[tag] = true
+        tags = append(tags, tag)
+    }
+    req.Tags = tags
+
+    return req, nil
+}
+
+// resolveSharedWith looks up the users a template is shared with by username.
+// Every username has to exist, so a typo doesn't silently leave someone out.
+func (s *TemplateService) resolveSharedWith(userID primitive.ObjectID, usernames []string) ([]primitive.ObjectID, error) {
+    if len(usernames) == 0 {
+        return []primitive.ObjectID{}, nil
+    }
+    users, err := s.authService.ResolveUsernames(usernames)
+    if err != nil {
+        return nil, err
+    }
+    found := map[string]bool{}
+    ids := []primitive.ObjectID{}
+    for _, user := range users {
+        found[strings.ToLower(user.Username)] = true
+        if user.ID != userID {
+            ids = append(ids, user.ID)
+        }
+    }
+    for _, username := range usernames {
+        if !found[strings.ToLower(strings.TrimSpace(username))] {
+            return nil, errors.New("user " + username + " not found")
+        }
+    }
+    // Like sharing a note, both sides need a verified email
+    if err := requireVerified(RestrictShare, append([]primitive.ObjectID{userID}, ids...)...); err != nil {
+        return nil, err
+    }
+    return ids, nil
+}
+
+// sharedWithUsernames returns the usernames of the users a template is shared with
+func (s *TemplateService) sharedWithUsernames(template models.NoteTemplate) []string {
+    ctx := context.Background()
+
+    usernames := []string{}
+    if len(template.SharedWith) == 0 {
+        return usernames
+    }
+    cursor, err := config.DB.Collection("users").Find(ctx, bson.M{"_id": bson.M{"$in": template.SharedWith}})
+    if err != nil {
+        return usernames
+    }
+    defer cursor.Close(ctx)
+    var users []models.User
+    if err := cursor.All(ctx, &users); err != nil {
+        return usernames
+    }
+    for _, user := range users {
+        usernames = append(usernames, user.Username)
+    }
+    return usernames
+}
+
+func (s *TemplateService) findAccessibleTemplate(templateID, userID primitive.ObjectID) (models.NoteTemplate, error) {
+    ctx := context.Background()
+
+    var template models.NoteTemplate
+    err := config.DB.Collection("templates").FindOne(ctx, bson.M{
+        "_id": templateID,
+        "$or": []bson.M{
+            {"userId": userID},
+            {"sharedWith": userID},
+        },
+    }).Decode(&template)
+
+    if err != nil {
+        return models.NoteTemplate{}, errors.New("template not found or access denied")
+    }
+
+    return template, nil
+}
+
+// templateVariables builds the placeholder values available to a template.
+// Caller supplied variables cannot override the built-in ones.
+func templateVariables(user models.User, now time.Time, custom map[string]string) map[string]string {
+    vars := map[string]string{}
+    for key, value := range custom {
+        vars[key] = value
+    }
+    vars["date"] = now.Format("2006-01-02")
+    vars["time"] = now.Format("15:04")
+    vars["datetime"] = now.Format("2006-01-02 15:04")
+    vars["weekday"] = now.Weekday().String()
+    vars["user.name"] = user.Name
+    vars["user.username"] = user.Username
+    vars["user.email"] = user.Email
+    return vars
+}
+
+// renderTemplate replaces known placeholders, unknown ones are left untouched
+func renderTemplate(text string, vars map[string]string) string {
+    return placeholderPattern.ReplaceAllStringFunc(text, func(match string) string {
+        key := strings.TrimSpace(placeholderPattern.FindStringSubmatch(match)[1])
+        if value, ok := vars[key]; ok {
+            return value
+        }
+        return match
+    })
+}
+
+// templateToResponse converts a template for userID. Only the owner sees who it's shared with.
+func (s *TemplateService) templateToResponse(template models.NoteTemplate, userID primitive.ObjectID) models.TemplateResponse {
+    response := models.TemplateResponse{
+        ID:          template.ID.Hex(),
+        Name:        template.Name,
+        Description: template.Description,
+        Title:       template.Title,
+        Content:     template.Content,
+        Tags:        template.Tags,
+        Shared:      len(template.SharedWith) > 0,
+        UserID:      template.UserID.Hex(),
+        CreatedAt:   template.CreatedAt,
+        UpdatedAt:   template.UpdatedAt,
+    }
+    if template.UserID == userID {
+        response.SharedWith = s.sharedWithUsernames(template)
+    }
+    return response
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+    "testing"
+    "time"
+)
+
+// rfcSecret is the SHA1 key of the RFC 4226 and RFC 6238 test vectors
+const rfcSecret = "12345678901234567890"
+
+func TestHOTPVectors(t *testing.T) {
+    // RFC 4226 appendix D
+    want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+    for counter, code := range want {
+        if got := hotp([]byte(rfcSecret), uint64(counter), 6); got != code {
+            t.Errorf("counter %d: got %s, want %s", counter, got, code)
+        }
+    }
+}
+
+func TestTOTPVectors(t *testing.T) {
+    // RFC 6238 appendix B, SHA1 with 8 digits
+    tests := []struct {
+        unix int64
+        code string
+    }{
+        {59, "94287082"},
+        {1111111109, "07081804"},
+        {1111111111, "14050471"},
+        {1234567890, "89005924"},
+        {2000000000, "69279037"},
+        {20000000000, "65353130"},
+    }
+    secret := totpEncoding.EncodeToString([]byte(rfcSecret))
+    for _, test := range tests {
+        if got := hotp([]byte(rfcSecret), uint64(test.unix/totpPeriod), 8); got != test.code {
+            t.Errorf("T=%d: got %s, want %s", test.unix, got, test.code)
+        }
+        // The 6 digit codes apps show are the last 6 digits
+        got, err := TOTPCode(secret, time.Unix(test.unix, 0))
+        if err != nil || got != test.code[2:] {
+            t.Errorf("T=%d: TOTPCode = %s, %v, want %s", test.unix, got, err, test.code[2:])
+        }
+    }
+}
+
+func TestVerifyTOTPWindow(t *testing.T) {
+    secret := GenerateTOTPSecret()
+    now := time.Unix(1700000000, 0)
+    current := now.Unix() / totpPeriod
+
+    tests := []struct {
+        offset int64
+        ok     bool
+    }{
+        {-2, false},
+        {-1, true},
+        {0, true},
+        {1, true},
+        {2, false},
+    }
+    for _, test := range tests {
+        code, err := totpCodeAt(secret, current+test.offset)
+        if err != nil {
+            t.Fatal(err)
+        }
+        step, ok := VerifyTOTP(secret, code, now)
+        if ok != test.ok {
+            t.Errorf("code from step %+d: ok = %v, want %v", test.offset, ok, test.ok)
+        }
+        if ok && step != current+test.offset {
+            t.Errorf("code from step %+d matched step %d", test.offset, step-current)
+        }
+    }
+
+    code, _ := TOTPCode(secret, now)
+    if _, ok := VerifyTOTP(secret, code[:3]+" "+code[3:], now); !ok {
+        t.Error("code with a space in the middle was refused")
+    }
+    for _, bad := range []string{"", "12345", "1234567", "abcdef"} {
+        if _, ok := VerifyTOTP(secret, bad, now); ok {
+            t.Errorf("%q accepted", bad)
+        }
+    }
+}
+
+func TestVerifyTOTPReplay(t *testing.T) {
+    // Verify only accepts a step newer than the last one used, so a code has to
+    // map to the same step for as long as it's accepted
+    secret := GenerateTOTPSecret()
+    now := time.Unix(1700000000, 0)
+    code, _ := TOTPCode(secret, now)
+
+    first, ok := VerifyTOTP(secret, code, now)
+    if !ok {
+        t.Fatal("code refused")
+    }
+    for _, later := range []time.Duration{time.Second, 20 * time.Second, totpPeriod * time.Second} {
+        step, ok := VerifyTOTP(secret, code, now.Add(later))
+        if ok && step != first {
+            t.Errorf("replay %v later matched step %d, want %d, it would pass the last-step check", later, step, first)
+        }
+    }
+
+    next, _ := TOTPCode(secret, now.Add(totpPeriod*time.Second))
+    if step, ok := VerifyTOTP(secret, next, now.Add(totpPeriod*time.Second)); !ok || step <= first {
+        t.Errorf("next code matched step %d, want one after %d", step, first)
+    }
+}
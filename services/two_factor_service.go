@@ -0,0 +1,365 @@
+package services
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "log"
+    "strconv"
+    "strings"
+    "time"
+    "notes-app/config"
+    "notes-app/models"
+    "github.com/go-redis/redis/v8"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "golang.org/x/crypto/bcrypt"
+)
+
+const (
+    recoveryCodeCount = 10
+    // maxTwoFactorAttempts is how many wrong codes a pending login allows before it's discarded
+    maxTwoFactorAttempts = 5
+)
+
+// Codes Verify rejects. Any other error it returns is a failure of the database.
+var (
+    ErrInvalidCode         = errors.New("invalid code")
+    ErrInvalidRecoveryCode = errors.New("invalid recovery code")
+    ErrCodeAlreadyUsed     = errors.New("code was already used, wait for the next one")
+)
+
+// ErrPasswordIncorrect is returned when re-confirming a sensitive change with the wrong password
+var ErrPasswordIncorrect = errors.New("password is incorrect")
+
+// failPendingScript counts a wrong code on the pending login at KEYS[1] and returns the
+// attempts so far, or -1 if the login has expired. Incrementing an expired login would
+// create a new hash without a TTL that's never cleaned up.
+var failPendingScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+    return -1
+end
+return redis.call("HINCRBY", KEYS[1], "attempts", 1)
+`)
+
+// TwoFactorService handles TOTP enrollment and verification. Logins of users with
+// 2FA enabled are parked as pending_2fa:<token hash> in Redis until a code is given.
+type TwoFactorService struct {
+    auditService *AuditService
+    loginGuard   *LoginGuard
+    issuer       string
+    pendingTTL   time.Duration
+}
+
+func NewTwoFactorService(auditService *AuditService, loginGuard *LoginGuard) *TwoFactorService {
+    return &TwoFactorService{
+        auditService: auditService,
+        loginGuard:   loginGuard,
+        issuer:       config.GetEnv("TOTP_ISSUER", "Notes App"),
+        pendingTTL:   config.GetEnvDuration("TWO_FACTOR_PENDING_TTL", 5*time.Minute),
+    }
+}
+
+// Setup starts enrollment with a new secret. 2FA isn't active until Enable confirms a code from it.
+func (s *TwoFactorService) Setup(userID primitive.ObjectID) (models.TOTPSetupResponse, error) {
+    ctx := context.Background()
+
+    user, err := s.findUser(userID)
+    if err != nil {
+        return models.TOTPSetupResponse{}, err
+    }
+    if user.TOTPEnabled {
+        return models.TOTPSetupResponse{}, errors.New("two-factor authentication is already enabled")
+    }
+
+    secret := GenerateTOTPSecret()
+    _, err = config.DB.Collection("users").UpdateOne(ctx, bson.M{"_id": userID}, bson.M{
+        "$set": bson.M{"totpPendingSecret": secret},
+    })
+    if err != nil {
+        return models.TOTPSetupResponse{}, err
+    }
+
+    return models.TOTPSetupResponse{
+        Secret:          secret,
+        ProvisioningURI: TOTPProvisioningURI(s.issuer, user.Username, secret),
+    }, nil
+}
+
+// Enable activates 2FA once the user proves their authenticator works,
+// and returns recovery codes, which are only shown this once
+func (s *TwoFactorService) Enable(userID primitive.ObjectID, code string, client models.ClientInfo) ([]string, error) {
+    ctx := context.Background()
+
+    user, err := s.findUser(userID)
+    if err != nil {
+        return nil, err
+    }
+    if user.TOTPEnabled {
+        return nil, errors.New("two-factor authentication is already enabled")
+    }
+    if user.TOTPPendingSecret == "" {
+        return nil, errors.New("start two-factor setup first")
+    }
+    step, ok := VerifyTOTP(user.TOTPPendingSecret, code, time.Now())
+    if !ok {
+        return nil, ErrInvalidCode
+    }
+
+    codes, hashes := generateRecoveryCodes()
+    result, err := config.DB.Collection("users").UpdateOne(ctx, bson.M{
+        "_id": userID,
+        "totpPendingSecret": user.TOTPPendingSecret,
+    }, bson.M{
+        "$set": bson.M{
+            "totpEnabled":   true,
+            "totpSecret":    user.TOTPPendingSecret,
+            "totpLastStep":  step,
+            "recoveryCodes": hashes,
+        },
+        "$unset": bson.M{"totpPendingSecret": ""},
+    })
+    if err != nil {
+        return nil, err
+    }
+    if result.ModifiedCount == 0 {
+        return nil, errors.New("two-factor setup changed, start again")
+    }
+
+    s.auditService.Record(&userID, models.AuditTwoFactorOn, "user", userID.Hex(), client, nil)
+    return codes, nil
+}
+
+// Disable turns 2FA off after re-checking the user's identity and a current code
+func (s *TwoFactorService) Disable(userID primitive.ObjectID, req models.ReauthRequest, client models.ClientInfo) error {
+    ctx := context.Background()
+
+    user, err := s.reauthenticate(userID, req, client)
+    if err != nil {
+        return err
+    }
+
+    _, err = config.DB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+        "$set": bson.M{"totpEnabled": false},
+        "$unset": bson.M{"totpSecret": "", "totpPendingSecret": "", "totpLastStep": "", "recoveryCodes": ""},
+    })
+    if err != nil {
+        return err
+    }
+
+    s.auditService.Record(&userID, models.AuditTwoFactorOff, "user", userID.Hex(), client, nil)
+    return nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after re-checking the user's identity and a current code
+func (s *TwoFactorService) RegenerateRecoveryCodes(userID primitive.ObjectID, req models.ReauthRequest, client models.ClientInfo) ([]string, error) {
+    ctx := context.Background()
+
+    user, err := s.reauthenticate(userID, req, client)
+    if err != nil {
+        return nil, err
+    }
+
+    codes, hashes := generateRecoveryCodes()
+    _, err = config.DB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+        "$set": bson.M{"recoveryCodes": hashes},
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    s.auditService.Record(&userID, models.AuditRecoveryReset, "user", userID.Hex(), client, nil)
+    return codes, nil
+}
+
+// Verify checks an authenticator code or a recovery code for a user with 2FA enabled.
+// Each code works once: authenticator codes can't be replayed and recovery codes are used up.
+func (s *TwoFactorService) Verify(user models.User, code, recoveryCode string, client models.ClientInfo) error {
+    ctx := context.Background()
+
+    if !user.TOTPEnabled {
+        return errors.New("two-factor authentication is not enabled")
+    }
+
+    if recoveryCode != "" {
+        hash := hashRecoveryCode(recoveryCode)
+        var updated models.User
+        err := config.DB.Collection("users").FindOneAndUpdate(ctx, bson.M{
+            "_id": user.ID,
+            "recoveryCodes": hash,
+        }, bson.M{
+            "$pull": bson.M{"recoveryCodes": hash},
+        }).Decode(&updated)
+        if err == mongo.ErrNoDocuments {
+            return ErrInvalidRecoveryCode
+        }
+        if err != nil {
+            return err
+        }
+        s.auditService.Record(&user.ID, models.AuditRecoveryUsed, "user", user.ID.Hex(), client, map[string]string{
+            "remaining": strconv.Itoa(len(updated.RecoveryCodes) - 1),
+        })
+        return nil
+    }
+
+    step, ok := VerifyTOTP(user.TOTPSecret, code, time.Now())
+    if !ok {
+        return ErrInvalidCode
+    }
+    result, err := config.DB.Collection("users").UpdateOne(ctx, bson.M{
+        "_id": user.ID,
+        "$or": []bson.M{
+            {"totpLastStep": bson.M{"$exists": false}},
+            {"totpLastStep": bson.M{"$lt": step}},
+        },
+    }, bson.M{
+        "$set": bson.M{"totpLastStep": step},
+    })
+    if err != nil {
+        return err
+    }
+    if result.ModifiedCount == 0 {
+        return ErrCodeAlreadyUsed
+    }
+    return nil
+}
+
+// CreatePending parks a password-verified login until the second factor is given
+func (s *TwoFactorService) CreatePending(userID primitive.ObjectID, rememberMe bool) (string, error) {
+    ctx := context.Background()
+
+    token := generateSessionID()
+    key := "pending_2fa:" + hashToken(token)
+    _, err := config.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+        pipe.HSet(ctx, key, map[string]interface{}{
+            "userId":     userID.Hex(),
+            "rememberMe": strconv.FormatBool(rememberMe),
+            "attempts":   0,
+        })
+        pipe.Expire(ctx, key, s.pendingTTL)
+        return nil
+    })
+    if err != nil {
+        return "", err
+    }
+    return token, nil
+}
+
+// LookupPending returns the user and remember-me flag of a pending login
+func (s *TwoFactorService) LookupPending(token string) (primitive.ObjectID, bool, error) {
+    ctx := context.Background()
+
+    data, err := config.RedisClient.HGetAll(ctx, "pending_2fa:"+hashToken(token)).Result()
+    if err != nil {
+        return primitive.NilObjectID, false, err
+    }
+    userID, err := primitive.ObjectIDFromHex(data["userId"])
+    if err != nil {
+        return primitive.NilObjectID, false, errors.New("login expired, please sign in again")
+    }
+    rememberMe, _ := strconv.ParseBool(data["rememberMe"])
+    return userID, rememberMe, nil
+}
+
+// FailPending counts a wrong code and discards the pending login after too many.
+// It returns true if the login was discarded.
+func (s *TwoFactorService) FailPending(token string) bool {
+    ctx := context.Background()
+
+    key := "pending_2fa:" + hashToken(token)
+    attempts, err := failPendingScript.Run(ctx, config.RedisClient, []string{key}).Int64()
+    if err != nil || attempts < 0 || attempts >= maxTwoFactorAttempts {
+        config.RedisClient.Del(ctx, key)
+        return true
+    }
+    return false
+}
+
+// CompletePending removes a pending login. It returns false if it was already used,
+// so two requests racing with the same token can't both get a session.
+func (s *TwoFactorService) CompletePending(token string) bool {
+    ctx := context.Background()
+    deleted, err := config.RedisClient.Del(ctx, "pending_2fa:"+hashToken(token)).Result()
+    return err == nil && deleted == 1
+}
+
+// reauthenticate checks the password, or a fresh provider sign-in for accounts without one,
+// and a current code. Wrong guesses count as failed logins, so they're throttled like logins.
+func (s *TwoFactorService) reauthenticate(userID primitive.ObjectID, req models.ReauthRequest, client models.ClientInfo) (models.User, error) {
+    user, err := s.findUser(userID)
+    if err != nil {
+        return models.User{}, err
+    }
+    if !user.TOTPEnabled {
+        return models.User{}, errors.New("two-factor authentication is not enabled")
+    }
+    if err := s.loginGuard.Check(user.Username, client.IP); err != nil {
+        return models.User{}, err
+    }
+    if user.Password != "" && bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+        s.reauthFailed(user, client)
+        return models.User{}, ErrPasswordIncorrect
+    }
+
+    code, recoveryCode := splitTwoFactorCode(req.Code)
+    if err := s.Verify(user, code, recoveryCode, client); err != nil {
+        s.reauthFailed(user, client)
+        return models.User{}, err
+    }
+    // Checked last since each confirmation is good for one change
+    if user.Password == "" && !RecentlyReauthenticated(userID) {
+        return models.User{}, ErrRecentLoginRequired
+    }
+    return user, nil
+}
+
+// reauthFailed counts a wrong password or code. Failing to count is only logged.
+func (s *TwoFactorService) reauthFailed(user models.User, client models.ClientInfo) {
+    if _, err := s.loginGuard.RecordFailure(user.Username, client.IP); err != nil {
+        log.Println("Failed to record login failure of", user.Username, err)
+    }
+}
+
+func (s *TwoFactorService) findUser(userID primitive.ObjectID) (models.User, error) {
+    ctx := context.Background()
+
+    var user models.User
+    err := config.DB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+    if err != nil {
+        return models.User{}, errors.New("user not found")
+    }
+    return user, nil
+}
+
+// splitTwoFactorCode tells apart a code typed into a single field: 6 digits are
+// an authenticator code, anything else is treated as a recovery code
+func splitTwoFactorCode(input string) (string, string) {
+    trimmed := strings.TrimSpace(input)
+    if _, err := strconv.Atoi(trimmed); err != nil || len(trimmed) != totpDigits {
+        return "", input
+    }
+    return input, ""
+}
+
+// generateRecoveryCodes returns codes like "3f9a1-c04be" and their hashes for storage
+func generateRecoveryCodes() ([]string, []string) {
+    var codes, hashes []string
+    for i := 0; i < recoveryCodeCount; i++ {
+        raw := make([]byte, 5)
+        rand.Read(raw)
+        code := hex.EncodeToString(raw)
+        code = code[:5] + "-" + code[5:]
+        codes = append(codes, code)
+        hashes = append(hashes, hashRecoveryCode(code))
+    }
+    return codes, hashes
+}
+
+// hashRecoveryCode ignores case, spaces and dashes so codes can be typed loosely
+func hashRecoveryCode(code string) string {
+    normalized := strings.ToLower(code)
+    normalized = strings.NewReplacer("-", "", " ", "").Replace(normalized)
+    return hashToken(normalized)
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "net"
+    "net/http"
+    "net/url"
+    "syscall"
+    "time"
+    "notes-app/config"
+)
+
+// ErrWebhookAddressNotAllowed is returned for webhook hosts on loopback, private,
+// link-local or otherwise internal addresses, which would let users probe the
+// network the server runs in
+var ErrWebhookAddressNotAllowed = errors.New("webhook address is not allowed")
+
+// sharedAddressSpace is 100.64.0.0/10, used for carrier-grade NAT and some cloud internals
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// webhookPrivateAllowed reports whether webhooks may use http and internal addresses,
+// which is only meant for local development
+func webhookPrivateAllowed() bool {
+    return config.GetEnvBool("WEBHOOK_ALLOW_PRIVATE_URLS", false)
+}
+
+// publicIP reports whether ip is an address webhooks may be delivered to
+func publicIP(ip net.IP) bool {
+    return !(ip.IsLoopback() ||
+        ip.IsPrivate() ||
+        ip.IsLinkLocalUnicast() ||
+        ip.IsLinkLocalMulticast() ||
+        ip.IsInterfaceLocalMulticast() ||
+        ip.IsMulticast() ||
+        ip.IsUnspecified() ||
+        sharedAddressSpace.Contains(ip) ||
+        ip.Equal(net.IPv4bcast))
+}
+
+// validateWebhookURL checks a webhook URL when it's registered: https, and a host
+// that only resolves to public addresses. Deliveries are checked again when
+// connecting, since DNS can change after registration.
+func validateWebhookURL(raw string, allowPrivate bool) error {
+    parsed, err := url.Parse(raw)
+    if err != nil || parsed.Hostname() == "" {
+        return errors.New("invalid webhook URL")
+    }
+    if allowPrivate {
+        if parsed.Scheme != "https" && parsed.Scheme != "http" {
+            return errors.New("webhook URL must use http or https")
+        }
+        return nil
+    }
+    if parsed.Scheme != "https" {
+        return errors.New("webhook URL must use https")
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    addrs, err := net.DefaultResolver.LookupIPAddr(ctx, parsed.Hostname())
+    if err != nil {
+        return errors.New("webhook host could not be resolved")
+    }
+    for _, addr := range addrs {
+        if !publicIP(addr.IP) {
+            return ErrWebhookAddressNotAllowed
+        }
+    }
+    return nil
+}
+
+// guardedDialControl refuses connections to non-public addresses. It runs after DNS
+// resolution for every connection, redirects included, so rebinding a name to an
+// internal address after registration doesn't get through.
+func guardedDialControl(network, address string, _ syscall.RawConn) error {
+    host, _, err := net.SplitHostPort(address)
+    if err != nil {
+        return err
+    }
+    ip := net.ParseIP(host)
+    if ip == nil || !publicIP(ip) {
+        return ErrWebhookAddressNotAllowed
+    }
+    return nil
+}
+
+// NewWebhookClient returns the HTTP client webhooks are delivered with. It only
+// connects to public addresses, doesn't use proxies and doesn't follow redirects,
+// a receiver that redirects counts as a failed delivery.
+func NewWebhookClient(timeout time.Duration) *http.Client {
+    dialer := &net.Dialer{Timeout: 10 * time.Second}
+    if !webhookPrivateAllowed() {
+        dialer.Control = guardedDialControl
+    }
+    return &http.Client{
+        Timeout: timeout,
+        Transport: &http.Transport{
+            DialContext:         dialer.DialContext,
+            TLSHandshakeTimeout: 10 * time.Second,
+            MaxIdleConns:        10,
+            IdleConnTimeout:     90 * time.Second,
+        },
+        CheckRedirect: func(req *http.Request, via []*http.Request) error {
+            return http.ErrUseLastResponse
+        },
+    }
+}
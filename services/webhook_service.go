@@ -0,0 +1,355 @@
+package services
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "time"
+    "notes-app/config"
+    "notes-app/models"
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type WebhookService struct {
+    client       *http.Client
+    maxAttempts  int
+    retryBase    time.Duration
+    allowPrivate bool
+}
+
+// NewWebhookService delivers webhooks with client, which should come from NewWebhookClient
+func NewWebhookService(client *http.Client) *WebhookService {
+    return &WebhookService{
+        client:       client,
+        maxAttempts:  config.GetEnvInt("WEBHOOK_MAX_ATTEMPTS", 6),
+        retryBase:    config.GetEnvDuration("WEBHOOK_RETRY_BASE", 30*time.Second),
+        allowPrivate: webhookPrivateAllowed(),
+    }
+}
+
+func (s *WebhookService) EnsureIndexes() error {
+    ctx := context.Background()
+    _, err := config.DB.Collection("webhook_deliveries").Indexes().CreateMany(ctx, []mongo.IndexModel{
+        {Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextAttemptAt", Value: 1}}},
+        {Keys: bson.D{{Key: "webhookId", Value: 1}, {Key: "createdAt", Value: -1}}},
+    })
+    return err
+}
+
+// SignPayload returns the value of the X-Webhook-Signature header for a body
+func SignPayload(secret string, body []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write(body)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *WebhookService) CreateWebhook(userID primitive.ObjectID, req models.WebhookRequest) (models.WebhookCreatedResponse, error) {
+    ctx := context.Background()
+
+    for _, event := range req.Events {
+        if !isWebhookEvent(event) {
+            return models.WebhookCreatedResponse{}, errors.New("unknown event: " + event)
+        }
+    }
+    if err := validateWebhookURL(req.URL, s.allowPrivate); err != nil {
+        return models.WebhookCreatedResponse{}, err
+    }
+
+    secret := req.Secret
+    if secret == "" {
+        secret = generateSessionID()
+    }
+
+    webhook := models.Webhook{
+        ID:        primitive.NewObjectID(),
+        UserID:    userID,
+        URL:       req.URL,
+        Secret:    secret,
+        Events:    req.Events,
+        CreatedAt: time.Now(),
+    }
+    if webhook.Events == nil {
+        webhook.Events = []string{}
+    }
+
+    _, err := config.DB.Collection("webhooks").InsertOne(ctx, webhook)
+    if err != nil {
+        return models.WebhookCreatedResponse{}, err
+    }
+
+    return models.WebhookCreatedResponse{Webhook: webhook, Secret: secret}, nil
+}
+
+func (s *WebhookService) GetWebhooks(userID primitive.ObjectID) ([]models.Webhook, error) {
+    ctx := context.Background()
+
+    opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+    cursor, err := config.DB.Collection("webhooks").Find(ctx, bson.M{"userId": userID}, opts)
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+
+    webhooks := []models.Webhook{}
+    if err = cursor.All(ctx, &webhooks); err != nil {
+        return nil, err
+    }
+    return webhooks, nil
+}
+
+// DeleteWebhook removes a webhook, pending deliveries for it are dropped
+func (s *WebhookService) DeleteWebhook(webhookID, userID primitive.ObjectID) error {
+    ctx := context.Background()
+
+    result, err := config.DB.Collection("webhooks").DeleteOne(ctx, bson.M{"_id": webhookID, "userId": userID})
+    if err != nil || result.DeletedCount == 0 {
+        return errors.New("webhook not found")
+    }
+
+    _, err = config.DB.Collection("webhook_deliveries").DeleteMany(ctx, bson.M{
+        "webhookId": webhookID,
+        "status": models.DeliveryPending,
+    })
+    return err
+}
+
+// GetDeliveries returns the most recent deliveries of a webhook
+func (s *WebhookService) GetDeliveries(webhookID, userID primitive.ObjectID) ([]models.WebhookDelivery, error) {
+    return s.findDeliveries(bson.M{"webhookId": webhookID, "userId": userID})
+}
+
+// GetDeadLetters returns deliveries that gave up after the maximum number of attempts
+func (s *WebhookService) GetDeadLetters(userID primitive.ObjectID) ([]models.WebhookDelivery, error) {
+    return s.findDeliveries(bson.M{"userId": userID, "status": models.DeliveryDead})
+}
+
+// Redeliver queues a dead or delivered delivery again
+func (s *WebhookService) Redeliver(deliveryID, userID primitive.ObjectID) error {
+    ctx := context.Background()
+
+    result, err := config.DB.Collection("webhook_deliveries").UpdateOne(ctx, bson.M{
+        "_id": deliveryID,
+        "userId": userID,
+        "status": bson.M{"$ne": models.DeliveryPending},
+    }, bson.M{
+        "$set": bson.M{"status": models.DeliveryPending, "attempts": 0, "nextAttemptAt": time.Now()},
+        "$unset": bson.M{"lockedUntil": "", "lastError": ""},
+    })
+    if err != nil || result.MatchedCount == 0 {
+        return errors.New("delivery not found or already pending")
+    }
+    return nil
+}
+
+// Dispatch queues a delivery of event for every matching webhook of the given users
+func (s *WebhookService) Dispatch(userIDs []primitive.ObjectID, event string, note models.NoteResponse) error {
+    ctx := context.Background()
+
+    cursor, err := config.DB.Collection("webhooks").Find(ctx, bson.M{
+        "userId": bson.M{"$in": userIDs},
+        "$or": []bson.M{
+            {"events": event},
+            {"events": bson.M{"$size": 0}},
+        },
+    })
+    if err != nil {
+        return err
+    }
+    defer cursor.Close(ctx)
+
+    var webhooks []models.Webhook
+    if err = cursor.All(ctx, &webhooks); err != nil {
+        return err
+    }
+    if len(webhooks) == 0 {
+        return nil
+    }
+
+    payload, err := json.Marshal(models.WebhookPayload{
+        Event:      event,
+        OccurredAt: time.Now(),
+        Note:       note,
+    })
+    if err != nil {
+        return err
+    }
+
+    var deliveries []interface{}
+    for _, webhook := range webhooks {
+        deliveries = append(deliveries, models.WebhookDelivery{
+            ID:            primitive.NewObjectID(),
+            WebhookID:     webhook.ID,
+            UserID:        webhook.UserID,
+            Event:         event,
+            Payload:       string(payload),
+            Status:        models.DeliveryPending,
+            NextAttemptAt: time.Now(),
+            CreatedAt:     time.Now(),
+        })
+    }
+
+    _, err = config.DB.Collection("webhook_deliveries").InsertMany(ctx, deliveries)
+    return err
+}
+
+// Start delivers pending webhooks until ctx is cancelled
+func (s *WebhookService) Start(ctx context.Context) {
+    ticker := time.NewTicker(config.GetEnvDuration("WEBHOOK_POLL_INTERVAL", 5*time.Second))
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            s.ProcessPending()
+        }
+    }
+}
+
+// ProcessPending attempts every delivery that is due
+func (s *WebhookService) ProcessPending() {
+    for {
+        delivery, err := s.claimNext()
+        if err != nil {
+            if err != mongo.ErrNoDocuments {
+                log.Println("Failed to claim webhook delivery:", err)
+            }
+            return
+        }
+        s.attempt(delivery)
+    }
+}
+
+// claimNext locks one due delivery so other instances skip it while it's in flight
+func (s *WebhookService) claimNext() (models.WebhookDelivery, error) {
+    ctx := context.Background()
+    now := time.Now()
+
+    var delivery models.WebhookDelivery
+    err := config.DB.Collection("webhook_deliveries").FindOneAndUpdate(ctx, bson.M{
+        "status": models.DeliveryPending,
+        "nextAttemptAt": bson.M{"$lte": now},
+        "$or": []bson.M{
+            {"lockedUntil": nil},
+            {"lockedUntil": bson.M{"$lt": now}},
+        },
+    }, bson.M{
+        "$set": bson.M{"lockedUntil": now.Add(time.Minute)},
+    }, options.FindOneAndUpdate().
+        SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}).
+        SetReturnDocument(options.After)).Decode(&delivery)
+    return delivery, err
+}
+
+func (s *WebhookService) attempt(delivery models.WebhookDelivery) {
+    ctx := context.Background()
+
+    var webhook models.Webhook
+    err := config.DB.Collection("webhooks").FindOne(ctx, bson.M{"_id": delivery.WebhookID}).Decode(&webhook)
+    if err != nil {
+        s.recordResult(delivery, 0, errors.New("webhook no longer exists"), true)
+        return
+    }
+
+    status, err := s.post(webhook, delivery)
+    s.recordResult(delivery, status, err, false)
+}
+
+func (s *WebhookService) post(webhook models.Webhook, delivery models.WebhookDelivery) (int, error) {
+    body := []byte(delivery.Payload)
+    req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+    if err != nil {
+        return 0, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-Webhook-Event", delivery.Event)
+    req.Header.Set("X-Webhook-Delivery", delivery.ID.Hex())
+    req.Header.Set("X-Webhook-Signature", SignPayload(webhook.Secret, body))
+
+    resp, err := s.client.Do(req)
+    if errors.Is(err, ErrWebhookAddressNotAllowed) {
+        return 0, ErrWebhookAddressNotAllowed
+    }
+    if err != nil {
+        return 0, err
+    }
+    defer resp.Body.Close()
+    io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return resp.StatusCode, fmt.Errorf("receiver responded with status %d", resp.StatusCode)
+    }
+    return resp.StatusCode, nil
+}
+
+// recordResult stores the outcome of a delivery attempt
+func (s *WebhookService) recordResult(delivery models.WebhookDelivery, status int, deliveryErr error, giveUp bool) {
+    ctx := context.Background()
+
+    fields := s.resultFields(delivery, status, deliveryErr, giveUp, time.Now())
+    _, err := config.DB.Collection("webhook_deliveries").UpdateOne(ctx, bson.M{"_id": delivery.ID}, bson.M{
+        "$set": fields,
+        "$unset": bson.M{"lockedUntil": ""},
+    })
+    if err != nil {
+        log.Println("Failed to record webhook delivery", delivery.ID.Hex(), err)
+    }
+}
+
+// resultFields marks a delivery delivered, schedules a retry with exponential
+// backoff, or moves it to the dead letters once attempts are exhausted
+func (s *WebhookService) resultFields(delivery models.WebhookDelivery, status int, deliveryErr error, giveUp bool, now time.Time) bson.M {
+    attempts := delivery.Attempts + 1
+
+    fields := bson.M{"attempts": attempts, "responseStatus": status}
+    switch {
+    case deliveryErr == nil:
+        fields["status"] = models.DeliveryDelivered
+        fields["deliveredAt"] = now
+    case giveUp || attempts >= s.maxAttempts:
+        fields["status"] = models.DeliveryDead
+        fields["lastError"] = deliveryErr.Error()
+    default:
+        fields["lastError"] = deliveryErr.Error()
+        fields["nextAttemptAt"] = now.Add(s.retryBase * time.Duration(1<<uint(attempts-1)))
+    }
+    return fields
+}
+
+func (s *WebhookService) findDeliveries(filter bson.M) ([]models.WebhookDelivery, error) {
+    ctx := context.Background()
+
+    opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(100)
+    cursor, err := config.DB.Collection("webhook_deliveries").Find(ctx, filter, opts)
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+
+    deliveries := []models.WebhookDelivery{}
+    if err = cursor.All(ctx, &deliveries); err != nil {
+        return nil, err
+    }
+    return deliveries, nil
+}
+
+func isWebhookEvent(event string) bool {
+    for _, known := range models.WebhookEvents {
+        if known == event {
+            return true
+        }
+    }
+    return false
+}
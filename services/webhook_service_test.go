@@ -0,0 +1,181 @@
+package services
+
+import (
+    "errors"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+    "notes-app/models"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSignPayload(t *testing.T) {
+    got := SignPayload("secret", []byte(`{"event":"note.created"}`))
+    want := "sha256=3d565b4d500d2bfec099463976a887178a81e45f976234a74fb0daea4f029659"
+    if got != want {
+        t.Errorf("SignPayload = %s, want %s", got, want)
+    }
+}
+
+func TestWebhookPostSignsRequest(t *testing.T) {
+    var received *http.Request
+    var body []byte
+    receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        received = r
+        body, _ = io.ReadAll(r.Body)
+        w.WriteHeader(http.StatusNoContent)
+    }))
+    defer receiver.Close()
+
+    service := &WebhookService{client: receiver.Client()}
+    webhook := models.Webhook{URL: receiver.URL, Secret: "s3cret"}
+    delivery := models.WebhookDelivery{ID: primitive.NewObjectID(), Event: models.WebhookNoteCreated, Payload: `{"event":"note.created"}`}
+
+    status, err := service.post(webhook, delivery)
+    if err != nil || status != http.StatusNoContent {
+        t.Fatalf("post = %d, %v", status, err)
+    }
+    if string(body) != delivery.Payload {
+        t.Errorf("receiver got body %q", body)
+    }
+    if got := received.Header.Get("X-Webhook-Signature"); got != SignPayload("s3cret", body) {
+        t.Errorf("signature %q doesn't match the body", got)
+    }
+    if got := received.Header.Get("X-Webhook-Event"); got != models.WebhookNoteCreated {
+        t.Errorf("X-Webhook-Event = %q", got)
+    }
+    if got := received.Header.Get("X-Webhook-Delivery"); got != delivery.ID.Hex() {
+        t.Errorf("X-Webhook-Delivery = %q", got)
+    }
+}
+
+func TestWebhookPostFailsOnErrorStatus(t *testing.T) {
+    receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusServiceUnavailable)
+    }))
+    defer receiver.Close()
+
+    service := &WebhookService{client: receiver.Client()}
+    status, err := service.post(models.Webhook{URL: receiver.URL}, models.WebhookDelivery{Payload: "{}"})
+    if err == nil || status != http.StatusServiceUnavailable {
+        t.Errorf("post = %d, %v, want a failure with status 503", status, err)
+    }
+}
+
+func TestWebhookClientRefusesInternalAddresses(t *testing.T) {
+    t.Setenv("WEBHOOK_ALLOW_PRIVATE_URLS", "")
+    hit := false
+    receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        hit = true
+    }))
+    defer receiver.Close()
+
+    service := &WebhookService{client: NewWebhookClient(5 * time.Second)}
+    _, err := service.post(models.Webhook{URL: receiver.URL}, models.WebhookDelivery{Payload: "{}"})
+    if !errors.Is(err, ErrWebhookAddressNotAllowed) {
+        t.Errorf("post to loopback = %v, want ErrWebhookAddressNotAllowed", err)
+    }
+    if hit {
+        t.Error("the loopback receiver was reached")
+    }
+}
+
+func TestWebhookClientDoesNotFollowRedirects(t *testing.T) {
+    t.Setenv("WEBHOOK_ALLOW_PRIVATE_URLS", "true")
+    redirected := false
+    receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/internal" {
+            redirected = true
+            return
+        }
+        http.Redirect(w, r, "/internal", http.StatusTemporaryRedirect)
+    }))
+    defer receiver.Close()
+
+    service := &WebhookService{client: NewWebhookClient(5 * time.Second)}
+    status, err := service.post(models.Webhook{URL: receiver.URL}, models.WebhookDelivery{Payload: "{}"})
+    if err == nil || status != http.StatusTemporaryRedirect {
+        t.Errorf("post = %d, %v, want a failed delivery with status 307", status, err)
+    }
+    if redirected {
+        t.Error("the redirect was followed")
+    }
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+    tests := []struct {
+        url     string
+        allowed bool
+    }{
+        {"https://93.184.216.34/hook", true},
+        {"http://93.184.216.34/hook", false},
+        {"ftp://93.184.216.34/hook", false},
+        {"https://127.0.0.1/hook", false},
+        {"https://localhost/hook", false},
+        {"https://[::1]/hook", false},
+        {"https://0.0.0.0/hook", false},
+        {"https://10.1.2.3/hook", false},
+        {"https://172.16.0.1/hook", false},
+        {"https://192.168.1.1/hook", false},
+        {"https://169.254.169.254/latest/meta-data", false},
+        {"https://100.64.0.1/hook", false},
+        {"https://[fe80::1]/hook", false},
+        {"https://[fd00::1]/hook", false},
+        {"https://[::ffff:127.0.0.1]/hook", false},
+    }
+    for _, test := range tests {
+        err := validateWebhookURL(test.url, false)
+        if test.allowed && err != nil {
+            t.Errorf("%s: unexpected error %v", test.url, err)
+        }
+        if !test.allowed && err == nil {
+            t.Errorf("%s: allowed, want an error", test.url)
+        }
+    }
+
+    if err := validateWebhookURL("http://127.0.0.1:8080/hook", true); err != nil {
+        t.Errorf("private URLs refused although allowed: %v", err)
+    }
+}
+
+func TestWebhookResultRetriesWithBackoff(t *testing.T) {
+    service := &WebhookService{maxAttempts: 4, retryBase: 30 * time.Second}
+    now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+    failure := errors.New("receiver responded with status 500")
+
+    for attempts, wait := range []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute} {
+        fields := service.resultFields(models.WebhookDelivery{Attempts: attempts}, 500, failure, false, now)
+        if fields["status"] != nil {
+            t.Errorf("attempt %d: status %v, want it to stay pending", attempts+1, fields["status"])
+        }
+        if fields["nextAttemptAt"] != now.Add(wait) {
+            t.Errorf("attempt %d: next attempt at %v, want %v", attempts+1, fields["nextAttemptAt"], now.Add(wait))
+        }
+        if fields["attempts"] != attempts+1 || fields["lastError"] != failure.Error() {
+            t.Errorf("attempt %d: recorded %v", attempts+1, fields)
+        }
+    }
+}
+
+func TestWebhookResultDeadLetters(t *testing.T) {
+    service := &WebhookService{maxAttempts: 4, retryBase: 30 * time.Second}
+    now := time.Now()
+    failure := errors.New("connection refused")
+
+    fields := service.resultFields(models.WebhookDelivery{Attempts: 3}, 0, failure, false, now)
+    if fields["status"] != models.DeliveryDead || fields["nextAttemptAt"] != nil {
+        t.Errorf("last attempt recorded %v, want a dead letter", fields)
+    }
+
+    fields = service.resultFields(models.WebhookDelivery{}, 0, errors.New("webhook no longer exists"), true, now)
+    if fields["status"] != models.DeliveryDead {
+        t.Errorf("giving up recorded %v, want a dead letter", fields)
+    }
+
+    fields = service.resultFields(models.WebhookDelivery{Attempts: 2}, 200, nil, false, now)
+    if fields["status"] != models.DeliveryDelivered || fields["deliveredAt"] != now {
+        t.Errorf("success recorded %v", fields)
+    }
+}